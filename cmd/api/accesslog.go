@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/tomasen/realip"
+	"greenlight.samedarslan28.net/internal/jsonlog"
+)
+
+// accessLogEntry is placed in the request context by metrics so that
+// middleware further down the chain, such as authenticate, can fill in what
+// only they know.
+type accessLogEntry struct {
+	userID int64
+}
+
+func contextWithAccessLogEntry(ctx context.Context, entry *accessLogEntry) context.Context {
+	return context.WithValue(ctx, accessLogContextKey, entry)
+}
+
+// logAccess writes the access log line for a finished request. Successful
+// responses are sampled at the configured rate; every other response is
+// logged.
+func (app *application) logAccess(r *http.Request, entry *accessLogEntry, m httpsnoop.Metrics) {
+	cfg := app.config.accessLog
+	if !cfg.enabled {
+		return
+	}
+	if m.Code >= 200 && m.Code < 300 && cfg.sampleRate < 1 && rand.Float64() >= cfg.sampleRate {
+		return
+	}
+
+	route := routeFromContext(r.Context())
+	if route == "" {
+		route = "unmatched"
+	}
+	fields := jsonlog.Fields{
+		"method":      r.Method,
+		"route":       route,
+		"status":      m.Code,
+		"bytes":       m.Written,
+		"duration_ms": float64(m.Duration.Microseconds()) / 1000,
+		"client_ip":   realip.FromRequest(r),
+	}
+	if r.URL.RawQuery != "" {
+		fields["query"] = redactQuery(r.URL.Query(), cfg.redact)
+	}
+	if entry.userID != 0 {
+		fields["user_id"] = entry.userID
+	}
+	app.logger.Log(r.Context(), jsonlog.LevelInfo, "request", fields)
+}
+
+// redactQuery encodes query with the values of the named parameters, which
+// are matched case-insensitively, replaced by REDACTED.
+func redactQuery(query url.Values, redact []string) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		sensitive := false
+		for _, name := range redact {
+			if strings.EqualFold(key, name) {
+				sensitive = true
+				break
+			}
+		}
+		for _, value := range query[key] {
+			if b.Len() > 0 {
+				b.WriteByte('&')
+			}
+			if sensitive {
+				value = "REDACTED"
+			}
+			b.WriteString(url.QueryEscape(key))
+			b.WriteByte('=')
+			b.WriteString(url.QueryEscape(value))
+		}
+	}
+	return b.String()
+}
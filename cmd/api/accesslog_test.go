@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"greenlight.samedarslan28.net/internal/jsonlog"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	app := &application{logger: jsonlog.NewLogger(&buf, jsonlog.LevelInfo)}
+	app.logger.SetContextFunc(func(ctx context.Context) map[string]string {
+		return map[string]string{"request_id": requestIDFromContext(ctx)}
+	})
+	app.config.accessLog.enabled = true
+	app.config.accessLog.sampleRate = 0
+	app.config.accessLog.redact = []string{"token"}
+	routes := app.routes()
+
+	tests := []struct {
+		target string
+		logged bool
+		want   map[string]any
+	}{
+		// Anonymous requests for movies are refused, so they are always logged.
+		{"/v1/movies?page=2&Token=s3cret", true, map[string]any{
+			"method": "GET", "route": "/v1/movies", "status": float64(401), "query": "Token=REDACTED&page=2", "request_id": "req-1",
+		}},
+		{"/v1/movies/stats", true, map[string]any{"route": "/v1/movies/stats", "status": float64(401)}},
+		{"/v1/movies/12", true, map[string]any{"route": "/v1/movies/:id"}},
+		{"/v1/nowhere", true, map[string]any{"route": "unmatched", "status": float64(404)}},
+		// A sample rate of zero drops every successful response.
+		{"/v1/healthcheck", false, nil},
+	}
+
+	for _, tt := range tests {
+		buf.Reset()
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		r.Header.Set("X-Request-ID", "req-1")
+		routes.ServeHTTP(httptest.NewRecorder(), r)
+
+		if !tt.logged {
+			if buf.Len() != 0 {
+				t.Errorf("%s: logged %s", tt.target, buf.String())
+			}
+			continue
+		}
+		var line struct {
+			Message    string         `json:"message"`
+			Properties map[string]any `json:"properties"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+			t.Fatalf("%s: %v in %q", tt.target, err, buf.String())
+		}
+		for key, want := range tt.want {
+			if got := line.Properties[key]; got != want {
+				t.Errorf("%s: %s = %v; want %v", tt.target, key, got, want)
+			}
+		}
+		if line.Properties["client_ip"] == nil || line.Properties["duration_ms"] == nil {
+			t.Errorf("%s: missing client_ip or duration_ms in %v", tt.target, line.Properties)
+		}
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	query := url.Values{"password": {"hunter2"}, "q": {"a b"}, "token": {"x", "y"}}
+	got := redactQuery(query, []string{"password", "token"})
+	want := "password=REDACTED&q=a+b&token=REDACTED&token=REDACTED"
+	if got != want {
+		t.Errorf("redactQuery = %q; want %q", got, want)
+	}
+}
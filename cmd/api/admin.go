@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight.samedarslan28.net/internal/data"
+	"greenlight.samedarslan28.net/internal/jsonlog"
+	"greenlight.samedarslan28.net/internal/validator"
+)
+
+// runAdmin implements the "admin <group> <action>" commands. Every
+// successful action writes an audit line to the log.
+func runAdmin(logger *jsonlog.Logger, models data.Models, args []string) error {
+	if len(args) < 2 {
+		return errors.New("admin: expected user, perm or tokens followed by an action")
+	}
+
+	name := args[0] + " " + args[1]
+	fs := flag.NewFlagSet("admin "+name, flag.ContinueOnError)
+	email := fs.String("email", "", "User email address")
+
+	var action func(ctx context.Context) (map[string]string, error)
+	switch name {
+	case "user create":
+		userName := fs.String("name", "", "User name")
+		password := fs.String("password", "", "User password; see -password-file")
+		passwordFile := fs.String("password-file", "", "File whose first line is the password")
+		activated := fs.Bool("activated", false, "Create the user already activated")
+		action = func(ctx context.Context) (map[string]string, error) {
+			pw, err := readPassword(*password, *passwordFile, os.Stdin)
+			if err != nil {
+				return nil, err
+			}
+			return adminCreateUser(ctx, models, *userName, *email, pw, *activated)
+		}
+	case "user activate":
+		action = func(ctx context.Context) (map[string]string, error) {
+			return adminActivateUser(ctx, models, *email)
+		}
+	case "user reset-password":
+		password := fs.String("password", "", "New password; see -password-file")
+		passwordFile := fs.String("password-file", "", "File whose first line is the new password")
+		action = func(ctx context.Context) (map[string]string, error) {
+			pw, err := readPassword(*password, *passwordFile, os.Stdin)
+			if err != nil {
+				return nil, err
+			}
+			return adminResetPassword(ctx, models, *email, pw)
+		}
+	case "perm grant", "perm revoke":
+		action = func(ctx context.Context) (map[string]string, error) {
+			return adminChangePermissions(ctx, models, *email, fs.Args(), args[1] == "grant")
+		}
+	case "perm list":
+		action = func(ctx context.Context) (map[string]string, error) {
+			return adminListPermissions(ctx, models, *email)
+		}
+	case "tokens revoke":
+		scope := fs.String("scope", data.ScopeAuthentication, "Token scope to revoke (activation or authentication)")
+		action = func(ctx context.Context) (map[string]string, error) {
+			return adminRevokeTokens(ctx, models, *email, *scope)
+		}
+	default:
+		return fmt.Errorf("admin: unknown command %q", name)
+	}
+
+	if err := fs.Parse(args[2:]); err != nil {
+		return err
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, *email); !v.Valid() {
+		return fmt.Errorf("admin %s: %w", name, validationError(v))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	properties, err := action(ctx)
+	if err != nil {
+		return fmt.Errorf("admin %s: %w", name, err)
+	}
+
+	properties["action"] = args[0] + "." + args[1]
+	properties["actor"] = adminActor()
+	logger.PrintInfo("admin action", properties)
+	return nil
+}
+
+// adminPasswordEnv names the environment variable that supplies the password
+// for admin user create and reset-password.
+const adminPasswordEnv = envPrefix + "ADMIN_PASSWORD"
+
+// readPassword returns the password given with -password or, so that it need
+// not appear in the process list or shell history, the first line of
+// -password-file, the value of GREENLIGHT_ADMIN_PASSWORD or the first line
+// of stdin, in that order. A terminal on stdin is prompted.
+func readPassword(password, file string, stdin io.Reader) (string, error) {
+	switch {
+	case password != "":
+		return password, nil
+	case file != "":
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		return firstLine(f)
+	}
+	if password, ok := os.LookupEnv(adminPasswordEnv); ok {
+		return password, nil
+	}
+	if f, ok := stdin.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			fmt.Fprint(os.Stderr, "Password: ")
+		}
+	}
+	return firstLine(stdin)
+}
+
+// firstLine reads r up to the first newline, which is dropped along with a
+// preceding carriage return.
+func firstLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// adminActor names the operating system user running the command.
+func adminActor() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+func validationError(v *validator.Validator) error {
+	problems := make([]string, 0, len(v.Errors))
+	for key, message := range v.Errors {
+		problems = append(problems, key+" "+message)
+	}
+	return errors.New(strings.Join(problems, "; "))
+}
+
+func adminGetUser(ctx context.Context, m data.Models, email string) (*data.User, error) {
+	u, err := m.Users.GetByEmail(data.UsePrimary(ctx), email)
+	if errors.Is(err, data.ErrRecordNotFound) {
+		return nil, fmt.Errorf("no user with email %q", email)
+	}
+	return u, err
+}
+
+func userProperties(u *data.User) map[string]string {
+	return map[string]string{
+		"user_id": strconv.FormatInt(u.ID, 10),
+		"email":   u.Email,
+	}
+}
+
+func adminCreateUser(ctx context.Context, m data.Models, name, email, password string, activated bool) (map[string]string, error) {
+	u := &data.User{Name: name, Email: email, Activated: activated}
+	err := u.Password.Set(password)
+	if err != nil {
+		return nil, err
+	}
+
+	v := validator.New()
+	if data.ValidateUser(v, u); !v.Valid() {
+		return nil, validationError(v)
+	}
+
+	err = m.WithTx(ctx, func(m data.Models) error {
+		if err := m.Users.Insert(ctx, u); err != nil {
+			return err
+		}
+		return m.Permissions.AddForUser(ctx, u.ID, "movies:read")
+	})
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateEmail) {
+			return nil, fmt.Errorf("a user with email %q already exists", email)
+		}
+		return nil, err
+	}
+
+	fmt.Printf("created user %d (%s)\n", u.ID, u.Email)
+	properties := userProperties(u)
+	properties["activated"] = strconv.FormatBool(activated)
+	return properties, nil
+}
+
+func adminActivateUser(ctx context.Context, m data.Models, email string) (map[string]string, error) {
+	var u *data.User
+	err := m.WithTx(ctx, func(m data.Models) error {
+		var err error
+		u, err = adminGetUser(ctx, m, email)
+		if err != nil {
+			return err
+		}
+		u.Activated = true
+		if err := m.Users.Update(ctx, u); err != nil {
+			return err
+		}
+		return m.Tokens.DeleDeleteAllForUser(ctx, data.ScopeActivation, u.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("activated user %d (%s)\n", u.ID, u.Email)
+	return userProperties(u), nil
+}
+
+// adminResetPassword sets a new password and signs the user out everywhere
+// by revoking their authentication tokens.
+func adminResetPassword(ctx context.Context, m data.Models, email, password string) (map[string]string, error) {
+	v := validator.New()
+	if data.ValidatePasswordPlaintext(v, password); !v.Valid() {
+		return nil, validationError(v)
+	}
+
+	var u *data.User
+	err := m.WithTx(ctx, func(m data.Models) error {
+		var err error
+		u, err = adminGetUser(ctx, m, email)
+		if err != nil {
+			return err
+		}
+		if err := u.Password.Set(password); err != nil {
+			return err
+		}
+		if err := m.Users.Update(ctx, u); err != nil {
+			return err
+		}
+		return m.Tokens.DeleDeleteAllForUser(ctx, data.ScopeAuthentication, u.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("reset password for user %d (%s)\n", u.ID, u.Email)
+	properties := userProperties(u)
+	properties["revoked_scope"] = data.ScopeAuthentication
+	return properties, nil
+}
+
+func adminChangePermissions(ctx context.Context, m data.Models, email string, codes []string, grant bool) (map[string]string, error) {
+	if len(codes) == 0 {
+		return nil, errors.New("expected at least one permission code")
+	}
+
+	known, err := m.Permissions.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		if !known.Include(code) {
+			return nil, fmt.Errorf("unknown permission code %q (known: %s)", code, strings.Join(known, ", "))
+		}
+	}
+
+	u, err := adminGetUser(ctx, m, email)
+	if err != nil {
+		return nil, err
+	}
+
+	verb := "granted"
+	if grant {
+		err = m.Permissions.AddForUser(ctx, u.ID, codes...)
+	} else {
+		verb = "revoked"
+		err = m.Permissions.RemoveForUser(ctx, u.ID, codes...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("%s %s for user %d (%s)\n", verb, strings.Join(codes, ", "), u.ID, u.Email)
+	properties := userProperties(u)
+	properties["codes"] = strings.Join(codes, ",")
+	return properties, nil
+}
+
+func adminListPermissions(ctx context.Context, m data.Models, email string) (map[string]string, error) {
+	u, err := adminGetUser(ctx, m, email)
+	if err != nil {
+		return nil, err
+	}
+
+	perms, err := m.Permissions.GetAllForUser(data.UsePrimary(ctx), u.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, code := range perms {
+		fmt.Println(code)
+	}
+	return userProperties(u), nil
+}
+
+func adminRevokeTokens(ctx context.Context, m data.Models, email, scope string) (map[string]string, error) {
+	v := validator.New()
+	v.CheckCode(validator.In(scope, data.ScopeActivation, data.ScopeAuthentication), "scope", validator.CodeNotAllowed, "must be activation or authentication")
+	if !v.Valid() {
+		return nil, validationError(v)
+	}
+
+	u, err := adminGetUser(ctx, m, email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Tokens.DeleDeleteAllForUser(ctx, scope, u.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("revoked %s tokens for user %d (%s)\n", scope, u.ID, u.Email)
+	properties := userProperties(u)
+	properties["scope"] = scope
+	return properties, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"greenlight.samedarslan28.net/internal/data"
+	"greenlight.samedarslan28.net/internal/jsonlog"
+)
+
+// The cases below fail before any query runs, so the models are never used.
+func TestRunAdminRejectsBadInput(t *testing.T) {
+	logger := jsonlog.NewLogger(io.Discard, jsonlog.LevelInfo)
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"missing action", []string{"user"}},
+		{"unknown command", []string{"user", "delete", "-email", "a@example.com"}},
+		{"invalid email", []string{"user", "activate", "-email", "nope"}},
+		{"short password", []string{"user", "reset-password", "-email", "a@example.com", "-password", "short"}},
+		{"no codes", []string{"perm", "grant", "-email", "a@example.com"}},
+		{"bad scope", []string{"tokens", "revoke", "-email", "a@example.com", "-scope", "refresh"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := runAdmin(logger, data.Models{}, tt.args); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestReadPassword(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(file, []byte("from-file\r\nignored\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	stdin := func() io.Reader { return strings.NewReader("from-stdin\n") }
+
+	tests := []struct {
+		name, flag, file, env, want string
+	}{
+		{"flag", "from-flag", file, "from-env", "from-flag"},
+		{"file", "", file, "from-env", "from-file"},
+		{"env", "", "", "from-env", "from-env"},
+		{"stdin", "", "", "", "from-stdin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv(adminPasswordEnv, tt.env)
+			}
+			got, err := readPassword(tt.flag, tt.file, stdin())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"greenlight.samedarslan28.net/internal/data"
+	"greenlight.samedarslan28.net/internal/jsonlog"
+)
+
+const commandUsage = `Usage: api [flags] <command> [arguments]
+
+Without a command, api starts the HTTP server.
+
+Commands:
+  migrate up        apply every pending migration
+  migrate down      revert the most recent migration
+  migrate status    show the current and latest schema version
+  migrate goto N    migrate up or down to version N
+  seed              load development fixtures
+      -file path    fixture file (.yaml, .yml or .json)
+      -random N     also generate N random movies
+  admin user create -name NAME -email EMAIL [-password PASSWORD] [-activated]
+  admin user activate -email EMAIL
+  admin user reset-password -email EMAIL [-password PASSWORD]
+                    set a new password and revoke authentication tokens;
+                    without -password, user create and reset-password read
+                    the first line of -password-file, GREENLIGHT_ADMIN_PASSWORD
+                    or the first line of stdin
+  admin perm grant -email EMAIL CODE...
+  admin perm revoke -email EMAIL CODE...
+  admin perm list -email EMAIL
+  admin tokens revoke -email EMAIL [-scope activation|authentication]
+
+Configuration:
+  Every flag below can also be set in the YAML file named by -config or
+  GREENLIGHT_CONFIG, with nested keys joined by dashes (limiter: {rps: 5}
+  sets -limiter-rps), or in an environment variable such as
+  GREENLIGHT_LIMITER_RPS, which may also be set in a .env file. The command
+  line overrides the environment, which overrides .env, which overrides the
+  file. On SIGHUP the file and .env are read again and limiter-*, log-level
+  and cors-trusted-origins take effect without a restart.
+`
+
+// runCommand runs the command named by args[0] instead of starting the
+// server.
+func runCommand(cfg config, logger *jsonlog.Logger, db *data.Cluster, args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(logger, db.Primary, args[1:])
+	case "seed":
+		return runSeed(logger, commandModels(cfg, db), args[1:])
+	case "admin":
+		return runAdmin(logger, commandModels(cfg, db), args[1:])
+	case "help":
+		fmt.Fprint(os.Stdout, commandUsage)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, commandUsage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// commandModels returns models for commands, without the movie cache.
+func commandModels(cfg config, db *data.Cluster) data.Models {
+	return data.NewModels(db, data.Timeouts{Read: cfg.db.readTimeout, Write: cfg.db.writeTimeout}, nil)
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v2"
+	"greenlight.samedarslan28.net/internal/jsonlog"
+	"greenlight.samedarslan28.net/internal/validator"
+)
+
+// envPrefix starts the environment variable of every setting, as in
+// GREENLIGHT_LIMITER_RPS for -limiter-rps.
+const envPrefix = "GREENLIGHT_"
+
+// legacyEnv names the environment variables that some settings were read
+// from before the GREENLIGHT_ names existed. They still work.
+var legacyEnv = map[string]string{
+	"db-dsn":        "DB_DSN",
+	"smtp-username": "SMTP_USERNAME",
+	"smtp-password": "SMTP_PASSWORD",
+}
+
+// reloadableFlags are the settings that a SIGHUP applies to the running
+// server. Other settings are read once at startup.
+var reloadableFlags = []string{
+	"limiter-enabled",
+	"limiter-rps",
+	"limiter-burst",
+	"log-level",
+	"cors-trusted-origins",
+}
+
+// errCommandLine wraps the errors of parsing the command line, which the
+// flag package has already reported along with the usage.
+var errCommandLine = errors.New("invalid command line")
+
+// commandLine holds what the command line asks for besides settings.
+type commandLine struct {
+	args       []string
+	version    bool
+	configFile string
+
+	// given are the arguments the configuration was loaded from, and
+	// layered the values taken from the config file and the environment,
+	// keyed by setting. A reload uses them to tell what changed.
+	given   []string
+	layered map[string]string
+}
+
+// loadConfig builds the configuration from, in increasing order of
+// precedence, the flag defaults, the YAML file named by -config or
+// GREENLIGHT_CONFIG, variables in an optional .env file, environment
+// variables and the command line. Every invalid value is reported in the one
+// error returned.
+func loadConfig(args []string) (config, commandLine, error) {
+	var cfg config
+	var cmdLine commandLine
+
+	// .env is read rather than loaded into the environment, so that a
+	// reload sees changes to it.
+	dotenv, err := godotenv.Read()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return cfg, cmdLine, fmt.Errorf("reading .env: %w", err)
+	}
+
+	flags := flag.NewFlagSet("api", flag.ContinueOnError)
+	defineFlags(flags, &cfg)
+	flags.StringVar(&cmdLine.configFile, "config", os.Getenv(envPrefix+"CONFIG"), "YAML file to read settings from; see Configuration above")
+	flags.BoolVar(&cmdLine.version, "version", false, "Display version and exit")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), commandUsage+"\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return cfg, cmdLine, fmt.Errorf("%w: %w", errCommandLine, err)
+	}
+	cmdLine.args = flags.Args()
+	cmdLine.given = args
+	cmdLine.layered = make(map[string]string)
+	if cmdLine.version {
+		return cfg, cmdLine, nil
+	}
+
+	v := validator.New()
+
+	var fileValues map[string]string
+	if cmdLine.configFile != "" {
+		fileValues, err = readConfigFile(cmdLine.configFile)
+		if err != nil {
+			return cfg, cmdLine, err
+		}
+		for name := range fileValues {
+			if flags.Lookup(name) == nil || name == "config" || name == "version" {
+				v.AddError(name, "is not a known setting, in "+cmdLine.configFile)
+			}
+		}
+	}
+
+	onCommandLine := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+	flags.VisitAll(func(f *flag.Flag) {
+		if onCommandLine[f.Name] || f.Name == "config" || f.Name == "version" {
+			return
+		}
+		value, source, ok := envValue(f.Name, dotenv)
+		if !ok {
+			value, ok = fileValues[f.Name]
+			source = cmdLine.configFile
+		}
+		if !ok {
+			return
+		}
+		cmdLine.layered[f.Name] = value
+		if err := flags.Set(f.Name, value); err != nil {
+			v.AddError(f.Name, fmt.Sprintf("has invalid value %q, from %s: %v", value, source, err))
+		}
+	})
+
+	cfg.validate(v, len(cmdLine.args) == 0)
+	if !v.Valid() {
+		return cfg, cmdLine, configError(v.Errors)
+	}
+	return cfg, cmdLine, nil
+}
+
+// envValue returns the value of the environment variable for the named
+// setting, looked up in the environment and then in dotenv, and the name of
+// the variable it came from.
+func envValue(name string, dotenv map[string]string) (value, source string, ok bool) {
+	names := []string{envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))}
+	if legacy, found := legacyEnv[name]; found {
+		names = append(names, legacy)
+	}
+	for _, lookup := range []func(string) (string, bool){os.LookupEnv, lookupIn(dotenv)} {
+		for _, source := range names {
+			if value, ok := lookup(source); ok {
+				return value, source, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+func lookupIn(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := vars[key]
+		return value, ok
+	}
+}
+
+// readConfigFile reads a YAML file into setting values keyed by flag name.
+// Nested keys are joined with dashes and underscores become dashes, so that
+//
+//	limiter:
+//	  rps: 5
+//
+// sets -limiter-rps. Lists become comma-separated values.
+func readConfigFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[interface{}]interface{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	flattenConfig(values, "", doc)
+	return values, nil
+}
+
+func flattenConfig(values map[string]string, prefix string, node interface{}) {
+	switch node := node.(type) {
+	case map[interface{}]interface{}:
+		for key, child := range node {
+			name := strings.ReplaceAll(fmt.Sprint(key), "_", "-")
+			if prefix != "" {
+				name = prefix + "-" + name
+			}
+			flattenConfig(values, name, child)
+		}
+	case []interface{}:
+		items := make([]string, len(node))
+		for i, item := range node {
+			items[i] = fmt.Sprint(item)
+		}
+		values[prefix] = strings.Join(items, ",")
+	case nil:
+		values[prefix] = ""
+	default:
+		values[prefix] = fmt.Sprint(node)
+	}
+}
+
+// validate checks the settings that the flag types alone do not. serving
+// is false when a command such as migrate runs instead of the server, which
+// needs no SMTP credentials.
+func (cfg *config) validate(v *validator.Validator, serving bool) {
+	v.Check(cfg.port > 0 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.In(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+
+	v.Check(cfg.db.dsn != "", "db-dsn", "must be provided")
+	v.Check(cfg.db.maxOpenConns > 0, "db-max-open-conns", "must be greater than zero")
+	v.Check(cfg.db.maxIdleConns >= 0, "db-max-idle-conns", "must not be negative")
+	_, err := time.ParseDuration(cfg.db.maxIdleTime)
+	v.Check(err == nil, "db-max-idle-time", "must be a duration such as 15m")
+	v.Check(cfg.db.readTimeout > 0, "db-read-timeout", "must be greater than zero")
+	v.Check(cfg.db.writeTimeout > 0, "db-write-timeout", "must be greater than zero")
+
+	v.Check(cfg.limiter.rps > 0, "limiter-rps", "must be greater than zero")
+	v.Check(cfg.limiter.burst > 0, "limiter-burst", "must be greater than zero")
+	for _, origin := range cfg.cors.trustedOrigins {
+		v.Check(strings.HasPrefix(origin, "http://") || strings.HasPrefix(origin, "https://"), "cors-trusted-origins", "must be origins such as https://example.com")
+	}
+
+	v.Check(cfg.cache.movieEntries >= 0, "cache-movie-entries", "must not be negative")
+	v.Check(cfg.webhooks.workers >= 0, "webhook-workers", "must not be negative")
+	v.Check(cfg.webhooks.maxAttempts > 0, "webhook-max-attempts", "must be greater than zero")
+	v.Check(cfg.webhooks.timeout > 0, "webhook-timeout", "must be greater than zero")
+	v.Check(cfg.webhooks.pollInterval > 0, "webhook-poll-interval", "must be greater than zero")
+	v.Check(cfg.jobs.workers >= 0, "job-workers", "must not be negative")
+	v.Check(cfg.jobs.timeout > 0, "job-timeout", "must be greater than zero")
+	v.Check(cfg.jobs.pollInterval > 0, "job-poll-interval", "must be greater than zero")
+	v.Check(cfg.scheduler.unactivatedAge > 0, "purge-unactivated-after-days", "must be greater than zero")
+	v.Check(cfg.graphql.maxDepth > 0, "graphql-max-depth", "must be greater than zero")
+	v.Check(cfg.graphql.maxComplexity > 0, "graphql-max-complexity", "must be greater than zero")
+	v.Check(cfg.compression.minSize >= 0, "compression-min-size", "must not be negative")
+
+	v.Check(cfg.log.stdout || cfg.log.file.path != "", "log-stdout", "must be true when no log-file is set")
+	v.Check(cfg.log.buffer > 0, "log-buffer", "must be greater than zero")
+	v.Check(cfg.accessLog.sampleRate >= 0 && cfg.accessLog.sampleRate <= 1, "access-log-sample-rate", "must be between 0 and 1")
+	v.Check(validator.In(cfg.tracing.exporter, "none", "stdout", "otlp"), "trace-exporter", "must be none, stdout or otlp")
+	v.Check(cfg.tracing.sampleRatio >= 0 && cfg.tracing.sampleRatio <= 1, "trace-sample-ratio", "must be between 0 and 1")
+	v.Check(cfg.health.timeout > 0, "health-check-timeout", "must be greater than zero")
+	v.Check(cfg.health.cacheTTL >= 0, "health-check-cache", "must not be negative")
+
+	v.Check(cfg.smtp.port > 0 && cfg.smtp.port <= 65535, "smtp-port", "must be between 1 and 65535")
+	if serving {
+		v.Check(cfg.smtp.username != "", "smtp-username", "must be provided")
+		v.Check(cfg.smtp.password != "", "smtp-password", "must be provided")
+	}
+}
+
+// configError lists every invalid setting, one per line.
+type configError map[string]string
+
+func (e configError) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("invalid configuration:")
+	for _, name := range names {
+		fmt.Fprintf(&b, "\n  %s %s", name, e[name])
+	}
+	return b.String()
+}
+
+// settings returns the configuration in effect, including the settings
+// last reloaded on SIGHUP. Code that reads a reloadable setting must go
+// through it rather than app.config, which stays as loaded at startup.
+func (app *application) settings() *config {
+	if cfg := app.live.Load(); cfg != nil {
+		return cfg
+	}
+	return &app.config
+}
+
+// reloadConfig reads the configuration again and applies the reloadable
+// settings. Changes to any other setting are reported and take effect at
+// the next restart.
+func (app *application) reloadConfig() error {
+	cfg, cmdLine, err := loadConfig(app.cmdLine.given)
+	if err != nil {
+		return err
+	}
+
+	app.reloadMu.Lock()
+	defer app.reloadMu.Unlock()
+
+	live := *app.settings()
+	live.limiter = cfg.limiter
+	live.cors = cfg.cors
+	live.log.level = cfg.log.level
+	app.live.Store(&live)
+	app.logger.SetLevel(cfg.log.level)
+
+	// Command-line values cannot change, so only the layered values are
+	// compared.
+	changed := make(map[string]bool)
+	for name, value := range cmdLine.layered {
+		if old, ok := app.cmdLine.layered[name]; !ok || old != value {
+			changed[name] = true
+		}
+	}
+	for name := range app.cmdLine.layered {
+		if _, ok := cmdLine.layered[name]; !ok {
+			changed[name] = true
+		}
+	}
+	var reloaded, needRestart []string
+	for name := range changed {
+		if slices.Contains(reloadableFlags, name) {
+			reloaded = append(reloaded, name)
+		} else {
+			needRestart = append(needRestart, name)
+		}
+	}
+	sort.Strings(reloaded)
+	sort.Strings(needRestart)
+	app.cmdLine.layered = cmdLine.layered
+
+	app.logger.Info("reloaded configuration", jsonlog.Fields{"changed": strings.Join(reloaded, ",")})
+	if len(needRestart) > 0 {
+		app.logger.Warn("some changed settings take effect only after a restart", jsonlog.Fields{
+			"settings": strings.Join(needRestart, ","),
+		})
+	}
+	return nil
+}
+
+// handleHangup reloads the configuration and rotates the log file whenever
+// the process receives SIGHUP, until ctx is cancelled.
+func (app *application) handleHangup(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := app.reloadConfig(); err != nil {
+				app.logger.PrintError(err, map[string]string{"task": "reload configuration"})
+			}
+			app.rotateLogs()
+		}
+	}
+}
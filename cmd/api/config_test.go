@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"greenlight.samedarslan28.net/internal/jsonlog"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "api.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+port: 5000
+limiter:
+  rps: 5
+  burst: 7
+cors_trusted_origins:
+  - https://a.example
+  - https://b.example
+db-dsn: postgres://from-file
+`)
+	t.Setenv("DB_DSN", "postgres://from-legacy-env")
+	t.Setenv("GREENLIGHT_LIMITER_BURST", "9")
+	t.Setenv("GREENLIGHT_SMTP_USERNAME", "user")
+	t.Setenv("GREENLIGHT_SMTP_PASSWORD", "secret")
+
+	cfg, _, err := loadConfig([]string{"-config", path, "-limiter-rps", "3", "-access-log-redact", "token, password,"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.port != 5000 {
+		t.Errorf("port = %d; want 5000 from the file", cfg.port)
+	}
+	if cfg.limiter.rps != 3 {
+		t.Errorf("limiter rps = %v; want 3 from the command line", cfg.limiter.rps)
+	}
+	if cfg.limiter.burst != 9 {
+		t.Errorf("limiter burst = %d; want 9 from the environment", cfg.limiter.burst)
+	}
+	if cfg.db.dsn != "postgres://from-legacy-env" {
+		t.Errorf("db dsn = %q; want the DB_DSN value", cfg.db.dsn)
+	}
+	if got := strings.Join(cfg.cors.trustedOrigins, " "); got != "https://a.example https://b.example" {
+		t.Errorf("trusted origins = %q", got)
+	}
+	if cfg.jobs.workers != 4 {
+		t.Errorf("job workers = %d; want the default 4", cfg.jobs.workers)
+	}
+	if got := strings.Join(cfg.accessLog.redact, " "); got != "token password" {
+		t.Errorf("redacted parameters = %q; want token and password", got)
+	}
+}
+
+func TestLoadConfigReportsEveryProblem(t *testing.T) {
+	path := writeConfigFile(t, `
+port: 70000
+log-level: loud
+limitr:
+  rps: 5
+`)
+	_, _, err := loadConfig([]string{"-config", path, "-trace-sample-ratio", "2", "-job-timeout", "0s", "-purge-unactivated-after-days", "0"})
+	if err == nil {
+		t.Fatal("invalid configuration was accepted")
+	}
+	for _, name := range []string{"port", "log-level", "limitr-rps", "trace-sample-ratio", "job-timeout", "purge-unactivated-after-days", "db-dsn", "smtp-username", "smtp-password"} {
+		if !strings.Contains(err.Error(), "\n  "+name+" ") {
+			t.Errorf("error does not mention %s:\n%s", name, err)
+		}
+	}
+
+	// Commands such as migrate do not send mail.
+	_, _, err = loadConfig([]string{"-db-dsn", "postgres://db", "migrate", "status"})
+	if err != nil {
+		t.Errorf("migrate without SMTP settings: %v", err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	path := writeConfigFile(t, "limiter: {rps: 5, burst: 7}\nport: 5000\n")
+	args := []string{"-config", path, "-db-dsn", "postgres://db", "-smtp-username", "u", "-smtp-password", "p"}
+	cfg, cmdLine, err := loadConfig(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	app := &application{config: cfg, cmdLine: cmdLine, logger: jsonlog.NewLogger(&logs, jsonlog.LevelInfo)}
+
+	err = os.WriteFile(path, []byte("limiter: {rps: 50, burst: 70}\nport: 6000\nlog-level: warn\ncors-trusted-origins: https://a.example\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.reloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	live := app.settings()
+	if live.limiter.rps != 50 || live.limiter.burst != 70 {
+		t.Errorf("limiter = %+v; want the reloaded limits", live.limiter)
+	}
+	if len(live.cors.trustedOrigins) != 1 {
+		t.Errorf("trusted origins = %v; want the reloaded origin", live.cors.trustedOrigins)
+	}
+	if live.port != 5000 {
+		t.Errorf("port = %d; want 5000 until a restart", live.port)
+	}
+	if app.logger.Enabled(jsonlog.LevelInfo) {
+		t.Error("log level was not reloaded")
+	}
+	if !strings.Contains(logs.String(), `"settings":"port"`) {
+		t.Errorf("the port change was not reported:\n%s", logs.String())
+	}
+
+	// A second reload compares against the first one, not the startup values.
+	logs.Reset()
+	if err := app.reloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(logs.String(), `"settings"`) {
+		t.Errorf("an unchanged file was reported as changed:\n%s", logs.String())
+	}
+}
+
+func TestTrustedOrigins(t *testing.T) {
+	app := &application{logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelInfo)}
+	app.config.cors.trustedOrigins = []string{"https://a.example"}
+	handler := app.enableCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for origin, want := range map[string]string{
+		"https://a.example": "https://a.example",
+		"https://b.example": "",
+		"":                  "",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r)
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != want {
+			t.Errorf("Origin %q: Access-Control-Allow-Origin = %q; want %q", origin, got, want)
+		}
+	}
+}
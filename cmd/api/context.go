@@ -9,17 +9,67 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey      = contextKey("user")
+	routeContextKey     = contextKey("route")
+	accessLogContextKey = contextKey("access_log")
+)
 
+// contextSetUser also records the user for the access log line of the
+// request, which is written by middleware that runs before authentication.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if entry, ok := r.Context().Value(accessLogContextKey).(*accessLogEntry); ok && !user.IsAnonymous() {
+		entry.userID = user.ID
+	}
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
 
 func (app *application) contextGetUser(r *http.Request) *data.User {
-	user, ok := r.Context().Value(userContextKey).(*data.User)
+	return userFromContext(r.Context())
+}
+
+// contextSetRequestID records the request ID with data.WithRequestID, so that
+// jobs queued while handling the request carry it too.
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := data.WithRequestID(r.Context(), id)
+	return r.WithContext(ctx)
+}
+
+// requestIDFromContext returns the ID of the request ctx belongs to, or an
+// empty string for work that did not start from a request. Jobs run with
+// the ID of the request that queued them.
+func requestIDFromContext(ctx context.Context) string {
+	return data.RequestID(ctx)
+}
+
+// userFromContext returns the user set on the request ctx belongs to, for
+// code that only has the context, such as GraphQL resolvers.
+func userFromContext(ctx context.Context) *data.User {
+	user, ok := ctx.Value(userContextKey).(*data.User)
 	if !ok {
 		panic("missing user value in request context")
 	}
 	return user
 }
+
+// withRoute records the route pattern a handler is registered under, such
+// as /v1/movies/:id, so that requests can be logged by route rather than by
+// their raw URL.
+func withRoute(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, contextSetRoute(r, pattern))
+	})
+}
+
+func contextSetRoute(r *http.Request, pattern string) *http.Request {
+	ctx := context.WithValue(r.Context(), routeContextKey, pattern)
+	return r.WithContext(ctx)
+}
+
+// routeFromContext returns the route pattern of the request ctx belongs to,
+// or an empty string for requests that matched no route.
+func routeFromContext(ctx context.Context) string {
+	route, _ := ctx.Value(routeContextKey).(string)
+	return route
+}
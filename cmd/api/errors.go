@@ -1,83 +1,226 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+
+	"greenlight.samedarslan28.net/internal/data"
+	"greenlight.samedarslan28.net/internal/render"
+	"greenlight.samedarslan28.net/internal/validator"
 )
 
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
+	app.logger.PrintErrorContext(r.Context(), err, map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
 	})
 }
 
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
-	env := envelope{"error": message}
-	err := app.writeJSON(w, status, env, nil)
+// Error codes identify each kind of error response. They are part of the
+// API: clients match on them, so they must not change once published.
+const (
+	errCodeServerError            = "server_error"
+	errCodeRequestCanceled        = "request_canceled"
+	errCodeQueryTimeout           = "query_timeout"
+	errCodeNotFound               = "not_found"
+	errCodeMethodNotAllowed       = "method_not_allowed"
+	errCodeBadRequest             = "bad_request"
+	errCodeValidationFailed       = "validation_failed"
+	errCodeEditConflict           = "edit_conflict"
+	errCodeRateLimitExceeded      = "rate_limit_exceeded"
+	errCodeInvalidCredentials     = "invalid_credentials"
+	errCodeInvalidToken           = "invalid_token"
+	errCodeAuthenticationRequired = "authentication_required"
+	errCodeInactiveAccount        = "inactive_account"
+	errCodeNotPermitted           = "not_permitted"
+	errCodeNotAcceptable          = "not_acceptable"
+	errCodeShuttingDown           = "shutting_down"
+	errCodeJobRunning             = "job_running"
+	errCodeDeliveryInFlight       = "delivery_in_flight"
+)
+
+// problemTypeBase prefixes error codes to form problem type URIs. The URIs
+// identify the problem; they are not guaranteed to resolve.
+const problemTypeBase = "https://greenlight.abdulsamedarslan.net/problems/"
+
+// problem is an RFC 9457 problem details object, extended with the error
+// code and, for validation failures, the fields that failed.
+type problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []fieldError `json:"errors,omitempty"`
+}
+
+// fieldError describes one invalid field of a request.
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorResponse sends message in the negotiated format. message is a string,
+// or the failed validator for validation failures.
+//
+// Clients that name application/problem+json in their Accept header get
+// problem details. Everyone else gets the original {"error": message}
+// envelope, in JSON for clients that asked for CSV, since an error is not a
+// table. Both carry the request ID, for matching a report with the logs.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code string, message interface{}) {
+	w.Header().Add("Vary", "Accept")
+
+	var err error
+	if render.Accepts(r.Header.Get("Accept"), render.ProblemJSON.MediaTypes[0]) {
+		err = app.writeEncoded(w, render.ProblemJSON, status, newProblem(r, status, code, message), nil)
+	} else {
+		enc, negotiateErr := responseEncoders.Negotiate(r.Header.Get("Accept"))
+		if negotiateErr != nil || enc.Tabular {
+			enc = responseEncoders.Default()
+		}
+		if v, ok := message.(*validator.Validator); ok {
+			message = v.Errors
+		}
+		env := envelope{"error": message}
+		if id := requestIDFromContext(r.Context()); id != "" {
+			env["request_id"] = id
+		}
+		err = app.writeEncoded(w, enc, status, env, nil)
+	}
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
+func newProblem(r *http.Request, status int, code string, message interface{}) problem {
+	p := problem{
+		Type:      problemTypeBase + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Instance:  r.URL.RequestURI(),
+		Code:      code,
+		RequestID: requestIDFromContext(r.Context()),
+	}
+
+	switch message := message.(type) {
+	case string:
+		p.Detail = message
+	case *validator.Validator:
+		p.Detail = "one or more fields of the request are invalid"
+		p.Errors = []fieldError{}
+		for field, msg := range message.Errors {
+			p.Errors = append(p.Errors, fieldError{Field: field, Code: message.Code(field), Message: msg})
+		}
+		sort.Slice(p.Errors, func(i, j int) bool {
+			return p.Errors[i].Field < p.Errors[j].Field
+		})
+	}
+	return p
+}
+
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrQueryCanceled):
+		app.requestCanceledResponse(w, r, err)
+		return
+	case errors.Is(err, data.ErrQueryTimeout):
+		app.queryTimeoutResponse(w, r, err)
+		return
+	}
+
 	app.logError(r, err)
 	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, errCodeServerError, message)
+}
+
+// requestCanceledResponse is used when a query was abandoned because the
+// client went away or the server started shutting down. It is not a server
+// fault, so it is only logged at info level.
+func (app *application) requestCanceledResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.PrintInfoContext(r.Context(), "request canceled", map[string]string{
+		"request_method": r.Method,
+		"request_url":    r.URL.String(),
+		"error":          err.Error(),
+	})
+	message := "the request was canceled before it could be completed"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, errCodeRequestCanceled, message)
+}
+
+func (app *application) queryTimeoutResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+	message := "the server took too long to process your request, please try again"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, errCodeQueryTimeout, message)
 }
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, errCodeNotFound, message)
 }
 
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, message)
 }
 
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, errCodeValidationFailed, v)
 }
 
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
+	app.errorResponse(w, r, http.StatusConflict, errCodeEditConflict, message)
 }
 
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message :=
 		"rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	app.errorResponse(w, r, http.StatusTooManyRequests, errCodeRateLimitExceeded, message)
 }
 
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message :=
 		"invalid authentication credentials"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, errCodeInvalidCredentials, message)
 }
 
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 	message :=
 		"invalid or missing authentication token"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, errCodeInvalidToken, message)
 }
 
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message :=
 		"you must be authenticated to access this resource"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, errCodeAuthenticationRequired, message)
 }
 
 func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
 	message :=
 		"your user account must be activated to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, errCodeInactiveAccount, message)
 }
 
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message :=
 		"your user account doesn't have the necessary permissions to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, errCodeNotPermitted, message)
+}
+
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the requested resource is only available as %s", strings.Join(responseEncoders.MediaTypes(), ", "))
+	app.errorResponse(w, r, http.StatusNotAcceptable, errCodeNotAcceptable, message)
+}
+
+func (app *application) shuttingDownResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "5")
+	message := "the server is shutting down, please try again"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, errCodeShuttingDown, message)
 }
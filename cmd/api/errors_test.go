@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"greenlight.samedarslan28.net/internal/jsonlog"
+	"greenlight.samedarslan28.net/internal/validator"
+)
+
+func TestProblemDetails(t *testing.T) {
+	app := &application{logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelInfo)}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/nowhere?x=1", nil)
+	r.Header.Set("Accept", "application/problem+json")
+	r.Header.Set("X-Request-ID", "req-42")
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, r)
+
+	if got := rr.Header().Get("Content-Type"); got != "application/problem+json" {
+		t.Fatalf("Content-Type = %q; want application/problem+json", got)
+	}
+	var p problem
+	if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	want := problem{
+		Type:      problemTypeBase + errCodeNotFound,
+		Title:     "Not Found",
+		Status:    http.StatusNotFound,
+		Detail:    "the requested resource could not be found",
+		Instance:  "/v1/nowhere?x=1",
+		Code:      errCodeNotFound,
+		RequestID: "req-42",
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("got %+v; want %+v", p, want)
+	}
+
+	// Clients that don't ask for problem details keep the old envelope.
+	r = httptest.NewRequest(http.MethodGet, "/v1/nowhere", nil)
+	rr = httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, r)
+	var env map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&env); err != nil || env["error"] == "" {
+		t.Errorf("legacy error body = %v (%v)", env, err)
+	}
+}
+
+func TestProblemDetailsValidation(t *testing.T) {
+	app := &application{logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelInfo)}
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies", nil)
+	r.Header.Set("Accept", "application/json, application/problem+json")
+	rr := httptest.NewRecorder()
+	v := validator.New()
+	v.CheckCode(false, "year", validator.CodeRequired, "must be provided")
+	v.CheckCode(false, "title", validator.CodeTooLong, "must not be more than 500 bytes long")
+	app.failedValidationResponse(rr, r, v)
+
+	var p problem
+	if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != http.StatusUnprocessableEntity || p.Code != errCodeValidationFailed {
+		t.Errorf("got status %d code %q", p.Status, p.Code)
+	}
+	want := []fieldError{
+		{Field: "title", Code: "too_long", Message: "must not be more than 500 bytes long"},
+		{Field: "year", Code: "required", Message: "must be provided"},
+	}
+	if !reflect.DeepEqual(p.Errors, want) {
+		t.Errorf("errors = %+v; want %+v", p.Errors, want)
+	}
+}
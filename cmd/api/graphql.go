@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/graph-gophers/dataloader"
+	graphql "github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"greenlight.samedarslan28.net/internal/data"
+)
+
+const graphqlSchema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+scalar Time
+
+type Query {
+	# A single movie, or null if there is no movie with that ID.
+	movie(id: ID!): Movie
+	# A page of movies, filtered and sorted like GET /v1/movies.
+	movies(title: String, genres: [String!], page: Int = 1, pageSize: Int = 20, sort: String = "id"): MovieConnection!
+	# The authenticated user.
+	me: User!
+}
+
+type Mutation {
+	createMovie(input: CreateMovieInput!): Movie!
+	# Changes the given fields of a movie. When expectedVersion is set the
+	# update fails with EDIT_CONFLICT unless it matches the current version.
+	updateMovie(id: ID!, input: UpdateMovieInput!): Movie!
+	# Deletes a movie and returns its ID.
+	deleteMovie(id: ID!): ID!
+}
+
+type Movie {
+	id: ID!
+	title: String!
+	year: Int!
+	# Runtime in minutes.
+	runtime: Int!
+	genres: [String!]!
+	version: Int!
+}
+
+type MovieConnection {
+	movies: [Movie!]!
+	metadata: Metadata!
+}
+
+type Metadata {
+	currentPage: Int!
+	pageSize: Int!
+	firstPage: Int!
+	lastPage: Int!
+	totalRecords: Int!
+}
+
+type User {
+	id: ID!
+	createdAt: Time!
+	name: String!
+	email: String!
+	activated: Boolean!
+	permissions: [String!]!
+}
+
+input CreateMovieInput {
+	title: String!
+	year: Int!
+	runtime: Int!
+	genres: [String!]!
+}
+
+input UpdateMovieInput {
+	title: String
+	year: Int
+	runtime: Int
+	genres: [String!]
+	expectedVersion: Int
+}
+`
+
+// GraphQLHandler godoc
+//
+//	@Summary		GraphQL endpoint
+//	@Description	Executes a GraphQL query or mutation over movies and the authenticated user. Errors are reported in the errors array with a code in their extensions.
+//	@Tags			graphql
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{object}	map[string]interface{}
+//	@Failure		400	{object}	map[string]string
+//	@Router			/v1/graphql [post]
+//
+// graphqlHandler parses the schema and returns the handler for
+// POST /v1/graphql. Authorization happens in the resolvers, using the same
+// permission codes as the REST routes.
+func (app *application) graphqlHandler() http.HandlerFunc {
+	schema := graphql.MustParseSchema(graphqlSchema, &graphqlResolver{app: app},
+		graphql.MaxDepth(app.config.graphql.maxDepth),
+		graphql.MaxQueryLength(64*1024),
+		graphql.PanicHandler(graphqlPanicHandler{app: app}),
+	)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Query         string                 `json:"query"`
+			OperationName string                 `json:"operationName"`
+			Variables     map[string]interface{} `json:"variables"`
+			Extensions    json.RawMessage        `json:"extensions"`
+		}
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponseHelper(w, r, err)
+			return
+		}
+
+		ctx := app.newGraphQLContext(r.Context())
+		response := schema.Exec(ctx, input.Query, input.OperationName, input.Variables)
+
+		env := envelope{"data": response.Data}
+		if len(response.Errors) > 0 {
+			env["errors"] = response.Errors
+		}
+		err = app.writeJSON(w, http.StatusOK, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// graphqlRequest holds the state shared by the resolvers of one request.
+type graphqlRequest struct {
+	movies *dataloader.Loader
+
+	permissionsOnce sync.Once
+	permissions     data.Permissions
+	permissionsErr  error
+
+	// budget is the complexity the request may still spend.
+	budget atomic.Int64
+}
+
+type graphqlContextKey struct{}
+
+func (app *application) newGraphQLContext(ctx context.Context) context.Context {
+	req := &graphqlRequest{
+		movies: dataloader.NewBatchedLoader(app.loadMovies, dataloader.WithWait(2*time.Millisecond), dataloader.WithBatchCapacity(100)),
+	}
+	req.budget.Store(int64(app.config.graphql.maxComplexity))
+	return context.WithValue(ctx, graphqlContextKey{}, req)
+}
+
+func graphqlRequestFrom(ctx context.Context) *graphqlRequest {
+	return ctx.Value(graphqlContextKey{}).(*graphqlRequest)
+}
+
+// loadMovies is the batch function behind the movie loader, so that every
+// movie(id:) field in a query is answered by a single database query.
+func (app *application) loadMovies(ctx context.Context, keys dataloader.Keys) []*dataloader.Result {
+	ids := make([]int64, len(keys))
+	for i, key := range keys {
+		ids[i] = key.Raw().(int64)
+	}
+
+	results := make([]*dataloader.Result, len(keys))
+	movies, err := app.models.Movies.GetMany(ctx, ids)
+	for i, id := range ids {
+		switch movie, ok := movies[id]; {
+		case err != nil:
+			results[i] = &dataloader.Result{Error: err}
+		case !ok:
+			results[i] = &dataloader.Result{Error: data.ErrRecordNotFound}
+		default:
+			results[i] = &dataloader.Result{Data: movie}
+		}
+	}
+	return results
+}
+
+// movieKey is a dataloader key for a movie ID.
+type movieKey int64
+
+func (k movieKey) String() string   { return strconv.FormatInt(int64(k), 10) }
+func (k movieKey) Raw() interface{} { return int64(k) }
+
+// userPermissions returns the permissions of the authenticated user, looking
+// them up once per request however many fields need them.
+func (req *graphqlRequest) userPermissions(ctx context.Context, models data.Models, user *data.User) (data.Permissions, error) {
+	req.permissionsOnce.Do(func() {
+		req.permissions, req.permissionsErr = models.Permissions.GetAllForUser(ctx, user.ID)
+	})
+	return req.permissions, req.permissionsErr
+}
+
+// charge spends cost from the request's complexity budget, failing once the
+// budget is used up.
+func (req *graphqlRequest) charge(cost int) error {
+	if req.budget.Add(-int64(cost)) < 0 {
+		return &graphqlError{code: "COMPLEXITY_LIMIT", message: "the query is too complex, request fewer or smaller pages"}
+	}
+	return nil
+}
+
+// graphqlError is a resolver error with a machine-readable code, reported in
+// the extensions of the GraphQL error.
+type graphqlError struct {
+	code    string
+	message string
+	fields  map[string]string
+}
+
+func (e *graphqlError) Error() string {
+	return e.message
+}
+
+func (e *graphqlError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.code}
+	if e.fields != nil {
+		ext["fields"] = e.fields
+	}
+	return ext
+}
+
+// graphqlServerError logs err and returns the error shown to the client in
+// its place.
+func (app *application) graphqlServerError(ctx context.Context, err error) error {
+	app.logger.PrintErrorContext(ctx, err, map[string]string{
+		"endpoint": "graphql",
+	})
+	return &graphqlError{code: "INTERNAL", message: "the server encountered a problem and could not process your request"}
+}
+
+type graphqlPanicHandler struct {
+	app *application
+}
+
+// MakePanicError logs a panic in a resolver and reports it to the client as
+// an internal error.
+func (h graphqlPanicHandler) MakePanicError(ctx context.Context, value interface{}) *gqlerrors.QueryError {
+	err := h.app.graphqlServerError(ctx, fmt.Errorf("graphql resolver panicked: %v", value))
+	return &gqlerrors.QueryError{Message: err.Error(), Extensions: err.(*graphqlError).Extensions()}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"greenlight.samedarslan28.net/internal/data"
+	"greenlight.samedarslan28.net/internal/jsonlog"
+)
+
+func execGraphQL(t *testing.T, app *application, user *data.User, query string) map[string]interface{} {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]string{"query": query})
+	r := httptest.NewRequest(http.MethodPost, "/v1/graphql", strings.NewReader(string(body)))
+	r = app.contextSetUser(r, user)
+	w := httptest.NewRecorder()
+	app.graphqlHandler()(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d; body %s", w.Code, w.Body)
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func graphqlErrorCodes(res map[string]interface{}) []string {
+	var codes []string
+	errs, _ := res["errors"].([]interface{})
+	for _, e := range errs {
+		ext, _ := e.(map[string]interface{})["extensions"].(map[string]interface{})
+		code, _ := ext["code"].(string)
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+func TestGraphQLAuthorization(t *testing.T) {
+	app := &application{logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelInfo)}
+	app.config.graphql.maxDepth = 10
+	app.config.graphql.maxComplexity = 1000
+
+	tests := []struct {
+		name  string
+		user  *data.User
+		query string
+		want  string
+	}{
+		{"anonymous me", data.AnonymousUser, `{ me { id } }`, "UNAUTHENTICATED"},
+		{"anonymous movies", data.AnonymousUser, `{ movies { movies { title } } }`, "UNAUTHENTICATED"},
+		{"inactive movie", &data.User{ID: 1}, `{ movie(id: "1") { title } }`, "INACTIVE_ACCOUNT"},
+		{"inactive create", &data.User{ID: 1}, `mutation { createMovie(input: {title: "x", year: 2000, runtime: 90, genres: ["drama"]}) { id } }`, "INACTIVE_ACCOUNT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codes := graphqlErrorCodes(execGraphQL(t, app, tt.user, tt.query))
+			if len(codes) != 1 || codes[0] != tt.want {
+				t.Errorf("got error codes %v; want [%s]", codes, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphQLMe(t *testing.T) {
+	app := &application{logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelInfo)}
+	app.config.graphql.maxComplexity = 1000
+
+	res := execGraphQL(t, app, &data.User{ID: 7, Name: "Alice", Email: "alice@example.com"}, `{ me { id name email activated } }`)
+	if res["errors"] != nil {
+		t.Fatalf("unexpected errors: %v", res["errors"])
+	}
+	me := res["data"].(map[string]interface{})["me"].(map[string]interface{})
+	if me["id"] != "7" || me["name"] != "Alice" || me["activated"] != false {
+		t.Errorf("got %v", me)
+	}
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	app := &application{logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelInfo)}
+	app.config.graphql.maxDepth = 2
+	app.config.graphql.maxComplexity = 1000
+
+	res := execGraphQL(t, app, data.AnonymousUser, `{ movies { metadata { totalRecords } } }`)
+	if res["errors"] == nil || res["data"] != nil {
+		t.Errorf("query deeper than the limit ran: %v", res)
+	}
+
+	req := app.newGraphQLContext(t.Context()).Value(graphqlContextKey{}).(*graphqlRequest)
+	if err := req.charge(1000); err != nil {
+		t.Fatalf("charging the whole budget failed: %v", err)
+	}
+	if err := req.charge(1); err == nil {
+		t.Error("charging past the budget succeeded")
+	}
+}
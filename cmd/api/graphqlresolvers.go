@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"greenlight.samedarslan28.net/internal/data"
+	"greenlight.samedarslan28.net/internal/validator"
+)
+
+// graphqlResolver is the root resolver for queries and mutations.
+type graphqlResolver struct {
+	app *application
+}
+
+// authorize checks the authenticated user like requirePermission does for
+// REST routes. An empty code only requires an authenticated user.
+func (r *graphqlResolver) authorize(ctx context.Context, code string) error {
+	user := userFromContext(ctx)
+	switch {
+	case user.IsAnonymous():
+		return &graphqlError{code: "UNAUTHENTICATED", message: "you must be authenticated to access this resource"}
+	case code == "":
+		return nil
+	case !user.Activated:
+		return &graphqlError{code: "INACTIVE_ACCOUNT", message: "your user account must be activated to access this resource"}
+	}
+
+	permissions, err := graphqlRequestFrom(ctx).userPermissions(ctx, r.app.models, user)
+	if err != nil {
+		return r.app.graphqlServerError(ctx, err)
+	}
+	if !permissions.Include(code) {
+		return &graphqlError{code: "FORBIDDEN", message: "your user account doesn't have the necessary permissions to access this resource"}
+	}
+	return nil
+}
+
+// movieError translates an error from the movie model for the client.
+func (r *graphqlResolver) movieError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		return &graphqlError{code: "NOT_FOUND", message: "the requested resource could not be found"}
+	case errors.Is(err, data.ErrEditConflict):
+		return &graphqlError{code: "EDIT_CONFLICT", message: "unable to update the record due to an edit conflict, please try again"}
+	default:
+		return r.app.graphqlServerError(ctx, err)
+	}
+}
+
+func graphqlValidationError(v *validator.Validator) error {
+	return &graphqlError{code: "VALIDATION_FAILED", message: "the input is invalid", fields: v.Errors}
+}
+
+func parseMovieID(id graphql.ID) (int64, bool) {
+	n, err := strconv.ParseInt(string(id), 10, 64)
+	return n, err == nil && n > 0
+}
+
+func (r *graphqlResolver) Movie(ctx context.Context, args struct{ ID graphql.ID }) (*movieResolver, error) {
+	if err := r.authorize(ctx, "movies:read"); err != nil {
+		return nil, err
+	}
+	req := graphqlRequestFrom(ctx)
+	if err := req.charge(1); err != nil {
+		return nil, err
+	}
+
+	id, ok := parseMovieID(args.ID)
+	if !ok {
+		return nil, nil
+	}
+	value, err := req.movies.Load(ctx, movieKey(id))()
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, r.app.graphqlServerError(ctx, err)
+	}
+	return &movieResolver{value.(*data.Movie)}, nil
+}
+
+type moviesArgs struct {
+	Title    *string
+	Genres   *[]string
+	Page     int32
+	PageSize int32
+	Sort     string
+}
+
+func (r *graphqlResolver) Movies(ctx context.Context, args moviesArgs) (*movieConnectionResolver, error) {
+	if err := r.authorize(ctx, "movies:read"); err != nil {
+		return nil, err
+	}
+
+	filters := data.Filters{
+		Page:         int(args.Page),
+		PageSize:     int(args.PageSize),
+		Sort:         args.Sort,
+		SortSafelist: movieSortSafelist,
+	}
+	v := validator.New()
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		return nil, graphqlValidationError(v)
+	}
+
+	// A listing costs one per selected movie field for every movie on the
+	// page, so that large pages of wide selections use up the budget.
+	req := graphqlRequestFrom(ctx)
+	fields := 0
+	for _, name := range graphql.SelectedFieldNames(ctx) {
+		if strings.HasPrefix(name, "movies.") {
+			fields++
+		}
+	}
+	if err := req.charge(1 + filters.PageSize*max(fields, 1)); err != nil {
+		return nil, err
+	}
+
+	title, genres := "", []string{}
+	if args.Title != nil {
+		title = *args.Title
+	}
+	if args.Genres != nil {
+		genres = *args.Genres
+	}
+
+	movies, metadata, err := r.app.models.Movies.GetAll(ctx, title, genres, filters)
+	if err != nil {
+		return nil, r.app.graphqlServerError(ctx, err)
+	}
+	for _, movie := range movies {
+		req.movies.Prime(ctx, movieKey(movie.ID), movie)
+	}
+	return &movieConnectionResolver{movies: movies, metadata: metadata}, nil
+}
+
+func (r *graphqlResolver) Me(ctx context.Context) (*userResolver, error) {
+	if err := r.authorize(ctx, ""); err != nil {
+		return nil, err
+	}
+	if err := graphqlRequestFrom(ctx).charge(1); err != nil {
+		return nil, err
+	}
+	return &userResolver{root: r, user: userFromContext(ctx)}, nil
+}
+
+type createMovieInput struct {
+	Title   string
+	Year    int32
+	Runtime int32
+	Genres  []string
+}
+
+func (r *graphqlResolver) CreateMovie(ctx context.Context, args struct{ Input createMovieInput }) (*movieResolver, error) {
+	if err := r.authorize(ctx, "movies:write"); err != nil {
+		return nil, err
+	}
+	if err := graphqlRequestFrom(ctx).charge(10); err != nil {
+		return nil, err
+	}
+
+	movie := &data.Movie{
+		Title:   args.Input.Title,
+		Year:    args.Input.Year,
+		Runtime: data.Runtime(args.Input.Runtime),
+		Genres:  args.Input.Genres,
+	}
+	v := validator.New()
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		return nil, graphqlValidationError(v)
+	}
+
+	err := r.app.models.Movies.Insert(ctx, movie)
+	if err != nil {
+		return nil, r.app.graphqlServerError(ctx, err)
+	}
+	return &movieResolver{movie}, nil
+}
+
+type updateMovieInput struct {
+	Title           *string
+	Year            *int32
+	Runtime         *int32
+	Genres          *[]string
+	ExpectedVersion *int32
+}
+
+func (r *graphqlResolver) UpdateMovie(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input updateMovieInput
+}) (*movieResolver, error) {
+	if err := r.authorize(ctx, "movies:write"); err != nil {
+		return nil, err
+	}
+	req := graphqlRequestFrom(ctx)
+	if err := req.charge(10); err != nil {
+		return nil, err
+	}
+
+	id, ok := parseMovieID(args.ID)
+	if !ok {
+		return nil, r.movieError(ctx, data.ErrRecordNotFound)
+	}
+	// Read from the primary so that the version we update against is current.
+	movie, err := r.app.models.Movies.Get(data.UsePrimary(ctx), id)
+	if err != nil {
+		return nil, r.movieError(ctx, err)
+	}
+
+	input := args.Input
+	if input.ExpectedVersion != nil && *input.ExpectedVersion != movie.Version {
+		return nil, r.movieError(ctx, data.ErrEditConflict)
+	}
+	if input.Title != nil {
+		movie.Title = *input.Title
+	}
+	if input.Year != nil {
+		movie.Year = *input.Year
+	}
+	if input.Runtime != nil {
+		movie.Runtime = data.Runtime(*input.Runtime)
+	}
+	if input.Genres != nil {
+		movie.Genres = *input.Genres
+	}
+
+	v := validator.New()
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		return nil, graphqlValidationError(v)
+	}
+	err = r.app.models.Movies.Update(ctx, movie)
+	if err != nil {
+		return nil, r.movieError(ctx, err)
+	}
+
+	req.movies.Clear(ctx, movieKey(id)).Prime(ctx, movieKey(id), movie)
+	return &movieResolver{movie}, nil
+}
+
+func (r *graphqlResolver) DeleteMovie(ctx context.Context, args struct{ ID graphql.ID }) (graphql.ID, error) {
+	if err := r.authorize(ctx, "movies:write"); err != nil {
+		return "", err
+	}
+	req := graphqlRequestFrom(ctx)
+	if err := req.charge(10); err != nil {
+		return "", err
+	}
+
+	id, ok := parseMovieID(args.ID)
+	if !ok {
+		return "", r.movieError(ctx, data.ErrRecordNotFound)
+	}
+	err := r.app.models.Movies.Delete(ctx, id)
+	if err != nil {
+		return "", r.movieError(ctx, err)
+	}
+
+	req.movies.Clear(ctx, movieKey(id))
+	return args.ID, nil
+}
+
+type movieResolver struct {
+	movie *data.Movie
+}
+
+func (m *movieResolver) ID() graphql.ID {
+	return graphql.ID(strconv.FormatInt(m.movie.ID, 10))
+}
+
+func (m *movieResolver) Title() string    { return m.movie.Title }
+func (m *movieResolver) Year() int32      { return m.movie.Year }
+func (m *movieResolver) Runtime() int32   { return int32(m.movie.Runtime) }
+func (m *movieResolver) Genres() []string { return m.movie.Genres }
+func (m *movieResolver) Version() int32   { return m.movie.Version }
+
+type movieConnectionResolver struct {
+	movies   []*data.Movie
+	metadata data.Metadata
+}
+
+func (c *movieConnectionResolver) Movies() []*movieResolver {
+	resolvers := make([]*movieResolver, len(c.movies))
+	for i, movie := range c.movies {
+		resolvers[i] = &movieResolver{movie}
+	}
+	return resolvers
+}
+
+func (c *movieConnectionResolver) Metadata() *metadataResolver {
+	return &metadataResolver{c.metadata}
+}
+
+type metadataResolver struct {
+	metadata data.Metadata
+}
+
+func (m *metadataResolver) CurrentPage() int32  { return int32(m.metadata.CurrentPage) }
+func (m *metadataResolver) PageSize() int32     { return int32(m.metadata.PageSize) }
+func (m *metadataResolver) FirstPage() int32    { return int32(m.metadata.FirstPage) }
+func (m *metadataResolver) LastPage() int32     { return int32(m.metadata.LastPage) }
+func (m *metadataResolver) TotalRecords() int32 { return int32(m.metadata.TotalRecords) }
+
+type userResolver struct {
+	root *graphqlResolver
+	user *data.User
+}
+
+func (u *userResolver) ID() graphql.ID {
+	return graphql.ID(strconv.FormatInt(u.user.ID, 10))
+}
+
+func (u *userResolver) CreatedAt() graphql.Time { return graphql.Time{Time: u.user.CreatedAt} }
+func (u *userResolver) Name() string            { return u.user.Name }
+func (u *userResolver) Email() string           { return u.user.Email }
+func (u *userResolver) Activated() bool         { return u.user.Activated }
+
+func (u *userResolver) Permissions(ctx context.Context) ([]string, error) {
+	permissions, err := graphqlRequestFrom(ctx).userPermissions(ctx, u.root.app.models, u.user)
+	if err != nil {
+		return nil, u.root.app.graphqlServerError(ctx, err)
+	}
+	if permissions == nil {
+		return []string{}, nil
+	}
+	return permissions, nil
+}
@@ -26,7 +26,7 @@ func (app *application) healthCheckerHandler(w http.ResponseWriter, r *http.Requ
 		},
 	}
 
-	err := app.writeJSON(w, 200, env, nil)
+	err := app.writeResponse(w, r, 200, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"greenlight.samedarslan28.net/internal/data"
+	"greenlight.samedarslan28.net/internal/jsonlog"
+	"greenlight.samedarslan28.net/internal/migrate"
+	"greenlight.samedarslan28.net/migrations"
+)
+
+// Overall and per-dependency readiness states. A failing critical
+// dependency makes the API failing; any other failure only degrades it.
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+	healthFailing  = "failing"
+)
+
+// healthCheck is one dependency checked by the readiness probe. check
+// returns a short description of what it found, such as a schema version.
+type healthCheck struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) (detail string, err error)
+}
+
+// HealthResult is the outcome of checking one dependency. Errors are logged
+// rather than returned, since the probe is public.
+type HealthResult struct {
+	Status    string  `json:"status" example:"ok"`
+	LatencyMS float64 `json:"latency_ms" example:"1.25"`
+	Detail    string  `json:"detail,omitempty" example:"version 13"`
+}
+
+// ReadinessResponse represents the readiness probe response body.
+type ReadinessResponse struct {
+	Status string                  `json:"status" example:"ok"`
+	Checks map[string]HealthResult `json:"checks"`
+}
+
+// readinessCache holds the last readiness result, so that frequent probes
+// from several load balancers do not each hit every dependency, and the run
+// in progress, so that probes arriving during it share its result.
+type readinessCache struct {
+	mu      sync.Mutex
+	resp    ReadinessResponse
+	checked time.Time
+	running *readinessRun
+}
+
+type readinessRun struct {
+	done chan struct{}
+	resp ReadinessResponse
+}
+
+// dependencyChecks returns the readiness checks of the database, its schema,
+// the job queue and the SMTP server.
+func (app *application) dependencyChecks(db *data.Cluster) []healthCheck {
+	return []healthCheck{
+		{name: "database", critical: true, check: func(ctx context.Context) (string, error) {
+			return "", db.Primary.PingContext(ctx)
+		}},
+		{name: "migrations", critical: true, check: func(ctx context.Context) (string, error) {
+			m, err := migrate.New(db.Primary, migrations.FS)
+			if err != nil {
+				return "", err
+			}
+			status, err := m.Inspect(ctx)
+			if err != nil {
+				return "", err
+			}
+			detail := fmt.Sprintf("version %d", status.Current)
+			switch {
+			case status.Dirty:
+				return detail, errors.New("schema is dirty")
+			case status.Pending():
+				return detail, fmt.Errorf("schema is behind this build, which needs version %d", status.Latest)
+			}
+			return detail, nil
+		}},
+		{name: "job_queue", check: func(ctx context.Context) (string, error) {
+			backlog, err := app.models.Jobs.Backlog(ctx)
+			if err != nil {
+				return "", err
+			}
+			detail := strconv.Itoa(backlog) + " jobs due"
+			if max := app.config.health.maxJobBacklog; max > 0 && backlog > max {
+				return detail, fmt.Errorf("backlog is over %d jobs", max)
+			}
+			return detail, nil
+		}},
+		{name: "smtp", check: func(ctx context.Context) (string, error) {
+			if err := app.mailer.Ping(ctx); err != nil {
+				return "", fmt.Errorf("%s: %w", app.config.smtp.host, err)
+			}
+			return "", nil
+		}},
+	}
+}
+
+// LivenessHandler godoc
+// @Summary      Liveness probe
+// @Description  Reports that the process is up and serving. It checks no dependencies, so an outage elsewhere does not get the API restarted.
+// @Tags         Health
+// @Produce      json
+// @Success      200  {object}  map[string]string
+// @Router       /health/live [get]
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": healthOK}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ReadinessHandler godoc
+// @Summary      Readiness probe
+// @Description  Checks every dependency and reports its status and latency. Answers 503 when a critical dependency fails or the server is shutting down, and 200 with status "degraded" when only a non-critical one fails.
+// @Tags         Health
+// @Produce      json
+// @Success      200  {object}  ReadinessResponse
+// @Failure      503  {object}  ReadinessResponse
+// @Router       /health/ready [get]
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	var resp ReadinessResponse
+	if app.shuttingDown.Load() {
+		resp = ReadinessResponse{
+			Status: healthFailing,
+			Checks: map[string]HealthResult{
+				"shutdown": {Status: healthFailing, Detail: "server is shutting down"},
+			},
+		}
+	} else {
+		resp = app.checkReadiness(r.Context())
+	}
+
+	status := http.StatusOK
+	if resp.Status == healthFailing {
+		status = http.StatusServiceUnavailable
+	}
+	err := app.writeJSON(w, status, envelope{"status": resp.Status, "checks": resp.Checks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkReadiness returns the cached readiness result, running the checks
+// again once it is older than the health check cache interval. Callers that
+// arrive while the checks run wait for and share their result; the lock is
+// not held while they run.
+func (app *application) checkReadiness(ctx context.Context) ReadinessResponse {
+	c := &app.readiness
+	c.mu.Lock()
+	if !c.checked.IsZero() && time.Since(c.checked) < app.config.health.cacheTTL {
+		defer c.mu.Unlock()
+		return c.resp
+	}
+	if run := c.running; run != nil {
+		c.mu.Unlock()
+		<-run.done
+		return run.resp
+	}
+	run := &readinessRun{done: make(chan struct{})}
+	c.running = run
+	c.mu.Unlock()
+
+	// The result is shared, so it must not depend on whether the request
+	// that happened to run the checks goes away.
+	run.resp = app.runReadinessChecks(context.WithoutCancel(ctx))
+
+	c.mu.Lock()
+	c.resp, c.checked, c.running = run.resp, time.Now(), nil
+	c.mu.Unlock()
+	close(run.done)
+	return run.resp
+}
+
+// runReadinessChecks runs the readiness checks concurrently, each bounded by
+// the health check timeout.
+func (app *application) runReadinessChecks(ctx context.Context) ReadinessResponse {
+	resp := ReadinessResponse{
+		Status: healthOK,
+		Checks: make(map[string]HealthResult, len(app.readinessChecks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, hc := range app.readinessChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := app.runHealthCheck(ctx, hc)
+
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Checks[hc.name] = result
+			switch {
+			case result.Status == healthOK:
+			case hc.critical:
+				resp.Status = healthFailing
+			case resp.Status == healthOK:
+				resp.Status = healthDegraded
+			}
+		}()
+	}
+	wg.Wait()
+	return resp
+}
+
+func (app *application) runHealthCheck(ctx context.Context, hc healthCheck) HealthResult {
+	ctx, cancel := context.WithTimeout(ctx, app.config.health.timeout)
+	defer cancel()
+
+	start := time.Now()
+	detail, err := hc.check(ctx)
+	result := HealthResult{
+		Status:    healthOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		Detail:    detail,
+	}
+	if err != nil {
+		result.Status = healthFailing
+		app.logger.Warn("readiness check failed", jsonlog.Fields{
+			"check":    hc.name,
+			"critical": hc.critical,
+			"error":    err.Error(),
+		})
+	}
+	return result
+}
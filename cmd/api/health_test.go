@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"greenlight.samedarslan28.net/internal/jsonlog"
+)
+
+func TestLiveness(t *testing.T) {
+	app := &application{logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelInfo)}
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/health/live", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("GET /v1/health/live = %d; want 200", rr.Code)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	pass := func(context.Context) (string, error) { return "fine", nil }
+	fail := func(context.Context) (string, error) { return "", errors.New("unreachable") }
+	hang := func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+
+	tests := []struct {
+		name     string
+		checks   []healthCheck
+		shutdown bool
+		status   int
+		want     string
+	}{
+		{"all pass", []healthCheck{{name: "database", critical: true, check: pass}, {name: "smtp", check: pass}}, false, http.StatusOK, healthOK},
+		{"optional fails", []healthCheck{{name: "database", critical: true, check: pass}, {name: "smtp", check: fail}}, false, http.StatusOK, healthDegraded},
+		{"critical fails", []healthCheck{{name: "database", critical: true, check: fail}, {name: "smtp", check: fail}}, false, http.StatusServiceUnavailable, healthFailing},
+		{"critical times out", []healthCheck{{name: "database", critical: true, check: hang}}, false, http.StatusServiceUnavailable, healthFailing},
+		{"shutting down", []healthCheck{{name: "database", critical: true, check: pass}}, true, http.StatusServiceUnavailable, healthFailing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{
+				logger:          jsonlog.NewLogger(io.Discard, jsonlog.LevelInfo),
+				readinessChecks: tt.checks,
+			}
+			app.config.health.timeout = 50 * time.Millisecond
+			app.shuttingDown.Store(tt.shutdown)
+
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/health/ready", nil))
+			if rr.Code != tt.status {
+				t.Errorf("status = %d; want %d", rr.Code, tt.status)
+			}
+
+			var resp ReadinessResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Status != tt.want {
+				t.Errorf("readiness = %q; want %q", resp.Status, tt.want)
+			}
+			if tt.shutdown {
+				if _, ok := resp.Checks["shutdown"]; !ok {
+					t.Error("shutdown is not reported")
+				}
+				return
+			}
+			for _, hc := range tt.checks {
+				if _, ok := resp.Checks[hc.name]; !ok {
+					t.Errorf("%s is not reported", hc.name)
+				}
+			}
+		})
+	}
+}
+
+func TestReadinessCache(t *testing.T) {
+	var runs atomic.Int32
+	var logs bytes.Buffer
+	app := &application{
+		logger: jsonlog.NewLogger(&logs, jsonlog.LevelInfo),
+		readinessChecks: []healthCheck{{name: "smtp", check: func(context.Context) (string, error) {
+			runs.Add(1)
+			return "", errors.New("dial tcp smtp.internal:25: connection refused")
+		}}},
+	}
+	app.config.health.timeout = 50 * time.Millisecond
+	app.config.health.cacheTTL = time.Minute
+
+	for range 3 {
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/health/ready", nil))
+		if strings.Contains(rr.Body.String(), "smtp.internal") {
+			t.Errorf("the error is in the response body: %s", rr.Body)
+		}
+	}
+	if n := runs.Load(); n != 1 {
+		t.Errorf("checks ran %d times; want 1 within the cache interval", n)
+	}
+	if !strings.Contains(logs.String(), "smtp.internal") {
+		t.Errorf("the failed check was not logged:\n%s", logs.String())
+	}
+}
+
+func TestReadinessSharesRunningChecks(t *testing.T) {
+	var runs atomic.Int32
+	release := make(chan struct{})
+	app := &application{
+		logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelInfo),
+		readinessChecks: []healthCheck{{name: "db", check: func(context.Context) (string, error) {
+			runs.Add(1)
+			<-release
+			return "", nil
+		}}},
+	}
+	app.config.health.timeout = time.Second
+
+	results := make(chan ReadinessResponse, 3)
+	for range 3 {
+		go func() { results <- app.checkReadiness(context.Background()) }()
+	}
+	// Give every probe time to find the run in progress before it finishes.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	for range 3 {
+		if resp := <-results; resp.Status != healthOK {
+			t.Errorf("status = %q; want %q", resp.Status, healthOK)
+		}
+	}
+	if n := runs.Load(); n != 1 {
+		t.Errorf("checks ran %d times; want 1 shared by the concurrent probes", n)
+	}
+
+	// With no cache interval, a probe after the run finishes checks again.
+	app.checkReadiness(context.Background())
+	if n := runs.Load(); n != 2 {
+		t.Errorf("checks ran %d times; want 2", n)
+	}
+}
@@ -1,18 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
+	"greenlight.samedarslan28.net/internal/render"
 	"greenlight.samedarslan28.net/internal/validator"
 )
 
@@ -25,27 +25,54 @@ type Envelope struct {
 }
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readInt64Param(r, "id")
+}
+
+// readInt64Param reads a positive integer route parameter such as :id.
+func (app *application) readInt64Param(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 	return id, nil
 }
 
+// responseEncoders are the formats responses can be sent in. JSON comes first
+// and is used when the client accepts anything.
+var responseEncoders = render.NewRegistry(render.JSON, render.CSV, render.XML, render.MessagePack)
+
+// writeResponse writes data in the format negotiated from the request's
+// Accept header, falling back to JSON when none of the formats is acceptable.
+// Routes behind requireAcceptable have already rejected such requests.
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	enc, err := responseEncoders.Negotiate(r.Header.Get("Accept"))
+	if err != nil {
+		enc = responseEncoders.Default()
+	}
+	w.Header().Add("Vary", "Accept")
+	return app.writeEncoded(w, enc, status, data, headers)
+}
+
+// writeJSON writes data as JSON whatever the client accepts, for endpoints
+// such as GraphQL whose format is fixed.
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
-	js, err := json.MarshalIndent(data, "", "\t")
+	return app.writeEncoded(w, render.JSON, status, data, headers)
+}
+
+func (app *application) writeEncoded(w http.ResponseWriter, enc render.Encoder, status int, data interface{}, headers http.Header) error {
+	var buf bytes.Buffer
+	err := enc.Encode(&buf, data)
 	if err != nil {
 		return err
 	}
 
-	js = append(js, '\n')
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", enc.ContentType)
 	w.WriteHeader(status)
-	_, err = w.Write(js)
+	_, err = w.Write(buf.Bytes())
 	if err != nil {
 		app.logger.PrintError(err, nil)
 		return err
@@ -99,7 +126,7 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 }
 
 func (app *application) badRequestResponseHelper(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	app.errorResponse(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error())
 }
 
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
@@ -131,25 +158,3 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, val
 	}
 	return integer
 }
-
-func mustGetEnv(key string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		log.Fatalf("Environment variable %s is not set", key)
-	}
-	return value
-}
-
-func (app *application) background(fn func()) {
-	app.wg.Add(1)
-	go func() {
-		defer func() {
-			defer app.wg.Done()
-			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil)
-			}
-		}()
-		fn()
-	}()
-	app.wg.Wait()
-}
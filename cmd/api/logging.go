@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"greenlight.samedarslan28.net/internal/jsonlog"
+)
+
+// openLogOutput returns the writer log lines go to: stdout, the log file or
+// both. Lines are handed to an AsyncWriter so that a slow disk never holds
+// up request handling. The file sink is returned as well, so that it can be
+// rotated and closed; it is nil when no log file is configured.
+func openLogOutput(cfg config) (*jsonlog.AsyncWriter, *jsonlog.FileSink, error) {
+	var outputs []io.Writer
+	if cfg.log.stdout {
+		outputs = append(outputs, os.Stdout)
+	}
+
+	var file *jsonlog.FileSink
+	if cfg.log.file.path != "" {
+		file = &jsonlog.FileSink{
+			Path:       cfg.log.file.path,
+			MaxSize:    int64(cfg.log.file.maxSizeMB) << 20,
+			MaxAge:     cfg.log.file.maxAge,
+			MaxBackups: cfg.log.file.maxBackups,
+			Compress:   cfg.log.file.compress,
+		}
+		if err := file.Open(); err != nil {
+			return nil, nil, err
+		}
+		outputs = append(outputs, file)
+	}
+
+	if len(outputs) == 0 {
+		return nil, nil, errors.New("no log output: enable -log-stdout or set -log-file")
+	}
+	return jsonlog.NewAsyncWriter(jsonlog.MultiWriter(outputs...), cfg.log.buffer), file, nil
+}
+
+// rotateLogs starts a new log file, if there is one. It runs on SIGHUP, for
+// use with external tools such as logrotate.
+func (app *application) rotateLogs() {
+	if app.logFile == nil {
+		return
+	}
+	if err := app.logFile.Rotate(); err != nil {
+		app.logger.PrintError(err, map[string]string{"task": "rotate log file"})
+		return
+	}
+	app.logger.PrintInfo("rotated log file", map[string]string{"path": app.logFile.Path})
+}
@@ -17,24 +17,32 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"runtime"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/felixge/httpsnoop"
-	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	_ "greenlight.samedarslan28.net/docs"
+	"greenlight.samedarslan28.net/internal/compress"
 	"greenlight.samedarslan28.net/internal/data"
+	"greenlight.samedarslan28.net/internal/events"
 	"greenlight.samedarslan28.net/internal/jsonlog"
 	"greenlight.samedarslan28.net/internal/mailer"
+	"greenlight.samedarslan28.net/internal/scheduler"
+	"greenlight.samedarslan28.net/internal/trace"
 )
 
 var (
@@ -56,6 +64,75 @@ type config struct {
 		burst   int
 		enabled bool
 	}
+	cors struct {
+		trustedOrigins []string
+	}
+	cache struct {
+		movieEntries int
+		movieTTL     time.Duration
+	}
+	scheduler struct {
+		purgeTokens      string
+		purgeUnactivated string
+		unactivatedAge   int
+		refreshStats     string
+		timeout          time.Duration
+	}
+	events struct {
+		replay    int
+		heartbeat time.Duration
+	}
+	webhooks struct {
+		workers      int
+		pollInterval time.Duration
+		timeout      time.Duration
+		maxAttempts  int
+	}
+	jobs struct {
+		workers      int
+		pollInterval time.Duration
+		timeout      time.Duration
+	}
+	graphql struct {
+		maxDepth      int
+		maxComplexity int
+	}
+	compression struct {
+		encodings []string
+		minSize   int
+	}
+	log struct {
+		level       jsonlog.Level
+		stackTraces bool
+		stdout      bool
+		buffer      int
+		file        struct {
+			path       string
+			maxSizeMB  int
+			maxAge     time.Duration
+			maxBackups int
+			compress   bool
+		}
+	}
+	metrics struct {
+		addr string
+	}
+	health struct {
+		timeout       time.Duration
+		cacheTTL      time.Duration
+		maxJobBacklog int
+		drainDelay    time.Duration
+	}
+	tracing struct {
+		exporter     string
+		otlpEndpoint string
+		sampleRatio  float64
+	}
+	accessLog struct {
+		enabled    bool
+		sampleRate float64
+		redact     []string
+	}
 	smtp struct {
 		host     string
 		port     int
@@ -70,113 +147,346 @@ type dbConfig struct {
 	maxOpenConns int
 	maxIdleConns int
 	maxIdleTime  string
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	slowQuery    time.Duration
+	replicas     struct {
+		dsns          []string
+		stickyWindow  time.Duration
+		checkInterval time.Duration
+	}
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	cmdLine commandLine
+	logger  *jsonlog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	wg      sync.WaitGroup
+
+	movieEvents *events.Broker
+	scheduler   *scheduler.Scheduler
+	logFile     *jsonlog.FileSink
+	tracer      *trace.Tracer
+
+	// readinessChecks are run by the readiness probe, which reuses its last
+	// result from readiness for a short while. shuttingDown makes the probe
+	// fail as soon as a shutdown begins.
+	readinessChecks []healthCheck
+	readiness       readinessCache
+	shuttingDown    atomic.Bool
+
+	// live holds the configuration as last reloaded on SIGHUP; see
+	// settings. reloadMu serializes reloads, which also update the layered
+	// values in cmdLine that the next reload is compared against.
+	live     atomic.Pointer[config]
+	reloadMu sync.Mutex
 }
 
 func main() {
-	var cfg config
-
-	displayVersion := parseFlags(&cfg)
+	cfg, cmdLine, err := loadConfig(os.Args[1:])
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(0)
+	case errors.Is(err, errCommandLine):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	if *displayVersion {
+	if cmdLine.version {
 		fmt.Printf("Version:\t%s\n", version)
 		fmt.Printf("Build time:\t%s\n", buildTime)
 		os.Exit(0)
 	}
 
-	logger := jsonlog.NewLogger(os.Stdout, jsonlog.LevelInfo)
+	logOutput, logFile, err := openLogOutput(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger := jsonlog.NewLogger(logOutput, cfg.log.level)
+	if !cfg.log.stackTraces {
+		logger.SetStackLevel(jsonlog.LevelOff)
+	}
+	// Libraries that log through log/slog write the same JSON lines.
+	slog.SetDefault(slog.New(logger.Handler()))
+	logger.SetContextFunc(func(ctx context.Context) map[string]string {
+		properties := map[string]string{"request_id": requestIDFromContext(ctx)}
+		if sc := trace.SpanFromContext(ctx).SpanContext(); sc.IsValid() {
+			properties["trace_id"] = sc.TraceID.String()
+		}
+		return properties
+	})
 
-	err := godotenv.Load()
+	tracer, err := newTracer(cfg, logger)
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		logger.PrintFatal(err, nil)
 	}
 
-	cfg.db.dsn = mustGetEnv("DB_DSN")
-	cfg.smtp.username = mustGetEnv("SMTP_USERNAME")
-	cfg.smtp.password = mustGetEnv("SMTP_PASSWORD")
+	queryStats := data.NewQueryStats(cfg.db.slowQuery, func(ctx context.Context, event data.QueryEvent) {
+		properties := map[string]string{
+			"query":       event.Name,
+			"duration_ms": strconv.FormatInt(event.Duration.Milliseconds(), 10),
+			"rows":        strconv.FormatInt(event.Rows, 10),
+		}
+		if event.Err != nil {
+			properties["error"] = event.Err.Error()
+		}
+		logger.PrintInfoContext(ctx, "slow query", properties)
+	})
 
-	db, err := openDB(cfg)
+	db, err := openDB(cfg, queryStats)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer func(db *sql.DB) {
+	defer func(db *data.Cluster) {
 		_ = db.Close()
 	}(db)
 
-	setupMetrics(logger, db)
+	if args := cmdLine.args; len(args) > 0 {
+		err = runCommand(cfg, logger, db, args)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
+	}
+
+	err = checkSchemaVersion(logger, db.Primary)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	go db.MonitorReplicas(context.Background(), cfg.db.replicas.checkInterval)
+
+	var movieCache *data.MovieCache
+	if cfg.cache.movieEntries > 0 {
+		movieCache = data.NewMovieCache(cfg.cache.movieEntries, cfg.cache.movieTTL)
+	}
+
+	setupMetrics(logger, db, movieCache, queryStats)
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:  cfg,
+		cmdLine: cmdLine,
+		logger:  logger,
+		logFile: logFile,
+		tracer:  tracer,
+		models:  data.NewModels(db, data.Timeouts{Read: cfg.db.readTimeout, Write: cfg.db.writeTimeout}, movieCache),
+		mailer:  mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+
+		movieEvents: events.NewBroker(cfg.events.replay),
 	}
+	app.scheduler, err = app.newScheduler()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	app.readinessChecks = app.dependencyChecks(db)
+
 	err = app.serve()
 	if err != nil {
 		logger.PrintFatal(err, nil)
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := tracer.Shutdown(ctx); err != nil {
+		logger.PrintError(err, map[string]string{"task": "export spans"})
+	}
+	cancel()
+
+	logOutput.Close()
+	if logFile != nil {
+		logFile.Close()
+	}
 }
 
-func setupMetrics(logger *jsonlog.Logger, db *sql.DB) {
+func setupMetrics(logger *jsonlog.Logger, db *data.Cluster, movieCache *data.MovieCache, queryStats *data.QueryStats) {
 	logger.PrintInfo("database connection pool established", nil)
+	registerDBMetrics(db)
 	expvar.NewString("version").Set(version)
 	expvar.Publish("goroutines", expvar.Func(func() interface{} {
 		return runtime.NumGoroutine()
 	}))
 
 	expvar.Publish("database", expvar.Func(func() interface{} {
-		return db.Stats()
+		return db.Primary.Stats()
 	}))
+	expvar.Publish("database_replicas", expvar.Func(func() interface{} {
+		return map[string]interface{}{
+			"status": db.ReplicaStatus(),
+			"stats":  db.ReplicaStats(),
+		}
+	}))
+	expvar.Publish("database_queries", expvar.Func(func() interface{} {
+		return queryStats.Snapshot()
+	}))
+	if movieCache != nil {
+		expvar.Publish("movie_cache", expvar.Func(func() interface{} {
+			return movieCache.Stats()
+		}))
+	}
 	expvar.Publish("timestamp", expvar.Func(func() interface{} {
 		return time.Now().Unix()
 	}))
 }
 
-func parseFlags(cfg *config) *bool {
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
-	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("DB_DSN"), "PostgresSQL DSN")
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgresSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgresSQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgresSQL max idle time")
-
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
-
-	flag.StringVar(&cfg.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("SMTP_USERNAME"), "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("SMTP_PASSWORD"), "SMTP password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.abdulsamedarslan.net>", "SMTP sender")
-	displayVersion := flag.Bool("version", false, "Display version and exit")
-
-	flag.Parse()
-	return displayVersion
+// defineFlags registers every setting on fs, with cfg as its destination.
+// Each one can also come from the config file or the environment; see
+// loadConfig.
+func defineFlags(fs *flag.FlagSet, cfg *config) {
+	fs.IntVar(&cfg.port, "port", 4000, "API server port")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgresSQL DSN (also read from DB_DSN)")
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgresSQL max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgresSQL max idle connections")
+	fs.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgresSQL max idle time")
+	fs.DurationVar(&cfg.db.readTimeout, "db-read-timeout", 3*time.Second, "PostgresSQL timeout for read queries")
+	fs.DurationVar(&cfg.db.writeTimeout, "db-write-timeout", 5*time.Second, "PostgresSQL timeout for write queries")
+	fs.DurationVar(&cfg.db.slowQuery, "db-slow-query-threshold", 200*time.Millisecond, "Log queries that take at least this long (0 disables)")
+	fs.Func("db-replica-dsns", "Comma-separated PostgresSQL read replica DSNs", func(value string) error {
+		cfg.db.replicas.dsns = strings.Split(value, ",")
+		return nil
+	})
+	fs.DurationVar(&cfg.db.replicas.stickyWindow, "db-replica-sticky-window", 5*time.Second, "How long a client reads from the primary after writing")
+	fs.DurationVar(&cfg.db.replicas.checkInterval, "db-replica-check-interval", 5*time.Second, "Interval between read replica health checks")
+
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	fs.Func("cors-trusted-origins", "Comma-separated origins allowed to make cross-origin requests (empty allows any origin)", func(value string) error {
+		cfg.cors.trustedOrigins = nil
+		for _, origin := range strings.Split(value, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				cfg.cors.trustedOrigins = append(cfg.cors.trustedOrigins, origin)
+			}
+		}
+		return nil
+	})
+
+	fs.IntVar(&cfg.cache.movieEntries, "cache-movie-entries", 1000, "Maximum number of cached movies and movie listings (0 disables the cache)")
+	fs.DurationVar(&cfg.cache.movieTTL, "cache-movie-ttl", 30*time.Second, "How long movie reads are cached")
+
+	fs.StringVar(&cfg.scheduler.purgeTokens, "schedule-purge-tokens", "0 * * * *", "Cron schedule for deleting expired tokens (empty disables)")
+	fs.StringVar(&cfg.scheduler.purgeUnactivated, "schedule-purge-unactivated", "30 3 * * *", "Cron schedule for deleting stale unactivated accounts (empty disables)")
+	fs.IntVar(&cfg.scheduler.unactivatedAge, "purge-unactivated-after-days", 30, "Age in days after which unactivated accounts are deleted")
+	fs.StringVar(&cfg.scheduler.refreshStats, "schedule-refresh-stats", "*/5 * * * *", "Cron schedule for refreshing the catalogue statistics snapshot (empty disables)")
+	fs.DurationVar(&cfg.scheduler.timeout, "scheduler-task-timeout", 5*time.Minute, "Timeout for a single run of a scheduled task")
+
+	fs.IntVar(&cfg.events.replay, "events-replay-size", 1000, "Number of recent movie events kept for clients resuming a stream")
+	fs.DurationVar(&cfg.events.heartbeat, "events-heartbeat", 15*time.Second, "Interval between heartbeats on idle event streams")
+
+	fs.IntVar(&cfg.webhooks.workers, "webhook-workers", 4, "Number of concurrent webhook deliveries (0 disables delivery on this instance)")
+	fs.DurationVar(&cfg.webhooks.pollInterval, "webhook-poll-interval", 2*time.Second, "How often an idle webhook worker checks for due deliveries")
+	fs.DurationVar(&cfg.webhooks.timeout, "webhook-timeout", 10*time.Second, "Timeout for a single webhook delivery")
+	fs.IntVar(&cfg.webhooks.maxAttempts, "webhook-max-attempts", 8, "Delivery attempts before a webhook is dead-lettered")
+
+	fs.IntVar(&cfg.jobs.workers, "job-workers", 4, "Number of background jobs run at once (0 disables job processing on this instance)")
+	fs.DurationVar(&cfg.jobs.pollInterval, "job-poll-interval", time.Second, "How often an idle job worker checks for due jobs")
+	fs.DurationVar(&cfg.jobs.timeout, "job-timeout", time.Minute, "Timeout for a single run of a background job")
+
+	fs.IntVar(&cfg.graphql.maxDepth, "graphql-max-depth", 10, "Maximum nesting depth of a GraphQL query")
+	fs.IntVar(&cfg.graphql.maxComplexity, "graphql-max-complexity", 1000, "Maximum complexity of a GraphQL query, roughly the number of fields it may return")
+
+	cfg.compression.encodings = compress.Encodings
+	fs.Func("compression-encodings", "Comma-separated response encodings to offer, most preferred first: zstd, br, gzip (empty disables compression)", func(value string) error {
+		cfg.compression.encodings = nil
+		for _, encoding := range strings.Split(value, ",") {
+			encoding = strings.TrimSpace(encoding)
+			if encoding == "" {
+				continue
+			}
+			if !slices.Contains(compress.Encodings, encoding) {
+				return fmt.Errorf("unsupported encoding %q", encoding)
+			}
+			cfg.compression.encodings = append(cfg.compression.encodings, encoding)
+		}
+		return nil
+	})
+	fs.IntVar(&cfg.compression.minSize, "compression-min-size", 1024, "Responses smaller than this many bytes are sent uncompressed")
+
+	cfg.log.level = jsonlog.LevelInfo
+	fs.Func("log-level", "Minimum log level (debug|info|warn|error|fatal|off) (default info)", func(value string) error {
+		level, err := jsonlog.ParseLevel(value)
+		cfg.log.level = level
+		return err
+	})
+	fs.BoolVar(&cfg.log.stackTraces, "log-stack-traces", false, "Add a stack trace to error log lines")
+	fs.BoolVar(&cfg.log.stdout, "log-stdout", true, "Write log lines to stdout")
+	fs.IntVar(&cfg.log.buffer, "log-buffer", 4096, "Log lines buffered on their way to a slow output before lines are dropped")
+	fs.StringVar(&cfg.log.file.path, "log-file", "", "Also write log lines to this file (empty disables)")
+	fs.IntVar(&cfg.log.file.maxSizeMB, "log-file-max-size-mb", 100, "Rotate the log file before it grows beyond this many megabytes (0 disables)")
+	fs.DurationVar(&cfg.log.file.maxAge, "log-file-max-age", 24*time.Hour, "Rotate the log file after it has been written to for this long (0 disables)")
+	fs.IntVar(&cfg.log.file.maxBackups, "log-file-max-backups", 7, "Rotated log files to keep (0 keeps all)")
+	fs.BoolVar(&cfg.log.file.compress, "log-file-compress", true, "Gzip rotated log files")
+
+	fs.BoolVar(&cfg.accessLog.enabled, "access-log", true, "Log a line for every request")
+	fs.Float64Var(&cfg.accessLog.sampleRate, "access-log-sample-rate", 1, "Fraction of 2xx responses to log, from 0 to 1; other responses are always logged")
+	cfg.accessLog.redact = []string{"token", "password", "secret", "api_key", "access_token"}
+	fs.StringVar(&cfg.metrics.addr, "metrics-addr", "", "Serve /metrics on this internal address, such as 127.0.0.1:9090, instead of on the API port behind the metrics:view permission")
+	fs.DurationVar(&cfg.health.timeout, "health-check-timeout", 2*time.Second, "Time each readiness check may take before it counts as failing")
+	fs.DurationVar(&cfg.health.cacheTTL, "health-check-cache", time.Second, "How long a readiness result is reused before the checks run again (0 disables)")
+	fs.IntVar(&cfg.health.maxJobBacklog, "health-max-job-backlog", 1000, "Due jobs beyond which readiness reports degraded (0 disables)")
+	fs.DurationVar(&cfg.health.drainDelay, "shutdown-drain-delay", 0, "How long to keep serving, with readiness failing, before shutting down, so that load balancers stop sending traffic first")
+	fs.StringVar(&cfg.tracing.exporter, "trace-exporter", "none", "Where to send trace spans (none|stdout|otlp)")
+	fs.StringVar(&cfg.tracing.otlpEndpoint, "trace-otlp-endpoint", "http://localhost:4318/v1/traces", "OTLP/HTTP endpoint spans are posted to by the otlp exporter")
+	fs.Float64Var(&cfg.tracing.sampleRatio, "trace-sample-ratio", 1, "Share of new traces recorded, from 0 to 1; traces continued from a traceparent header follow its sampled flag")
+	fs.Func("access-log-redact", "Comma-separated query parameters whose values are redacted in the access log (default token,password,secret,api_key,access_token)", func(value string) error {
+		cfg.accessLog.redact = nil
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				cfg.accessLog.redact = append(cfg.accessLog.redact, name)
+			}
+		}
+		return nil
+	})
+
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username (also read from SMTP_USERNAME)")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password (also read from SMTP_PASSWORD)")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.abdulsamedarslan.net>", "SMTP sender")
 }
 
-func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.db.dsn)
+// openDB connects to the primary database and to every configured read
+// replica. The primary must be reachable. Replicas are not contacted here:
+// like every replica, one that cannot be reached is kept, and reads avoid it
+// until the replica monitor sees it answer.
+// Queries on every pool are recorded in stats, if it is not nil.
+func openDB(cfg config, stats *data.QueryStats) (*data.Cluster, error) {
+	primary, err := openPool(cfg.db.dsn, cfg.db, stats)
 	if err != nil {
 		return nil, err
 	}
 
-	db.SetMaxOpenConns(cfg.db.maxOpenConns)
-	db.SetMaxIdleConns(cfg.db.maxIdleConns)
+	var replicas []*sql.DB
+	for _, dsn := range cfg.db.replicas.dsns {
+		dsn = strings.TrimSpace(dsn)
+		if dsn == "" {
+			continue
+		}
+		replica, err := newPool(dsn, stats)
+		if err != nil {
+			return nil, err
+		}
+		if err := configurePool(replica, cfg.db); err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, replica)
+	}
+
+	return data.NewCluster(primary, replicas, cfg.db.replicas.stickyWindow), nil
+}
 
-	duration, err := time.ParseDuration(cfg.db.maxIdleTime)
+func openPool(dsn string, cfg dbConfig, stats *data.QueryStats) (*sql.DB, error) {
+	db, err := newPool(dsn, stats)
 	if err != nil {
 		return nil, err
 	}
 
-	db.SetConnMaxIdleTime(duration)
+	if err := configurePool(db, cfg); err != nil {
+		return nil, err
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -187,14 +497,43 @@ func openDB(cfg config) (*sql.DB, error) {
 	return db, nil
 }
 
+func newPool(dsn string, stats *data.QueryStats) (*sql.DB, error) {
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if stats == nil {
+		return sql.OpenDB(connector), nil
+	}
+	return sql.OpenDB(stats.Connector(connector)), nil
+}
+
+func configurePool(db *sql.DB, cfg dbConfig) error {
+	db.SetMaxOpenConns(cfg.maxOpenConns)
+	db.SetMaxIdleConns(cfg.maxIdleConns)
+
+	duration, err := time.ParseDuration(cfg.maxIdleTime)
+	if err != nil {
+		return err
+	}
+
+	db.SetConnMaxIdleTime(duration)
+	return nil
+}
+
 func (app *application) metrics(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		totalRequestsReceived.Add(1)
 
+		entry := &accessLogEntry{}
+		r = r.WithContext(contextWithAccessLogEntry(r.Context(), entry))
 		metrics := httpsnoop.CaptureMetrics(next, w, r)
 
 		totalResponsesSent.Add(1)
 		totalProcessingTimeMicroseconds.Add(metrics.Duration.Microseconds())
 		totalResponsesSentByStatus.Add(strconv.Itoa(metrics.Code), 1)
+		observeRequest(routeFromContext(r.Context()), r.Method, metrics.Code, metrics.Duration)
+
+		app.logAccess(r, entry, metrics)
 	})
 }
@@ -38,7 +38,7 @@ func TestOpenDB(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := openDB(tt.config)
+			_, err := openDB(tt.config, nil)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("openDB() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
+	"greenlight.samedarslan28.net/internal/compress"
 	"greenlight.samedarslan28.net/internal/data"
+	"greenlight.samedarslan28.net/internal/render"
+	"greenlight.samedarslan28.net/internal/trace"
 	"greenlight.samedarslan28.net/internal/validator"
 )
 
@@ -26,6 +32,47 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// requestID gives every request an ID that log lines and error responses
+// about the request refer to. An ID sent by the client or a proxy in the
+// X-Request-ID header is kept, so that a request can be followed across
+// services; otherwise a random one is generated. The ID is echoed in the
+// X-Request-ID response header.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestID(r, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID reports whether a client-supplied request ID is safe to log
+// and echo: 1 to 128 letters, digits and the punctuation found in UUIDs and
+// trace IDs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':' || c == '/' || c == '+' || c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 func (app *application) rateLimit(next http.Handler) http.Handler {
 	type client struct {
 		limiter  *rate.Limiter
@@ -58,18 +105,24 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 			return
 		}
 
-		if app.config.limiter.enabled {
+		if limits := app.settings().limiter; limits.enabled {
 			ip := realip.FromRequest(r)
 			mu.Lock()
 			if _, ok := clients[ip]; !ok {
 				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
+					limiter: rate.NewLimiter(rate.Limit(limits.rps), limits.burst),
 				}
 			}
 			clients[ip].lastSeen = time.Now()
+			// Limits reloaded on SIGHUP apply to known clients too.
+			if l := clients[ip].limiter; l.Limit() != rate.Limit(limits.rps) || l.Burst() != limits.burst {
+				l.SetLimit(rate.Limit(limits.rps))
+				l.SetBurst(limits.burst)
+			}
 
 			if !clients[ip].limiter.Allow() {
 				mu.Unlock()
+				rateLimitedTotal.Inc()
 				app.rateLimitExceededResponse(w, r)
 				return
 			}
@@ -89,6 +142,11 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		w.Header().Add("Vary", "Authorization")
+
+		// Until we know who the caller is, their reads and writes are tracked
+		// by client IP for read-your-writes routing.
+		r = r.WithContext(data.ContextWithSession(r.Context(), "ip:"+realip.FromRequest(r)))
+
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
 			r = app.contextSetUser(r, data.AnonymousUser)
@@ -109,7 +167,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
 		if err != nil {
 			if errors.Is(err, data.ErrRecordNotFound) {
 				app.invalidAuthenticationTokenResponse(w, r)
@@ -119,6 +177,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		r = r.WithContext(data.ContextWithSession(r.Context(), fmt.Sprintf("user:%d", user.ID)))
 		r = app.contextSetUser(r, user)
 		next.ServeHTTP(w, r)
 	})
@@ -149,7 +208,12 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	return app.requireActivatedUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
-		perms, err := app.models.Permissions.GetAllForUser(user.ID)
+
+		ctx, span := trace.Start(r.Context(), "permissions check", trace.KindInternal)
+		span.SetAttribute("permission", code)
+		perms, err := app.models.Permissions.GetAllForUser(ctx, user.ID)
+		span.RecordError(err)
+		span.End()
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -163,6 +227,37 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	}))
 }
 
+// compressResponse compresses responses for clients that accept it, unless
+// compression is disabled.
+func (app *application) compressResponse(next http.Handler) http.Handler {
+	if len(app.config.compression.encodings) == 0 {
+		return next
+	}
+	return compress.Middleware(compress.Options{
+		Encodings: app.config.compression.encodings,
+		MinSize:   app.config.compression.minSize,
+	})(next)
+}
+
+// requireAcceptable answers 406 Not Acceptable, before any work is done,
+// when the Accept header rules out every format responses can be sent in.
+// Clients that accept problem details are let through, and get JSON for
+// successful responses.
+func (app *application) requireAcceptable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept := r.Header.Get("Accept")
+		if render.Accepts(accept, render.ProblemJSON.MediaTypes[0]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, err := responseEncoders.Negotiate(accept); err != nil {
+			app.notAcceptableResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Skip Swagger
@@ -171,10 +266,23 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 			return
 		}
 
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		// Without trusted origins every origin is allowed. Otherwise only
+		// the listed ones are, and the header depends on the request.
+		if origins := app.settings().cors.trustedOrigins; len(origins) == 0 {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Add("Vary", "Origin")
+			origin := r.Header.Get("Origin")
+			if origin == "" || !slices.Contains(origins, origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, POST, PUT, PATCH, DELETE")
-			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Request-ID")
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"greenlight.samedarslan28.net/internal/jsonlog"
+	"greenlight.samedarslan28.net/internal/migrate"
+	"greenlight.samedarslan28.net/migrations"
+)
+
+// runMigrate implements the "migrate up|down|status|goto N" command.
+func runMigrate(logger *jsonlog.Logger, db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("migrate: expected up, down, status or goto N")
+	}
+
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	switch args[0] {
+	case "up":
+		err = m.Up(ctx)
+	case "down":
+		err = m.Down(ctx)
+	case "goto":
+		if len(args) != 2 {
+			return errors.New("migrate goto: expected a version number")
+		}
+		target, perr := strconv.ParseInt(args[1], 10, 64)
+		if perr != nil || target < 0 {
+			return fmt.Errorf("migrate goto: invalid version %q", args[1])
+		}
+		err = m.Goto(ctx, target)
+	case "status":
+		return printMigrationStatus(ctx, m)
+	default:
+		return fmt.Errorf("migrate: unknown subcommand %q", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	logger.PrintInfo("migrations complete", map[string]string{
+		"command": args[0],
+		"version": strconv.FormatInt(status.Current, 10),
+	})
+	return nil
+}
+
+func printMigrationStatus(ctx context.Context, m *migrate.Migrator) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "current version:\t%d\n", status.Current)
+	fmt.Fprintf(tw, "latest version:\t%d\n", status.Latest)
+	fmt.Fprintf(tw, "dirty:\t%t\n\n", status.Dirty)
+	for _, mig := range status.Migrations {
+		state := "pending"
+		if mig.Version <= status.Current {
+			state = "applied"
+		}
+		fmt.Fprintf(tw, "%06d\t%s\t%s\n", mig.Version, mig.Name, state)
+	}
+	return tw.Flush()
+}
+
+// checkSchemaVersion refuses to start the server against a database whose
+// schema is older than the migrations embedded in this binary. A newer schema
+// is allowed, since during a rolling deploy the old binary keeps serving
+// after the new one has migrated.
+func checkSchemaVersion(logger *jsonlog.Logger, db *sql.DB) error {
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case status.Dirty:
+		return fmt.Errorf("database schema is dirty at version %d", status.Current)
+	case status.Pending():
+		return fmt.Errorf("database schema is at version %d but this build needs version %d, run \"api migrate up\"", status.Current, status.Latest)
+	case status.Current > status.Latest:
+		logger.PrintInfo("database schema is newer than this build", map[string]string{
+			"schema_version":   strconv.FormatInt(status.Current, 10),
+			"expected_version": strconv.FormatInt(status.Latest, 10),
+		})
+	}
+	return nil
+}
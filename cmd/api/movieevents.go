@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"greenlight.samedarslan28.net/internal/data"
+	"greenlight.samedarslan28.net/internal/events"
+)
+
+// streamWriteTimeout bounds each write to an event stream. The server-wide
+// WriteTimeout would cut long-lived streams off, so streams lift it and
+// instead give up on clients that stop reading for this long.
+const streamWriteTimeout = 30 * time.Second
+
+// MovieEventsHandler godoc
+//
+//	@Summary		Stream movie changes
+//	@Description	Server-Sent Events stream of created, updated and deleted events carrying the movie ID and version. Reconnecting clients send Last-Event-ID to replay what they missed; a resync event means the missed events are no longer known and the client should reload.
+//	@Tags			movies
+//	@Produce		text/event-stream
+//	@Param			Last-Event-ID	header		int		false	"ID of the last event received"
+//	@Success		200				{string}	string	"event stream"
+//	@Failure		401				{object}	map[string]string
+//	@Router			/v1/movies/events [get]
+func (app *application) movieEventsHandler(w http.ResponseWriter, r *http.Request) {
+	lastID, resume := readLastEventID(r)
+
+	sub, replay, resync, err := app.movieEvents.Subscribe(lastID, resume)
+	if err != nil {
+		if errors.Is(err, events.ErrClosed) {
+			app.shuttingDownResponse(w, r)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	err = rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	if err != nil {
+		app.serverErrorResponse(w, r, fmt.Errorf("event stream: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(write func(io.Writer) error) bool {
+		if err := rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+			return false
+		}
+		if err := write(w); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	frames := []func(io.Writer) error{writeRetry}
+	if resync {
+		latest, ok := app.movieEvents.Latest()
+		frames = append(frames, writeResync(latest, ok))
+	}
+	for _, e := range replay {
+		frames = append(frames, writeEvent(e))
+	}
+	for _, frame := range frames {
+		if !send(frame) {
+			return
+		}
+	}
+
+	interval := app.config.events.heartbeat
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	heartbeat := time.NewTicker(interval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.C:
+			// A closed subscription means the client fell behind or the
+			// server is shutting down. Either way the client reconnects
+			// and resumes from Last-Event-ID.
+			if !ok || !send(writeEvent(e)) {
+				return
+			}
+		case <-heartbeat.C:
+			if !send(writeHeartbeat) {
+				return
+			}
+		}
+	}
+}
+
+// readLastEventID returns the ID sent by a reconnecting client. An ID that
+// cannot be parsed is treated as unknown, so the client is asked to resync.
+func readLastEventID(r *http.Request) (int64, bool) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return -1, true
+	}
+	return id, true
+}
+
+func writeEvent(e events.Event) func(io.Writer) error {
+	return func(w io.Writer) error {
+		js, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, js)
+		return err
+	}
+}
+
+// writeResync tells the client to reload its state. The id field moves the
+// client's Last-Event-ID to the newest event, or clears it when there is none,
+// so that it does not resync again on its next reconnect.
+func writeResync(latest int64, ok bool) func(io.Writer) error {
+	return func(w io.Writer) error {
+		id := ""
+		if ok {
+			id = strconv.FormatInt(latest, 10)
+		}
+		_, err := fmt.Fprintf(w, "id: %s\nevent: resync\ndata: {}\n\n", id)
+		return err
+	}
+}
+
+// writeRetry sets the client's reconnection delay to three seconds.
+func writeRetry(w io.Writer) error {
+	_, err := io.WriteString(w, "retry: 3000\n\n")
+	return err
+}
+
+func writeHeartbeat(w io.Writer) error {
+	_, err := io.WriteString(w, ": heartbeat\n\n")
+	return err
+}
+
+// invalidateMovieCache drops cached movies as the movie events feed reports
+// changes to them, so that changes made through other instances are not
+// served from this one's cache until it expires. Whenever the feed may have
+// missed changes the subscription ends, and the whole cache is purged.
+func (app *application) invalidateMovieCache(cache *data.MovieCache) {
+	for {
+		sub, _, _, err := app.movieEvents.Subscribe(0, false)
+		if err != nil {
+			return // the broker was closed
+		}
+		cache.Purge()
+		for e := range sub.C {
+			cache.Invalidate(e.MovieID)
+		}
+	}
+}
+
+// listenMovieEvents feeds the movie events broker from Postgres until ctx is
+// cancelled, retrying if the listener cannot be set up.
+func (app *application) listenMovieEvents(ctx context.Context) {
+	onError := func(err error) {
+		app.logger.PrintError(err, map[string]string{"task": "listen for movie events"})
+	}
+
+	for {
+		err := app.movieEvents.Listen(ctx, app.config.db.dsn, onError)
+		if err == nil {
+			return
+		}
+		onError(err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
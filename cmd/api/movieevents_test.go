@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"greenlight.samedarslan28.net/internal/events"
+	"greenlight.samedarslan28.net/internal/jsonlog"
+)
+
+func TestMovieEventsStream(t *testing.T) {
+	app := &application{
+		logger:      jsonlog.NewLogger(io.Discard, jsonlog.LevelInfo),
+		movieEvents: events.NewBroker(10),
+	}
+	app.config.events.heartbeat = time.Hour
+
+	app.movieEvents.Publish(events.Event{ID: 1, Type: events.Created, MovieID: 7, Version: 1})
+	app.movieEvents.Publish(events.Event{ID: 2, Type: events.Updated, MovieID: 7, Version: 2})
+
+	ts := httptest.NewServer(http.HandlerFunc(app.movieEventsHandler))
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("Last-Event-ID", "1")
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	lines := bufio.NewScanner(res.Body)
+	next := func() string {
+		t.Helper()
+		if !lines.Scan() {
+			t.Fatalf("stream ended early: %v", lines.Err())
+		}
+		return lines.Text()
+	}
+	expect := func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			if got := next(); got != w {
+				t.Fatalf("got line %q, want %q", got, w)
+			}
+		}
+	}
+
+	expect("retry: 3000", "")
+	expect("id: 2", "event: updated", `data: {"id":2,"type":"updated","movie_id":7,"version":2}`, "")
+
+	app.movieEvents.Publish(events.Event{ID: 3, Type: events.Deleted, MovieID: 7, Version: 2})
+	expect("id: 3", "event: deleted")
+	if got := next(); !strings.Contains(got, `"type":"deleted"`) {
+		t.Fatalf("unexpected data line %q", got)
+	}
+}
+
+func TestMovieEventsResync(t *testing.T) {
+	app := &application{movieEvents: events.NewBroker(10)}
+	app.config.events.heartbeat = time.Hour
+	app.movieEvents.Publish(events.Event{ID: 5, Type: events.Created})
+
+	ts := httptest.NewServer(http.HandlerFunc(app.movieEventsHandler))
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("Last-Event-ID", "4")
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	buf := make([]byte, 64)
+	var body string
+	for !strings.Contains(body, "event: resync") {
+		n, err := res.Body.Read(buf)
+		if err != nil {
+			t.Fatalf("reading stream: %v (got %q)", err, body)
+		}
+		body += string(buf[:n])
+	}
+	if !strings.Contains(body, "id: 5\nevent: resync") {
+		t.Fatalf("expected the resync to carry the latest id, got %q", body)
+	}
+}
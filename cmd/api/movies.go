@@ -47,11 +47,11 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	v := validator.New()
 	if data.ValidateMovie(v, movie); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(r.Context(), movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -60,9 +60,9 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
-		app.logger.PrintError(err, nil)
+		app.logger.PrintErrorContext(r.Context(), err, nil)
 		app.serverErrorResponse(w, r, err)
 		return
 	}
@@ -73,7 +73,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 //	@Summary		Get a single movie
 //	@Description	Retrieves a movie by its ID.
 //	@Tags			movies
-//	@Produce		json
+//	@Produce		json,xml,text/csv,application/msgpack
 //	@Param			id	path		int	true	"Movie ID"
 //	@Success		200	{object}	map[string]data.Movie
 //	@Failure		404	{object}	map[string]string
@@ -85,7 +85,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -96,7 +96,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, 200, envelope{"Movie": movie}, nil)
+	err = app.writeResponse(w, r, 200, envelope{"Movie": movie}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -123,7 +123,8 @@ func (app *application) updateMovieHandler(writer http.ResponseWriter, request *
 		app.notFoundResponse(writer, request)
 		return
 	}
-	movie, err := app.models.Movies.Get(id)
+	// Read from the primary so that the version we update against is current.
+	movie, err := app.models.Movies.Get(data.UsePrimary(request.Context()), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -163,16 +164,16 @@ func (app *application) updateMovieHandler(writer http.ResponseWriter, request *
 
 	v := validator.New()
 	if data.ValidateMovie(v, movie); !v.Valid() {
-		app.failedValidationResponse(writer, request, v.Errors)
+		app.failedValidationResponse(writer, request, v)
 		return
 	}
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(request.Context(), movie)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 		return
 	}
 
-	err = app.writeJSON(writer, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeResponse(writer, request, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 		return
@@ -196,23 +197,26 @@ func (app *application) deleteMovieHandler(writer http.ResponseWriter, request *
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	err = app.models.Movies.Delete(request.Context(), id)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 		return
 	}
-	err = app.writeJSON(writer, http.StatusOK, envelope{"message": "movie deleted"}, nil)
+	err = app.writeResponse(writer, request, http.StatusOK, envelope{"message": "movie deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 	}
 }
 
+// movieSortSafelist holds the sort values accepted for movie listings.
+var movieSortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
 // ListMoviesHandler godoc
 //
 //	@Summary		List all movies
 //	@Description	Retrieves a list of movies with optional filters, pagination, and sorting.
 //	@Tags			movies
-//	@Produce		json
+//	@Produce		json,xml,text/csv,application/msgpack
 //	@Param			title		query		string		false	"Filter by title"
 //	@Param			genres		query		[]string	false	"Filter by genres (comma separated)"
 //	@Param			page		query		int			false	"Page number"
@@ -220,6 +224,7 @@ func (app *application) deleteMovieHandler(writer http.ResponseWriter, request *
 //	@Param			sort		query		string		false	"Sort by field"
 //	@Success		200			{object}	map[string]interface{}
 //	@Failure		400			{object}	map[string]string
+//	@Failure		406			{object}	map[string]string
 //	@Router			/v1/movies [get]
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
@@ -237,28 +242,19 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	input.Filters.PageSize = app.readInt(urlValues, "page_size", 20, v)
 	input.Filters.Sort = app.readString(urlValues, "sort", "id")
 
-	input.Filters.SortSafelist = []string{
-		"id",
-		"title",
-		"year",
-		"runtime",
-		"-id",
-		"-title",
-		"-year",
-		"-runtime",
-	}
+	input.Filters.SortSafelist = movieSortSafelist
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	allItems, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	allItems, metadata, err := app.models.Movies.GetAll(r.Context(), input.Title, input.Genres, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 	d := envelope{"movies": allItems, "metadata": metadata}
-	err = app.writeJSON(w, http.StatusOK, d, nil)
+	err = app.writeResponse(w, r, http.StatusOK, d, nil)
 
 }
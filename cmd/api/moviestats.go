@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+)
+
+// MovieStatsHandler godoc
+//
+//	@Summary		Catalogue statistics
+//	@Description	Returns movie counts per genre and decade, runtime averages and recent additions. Without filters the figures come from a periodically refreshed snapshot; refreshed_at says how old it is.
+//	@Tags			movies
+//	@Produce		json
+//	@Param			title	query		string		false	"Filter by title"
+//	@Param			genres	query		[]string	false	"Filter by genres (comma separated)"
+//	@Success		200		{object}	map[string]data.MovieStats
+//	@Failure		401		{object}	map[string]string
+//	@Router			/v1/movies/stats [get]
+func (app *application) movieStatsHandler(w http.ResponseWriter, r *http.Request) {
+	urlValues := r.URL.Query()
+	title := app.readString(urlValues, "title", "")
+	genres := app.readCSV(urlValues, "genres", []string{})
+
+	stats, err := app.models.Movies.Stats(r.Context(), title, genres)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"stats": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"greenlight.samedarslan28.net/internal/data"
+	"greenlight.samedarslan28.net/internal/metrics"
+)
+
+// registry holds the metrics served at /metrics, alongside the expvar
+// totals at /debug/vars.
+var (
+	registry = metrics.NewRegistry()
+
+	httpRequestsTotal = registry.NewCounterVec("greenlight_http_requests_total",
+		"HTTP requests served, by route pattern, method and status.",
+		"route", "method", "status")
+	httpRequestDuration = registry.NewHistogramVec("greenlight_http_request_duration_seconds",
+		"Time taken to serve HTTP requests, by route pattern, method and status.",
+		metrics.DefaultBuckets, "route", "method", "status")
+	rateLimitedTotal = registry.NewCounter("greenlight_rate_limited_requests_total",
+		"Requests turned away by the rate limiter.")
+	mailSentTotal = registry.NewCounterVec("greenlight_mail_sent_total",
+		"Emails sent, by template and outcome (success or failure).",
+		"template", "outcome")
+)
+
+func init() {
+	registry.NewGaugeFunc("greenlight_build_info", "Always 1, labelled with the running version.",
+		[]string{"version"}, func(emit func(float64, ...string)) {
+			emit(1, version)
+		})
+	registry.NewGaugeFunc("go_goroutines", "Goroutines that currently exist.",
+		nil, func(emit func(float64, ...string)) {
+			emit(float64(runtime.NumGoroutine()))
+		})
+}
+
+// observeRequest records a finished request in the HTTP metrics.
+func observeRequest(route, method string, status int, duration time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	// Clients choose the method, so anything nonstandard is folded into one
+	// label value to keep the number of series bounded.
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+	default:
+		method = "other"
+	}
+	code := strconv.Itoa(status)
+	httpRequestsTotal.WithLabelValues(route, method, code).Inc()
+	httpRequestDuration.WithLabelValues(route, method, code).Observe(duration.Seconds())
+}
+
+// observeMail records the outcome of sending an email built from template.
+func observeMail(template string, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	mailSentTotal.WithLabelValues(template, outcome).Inc()
+}
+
+// registerDBMetrics adds the connection pool statistics of the primary and
+// of every replica, labelled by pool name.
+func registerDBMetrics(db *data.Cluster) {
+	pools := func(emit func(float64, ...string), value func(sql.DBStats) float64) {
+		emit(value(db.Primary.Stats()), "primary")
+		for name, stats := range db.ReplicaStats() {
+			emit(value(stats), name)
+		}
+	}
+	gauge := func(name, help string, value func(sql.DBStats) float64) {
+		registry.NewGaugeFunc(name, help, []string{"pool"}, func(emit func(float64, ...string)) {
+			pools(emit, value)
+		})
+	}
+	counter := func(name, help string, value func(sql.DBStats) float64) {
+		registry.NewCounterFunc(name, help, []string{"pool"}, func(emit func(float64, ...string)) {
+			pools(emit, value)
+		})
+	}
+
+	gauge("greenlight_db_max_open_connections", "Maximum number of open connections to the database.",
+		func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	gauge("greenlight_db_open_connections", "Established connections, in use or idle.",
+		func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	gauge("greenlight_db_in_use_connections", "Connections currently in use.",
+		func(s sql.DBStats) float64 { return float64(s.InUse) })
+	gauge("greenlight_db_idle_connections", "Idle connections.",
+		func(s sql.DBStats) float64 { return float64(s.Idle) })
+	counter("greenlight_db_wait_total", "Connections waited for.",
+		func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	counter("greenlight_db_wait_duration_seconds_total", "Time spent waiting for connections.",
+		func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+	counter("greenlight_db_max_idle_closed_total", "Connections closed because of the idle connection limit.",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) })
+	counter("greenlight_db_max_idle_time_closed_total", "Connections closed because of the idle time limit.",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) })
+	counter("greenlight_db_max_lifetime_closed_total", "Connections closed because of the lifetime limit.",
+		func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) })
+}
+
+// serveMetrics serves /metrics, without authentication, on the internal
+// listener given by -metrics-addr until ctx is cancelled.
+func (app *application) serveMetrics(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", registry.Handler())
+	srv := &http.Server{
+		Addr:         app.config.metrics.addr,
+		Handler:      mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	app.logger.PrintInfo("starting metrics server", map[string]string{"address": srv.Addr})
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		app.logger.PrintError(err, map[string]string{"task": "serve metrics"})
+	}
+}
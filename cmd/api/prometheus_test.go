@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"greenlight.samedarslan28.net/internal/jsonlog"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	app := &application{logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelInfo)}
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous GET /metrics = %d; want %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	// On an internal listener, the API port does not serve /metrics at all.
+	app.config.metrics.addr = "127.0.0.1:9090"
+	rr = httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("GET /metrics with -metrics-addr = %d; want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestRequestMetrics(t *testing.T) {
+	app := &application{logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelInfo)}
+	routes := app.routes()
+	for _, path := range []string{"/v1/healthcheck", "/v1/nowhere"} {
+		routes.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	routes.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/v1/nowhere", nil))
+
+	rr := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rr.Body.String()
+
+	for _, want := range []string{
+		`greenlight_http_requests_total{route="/v1/healthcheck",method="GET",status="200"} `,
+		`greenlight_http_requests_total{route="unmatched",method="GET",status="404"} `,
+		`greenlight_http_requests_total{route="unmatched",method="other",status="404"} `,
+		`greenlight_http_request_duration_seconds_bucket{route="/v1/healthcheck",method="GET",status="200",le="+Inf"} `,
+		"go_goroutines ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+}
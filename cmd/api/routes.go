@@ -39,43 +39,115 @@ package main
 import (
 	"expvar"
 	"net/http"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
 	httpSwagger "github.com/swaggo/http-swagger"
 	_ "greenlight.samedarslan28.net/docs"
+	"greenlight.samedarslan28.net/internal/trace"
 )
 
 func (app *application) routes() http.Handler {
 	router := httprouter.New()
 
-	router.NotFound = http.HandlerFunc(app.notFoundResponse)
-	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+	// handle registers a route and records its pattern for the access log.
+	handle := func(method, pattern string, handler http.Handler) {
+		router.Handler(method, pattern, withRoute(pattern, handler))
+	}
 
-	base := alice.New(
-		app.recoverPanic,
-		app.enableCORS,
-		app.rateLimit,
-		app.authenticate,
+	// Unrouted requests skip the middleware chains below, but are still
+	// counted and logged, with an ID for their error responses.
+	router.NotFound = alice.New(app.requestID, app.traceRequest, app.metrics).ThenFunc(app.notFoundResponse)
+	router.MethodNotAllowed = alice.New(app.requestID, app.traceRequest, app.metrics).ThenFunc(app.methodNotAllowedResponse)
+
+	// stream is for endpoints with a fixed response format, such as event
+	// streams and GraphQL. Everything else goes through base, which
+	// negotiates the format from the Accept header. metrics comes early so
+	// that requests turned away by the rate limiter or authentication are
+	// counted and logged too. Each middleware after metrics is timed in its
+	// own span of the request trace.
+	traced := func(name string, mw alice.Constructor) alice.Constructor {
+		return trace.Middleware("middleware "+name, mw)
+	}
+	stream := alice.New(
+		app.requestID,
+		app.traceRequest,
 		app.metrics,
+		traced("recoverPanic", app.recoverPanic),
+		traced("compressResponse", app.compressResponse),
+		traced("enableCORS", app.enableCORS),
+		traced("rateLimit", app.rateLimit),
+		traced("authenticate", app.authenticate),
 	)
+	base := stream.Append(traced("requireAcceptable", app.requireAcceptable))
+
+	// Probes skip rate limiting and authentication, so that load balancers
+	// and orchestrators are never turned away.
+	probe := alice.New(app.requestID, app.metrics, app.recoverPanic)
+	handle(http.MethodGet, "/v1/health/live", probe.ThenFunc(app.livenessHandler))
+	handle(http.MethodGet, "/v1/health/ready", probe.ThenFunc(app.readinessHandler))
 
 	// Public routes
-	router.Handler(http.MethodGet, "/v1/healthcheck", base.ThenFunc(app.healthCheckerHandler))
-	router.Handler(http.MethodPost, "/v1/users", base.ThenFunc(app.registerUserHandler))
-	router.Handler(http.MethodPut, "/v1/users/activated", base.ThenFunc(app.activateUserHandler))
-	router.Handler(http.MethodPost, "/v1/tokens/authentication", base.ThenFunc(app.createAuthenticationTokenHandler))
+	handle(http.MethodGet, "/v1/healthcheck", base.ThenFunc(app.healthCheckerHandler))
+	handle(http.MethodPost, "/v1/users", base.ThenFunc(app.registerUserHandler))
+	handle(http.MethodPut, "/v1/users/activated", base.ThenFunc(app.activateUserHandler))
+	handle(http.MethodPost, "/v1/tokens/authentication", base.ThenFunc(app.createAuthenticationTokenHandler))
 
 	// Movie routes with permission checks
-	router.Handler(http.MethodGet, "/v1/movies", base.ThenFunc(app.requirePermission("movies:read", app.listMoviesHandler)))
-	router.Handler(http.MethodPost, "/v1/movies", base.ThenFunc(app.requirePermission("movies:write", app.createMovieHandler)))
-	router.Handler(http.MethodGet, "/v1/movies/:id", base.ThenFunc(app.requirePermission("movies:read", app.showMovieHandler)))
-	router.Handler(http.MethodPatch, "/v1/movies/:id", base.ThenFunc(app.requirePermission("movies:write", app.updateMovieHandler)))
-	router.Handler(http.MethodDelete, "/v1/movies/:id", base.ThenFunc(app.requirePermission("movies:write", app.deleteMovieHandler)))
+	handle(http.MethodGet, "/v1/movies", base.ThenFunc(app.requirePermission("movies:read", app.listMoviesHandler)))
+	handle(http.MethodPost, "/v1/movies", base.ThenFunc(app.requirePermission("movies:write", app.createMovieHandler)))
+	handle(http.MethodGet, "/v1/movies/:id", staticOr(map[string]http.Handler{
+		"stats":  base.ThenFunc(app.requirePermission("movies:read", app.movieStatsHandler)),
+		"events": stream.ThenFunc(app.requirePermission("movies:read", app.movieEventsHandler)),
+	}, base.ThenFunc(app.requirePermission("movies:read", app.showMovieHandler))))
+	handle(http.MethodPatch, "/v1/movies/:id", base.ThenFunc(app.requirePermission("movies:write", app.updateMovieHandler)))
+	handle(http.MethodDelete, "/v1/movies/:id", base.ThenFunc(app.requirePermission("movies:write", app.deleteMovieHandler)))
+
+	// GraphQL; the resolvers check permissions per field
+	handle(http.MethodPost, "/v1/graphql", stream.ThenFunc(app.graphqlHandler()))
+
+	// Webhook subscriptions
+	handle(http.MethodGet, "/v1/webhooks", base.ThenFunc(app.requirePermission("webhooks:manage", app.listWebhooksHandler)))
+	handle(http.MethodPost, "/v1/webhooks", base.ThenFunc(app.requirePermission("webhooks:manage", app.createWebhookHandler)))
+	handle(http.MethodGet, "/v1/webhooks/:id", base.ThenFunc(app.requirePermission("webhooks:manage", app.showWebhookHandler)))
+	handle(http.MethodDelete, "/v1/webhooks/:id", base.ThenFunc(app.requirePermission("webhooks:manage", app.deleteWebhookHandler)))
+	handle(http.MethodGet, "/v1/webhooks/:id/deliveries", base.ThenFunc(app.requirePermission("webhooks:manage", app.listWebhookDeliveriesHandler)))
+	handle(http.MethodPost, "/v1/webhooks/:id/deliveries/:delivery_id/replay", base.ThenFunc(app.requirePermission("webhooks:manage", app.replayWebhookDeliveryHandler)))
+
+	// Background jobs
+	handle(http.MethodGet, "/v1/jobs", base.ThenFunc(app.requirePermission("jobs:manage", app.listJobsHandler)))
+	handle(http.MethodGet, "/v1/jobs/:id", base.ThenFunc(app.requirePermission("jobs:manage", app.showJobHandler)))
+	handle(http.MethodPost, "/v1/jobs/:id/requeue", base.ThenFunc(app.requirePermission("jobs:manage", app.requeueJobHandler)))
 
-	router.Handler(http.MethodGet, "/debug/vars", base.Then(expvar.Handler()))
+	// Operations
+	handle(http.MethodGet, "/v1/ops/tasks", base.ThenFunc(app.requirePermission("ops:view", app.listScheduledTasksHandler)))
 
-	router.Handler(http.MethodGet, "/v1/swagger/*any", httpSwagger.WrapHandler)
+	handle(http.MethodGet, "/debug/vars", stream.Then(expvar.Handler()))
+
+	// With -metrics-addr set, /metrics is served on that internal listener
+	// instead; see serveMetrics.
+	if app.config.metrics.addr == "" {
+		handle(http.MethodGet, "/metrics", stream.ThenFunc(app.requirePermission("metrics:view", registry.Handler().ServeHTTP)))
+	}
+
+	handle(http.MethodGet, "/v1/swagger/*any", httpSwagger.WrapHandler)
 
 	return router
 }
+
+// staticOr routes requests whose :id parameter is one of the names in static
+// to the matching handler, and every other request to next. httprouter does
+// not allow static segments such as /v1/movies/stats alongside the
+// /v1/movies/:id wildcard, so those routes are dispatched here instead.
+func staticOr(static map[string]http.Handler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+		if h, ok := static[id]; ok {
+			route := strings.Replace(routeFromContext(r.Context()), ":id", id, 1)
+			h.ServeHTTP(w, contextSetRoute(r, route))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
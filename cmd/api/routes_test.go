@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"greenlight.samedarslan28.net/internal/jsonlog"
+)
+
+func TestStaticOr(t *testing.T) {
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		})
+	}
+
+	router := httprouter.New()
+	router.Handler(http.MethodGet, "/v1/movies/:id", staticOr(map[string]http.Handler{
+		"stats": named("stats"),
+	}, named("movie")))
+
+	for path, want := range map[string]string{
+		"/v1/movies/stats": "stats",
+		"/v1/movies/42":    "movie",
+		"/v1/movies/statz": "movie",
+	} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if got := rr.Body.String(); got != want {
+			t.Errorf("GET %s served by %q, want %q", path, got, want)
+		}
+	}
+}
+
+// httprouter panics on conflicting routes, so building the router is enough
+// to catch them.
+func TestRoutesRegister(t *testing.T) {
+	app := &application{}
+	if app.routes() == nil {
+		t.Fatal("routes() returned nil")
+	}
+}
+
+func TestContentNegotiation(t *testing.T) {
+	app := &application{logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelInfo)}
+	routes := app.routes()
+
+	tests := []struct {
+		path, accept string
+		status       int
+		contentType  string
+	}{
+		{"/v1/healthcheck", "", http.StatusOK, "application/json"},
+		{"/v1/healthcheck", "text/csv", http.StatusOK, "text/csv; charset=utf-8"},
+		{"/v1/healthcheck", "application/xml", http.StatusOK, "application/xml; charset=utf-8"},
+		{"/v1/healthcheck", "application/msgpack", http.StatusOK, "application/msgpack"},
+		{"/v1/healthcheck", "text/html", http.StatusNotAcceptable, "application/json"},
+		{"/v1/nowhere", "application/xml", http.StatusNotFound, "application/xml; charset=utf-8"},
+		// Errors are not tables, so CSV clients get them as JSON.
+		{"/v1/nowhere", "text/csv", http.StatusNotFound, "application/json"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, r)
+
+		if rr.Code != tt.status || rr.Header().Get("Content-Type") != tt.contentType {
+			t.Errorf("GET %s with Accept %q: got %d %q; want %d %q",
+				tt.path, tt.accept, rr.Code, rr.Header().Get("Content-Type"), tt.status, tt.contentType)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	app := &application{logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelInfo)}
+	routes := app.routes()
+
+	tests := []struct {
+		sent string
+		kept bool
+	}{
+		{"", false},
+		{"3f2a9c1e-6b7d-4e0a-9c8b-1d2e3f4a5b6c", true},
+		{"bad id\nwith newline", false},
+		{strings.Repeat("a", 129), false},
+	}
+
+	for _, tt := range tests {
+		// Anonymous requests for movies are refused by requirePermission.
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+		if tt.sent != "" {
+			r.Header.Set("X-Request-ID", tt.sent)
+		}
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, r)
+
+		id := rr.Header().Get("X-Request-ID")
+		if id == "" || (id == tt.sent) != tt.kept {
+			t.Errorf("sent %q: X-Request-ID = %q", tt.sent, id)
+		}
+		var body map[string]string
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil || body["request_id"] != id {
+			t.Errorf("sent %q: error body = %v (%v); want request_id %q", tt.sent, body, err, id)
+		}
+	}
+}
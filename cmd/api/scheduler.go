@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"greenlight.samedarslan28.net/internal/data"
+	"greenlight.samedarslan28.net/internal/scheduler"
+)
+
+// schedulerLock names the advisory lock whose holder runs scheduled tasks.
+const schedulerLock = "greenlight:scheduler"
+
+// newScheduler returns the scheduler with the maintenance tasks registered.
+// A task whose schedule is empty is not registered.
+func (app *application) newScheduler() (*scheduler.Scheduler, error) {
+	s := &scheduler.Scheduler{
+		Elector:  app.models.AdvisoryLock(schedulerLock),
+		Recorder: app.models.Tasks,
+		Timeout:  app.config.scheduler.timeout,
+		OnError: func(err error, properties map[string]string) {
+			app.logger.PrintError(err, properties)
+		},
+		OnRun: func(name string, duration time.Duration) {
+			app.logger.PrintInfo("ran scheduled task", map[string]string{
+				"task":     name,
+				"duration": duration.String(),
+			})
+		},
+	}
+
+	cfg := app.config.scheduler
+	for _, task := range []struct {
+		name, spec string
+		fn         scheduler.Func
+	}{
+		{"purge-expired-tokens", cfg.purgeTokens, app.purgeExpiredTokens},
+		{"purge-unactivated-users", cfg.purgeUnactivated, app.purgeUnactivatedUsers},
+		{"refresh-movie-stats", cfg.refreshStats, app.models.Movies.RefreshStats},
+	} {
+		if task.spec == "" {
+			continue
+		}
+		if err := s.Register(task.name, task.spec, task.fn); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// runScheduler runs scheduled tasks until ctx is cancelled. Every instance
+// runs it; the advisory lock makes sure only one of them runs the tasks.
+func (app *application) runScheduler(ctx context.Context) {
+	app.scheduler.Run(ctx)
+}
+
+func (app *application) purgeExpiredTokens(ctx context.Context) error {
+	n, err := app.models.Tokens.DeleteExpired(ctx)
+	if err != nil {
+		return err
+	}
+	app.logger.PrintInfo("purged expired tokens", map[string]string{"deleted": strconv.FormatInt(n, 10)})
+	return nil
+}
+
+func (app *application) purgeUnactivatedUsers(ctx context.Context) error {
+	age := time.Duration(app.config.scheduler.unactivatedAge) * 24 * time.Hour
+	n, err := app.models.Users.DeleteUnactivated(ctx, age)
+	if err != nil {
+		return err
+	}
+	app.logger.PrintInfo("purged unactivated users", map[string]string{
+		"deleted":  strconv.FormatInt(n, 10),
+		"age_days": strconv.Itoa(app.config.scheduler.unactivatedAge),
+	})
+	return nil
+}
+
+// scheduledTaskStatus combines this instance's view of a task with the last
+// run recorded by whichever instance ran it.
+type scheduledTaskStatus struct {
+	scheduler.TaskStatus
+	LastRun *data.ScheduledTask `json:"last_run,omitempty"`
+}
+
+// ListScheduledTasksHandler godoc
+//
+//	@Summary		Scheduled task status
+//	@Description	Lists the scheduled maintenance tasks with their next run and the outcome of their last run. leader says whether the instance that answered is the one running the tasks.
+//	@Tags			ops
+//	@Produce		json
+//	@Success		200	{object}	map[string]interface{}
+//	@Router			/v1/ops/tasks [get]
+func (app *application) listScheduledTasksHandler(w http.ResponseWriter, r *http.Request) {
+	runs, err := app.models.Tasks.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	lastRuns := make(map[string]*data.ScheduledTask, len(runs))
+	for _, run := range runs {
+		lastRuns[run.Name] = run
+	}
+
+	tasks := []scheduledTaskStatus{}
+	for _, status := range app.scheduler.Status() {
+		tasks = append(tasks, scheduledTaskStatus{TaskStatus: status, LastRun: lastRuns[status.Name]})
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"leader": app.scheduler.Leader(), "tasks": tasks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
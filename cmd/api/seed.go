@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	"greenlight.samedarslan28.net/internal/data"
+	"greenlight.samedarslan28.net/internal/jsonlog"
+	"greenlight.samedarslan28.net/internal/validator"
+)
+
+// fixtures is the layout of a seed file. Runtimes are plain minutes and token
+// lifetimes are Go durations such as "72h".
+type fixtures struct {
+	Movies []movieFixture `json:"movies" yaml:"movies"`
+	Users  []userFixture  `json:"users" yaml:"users"`
+}
+
+type movieFixture struct {
+	Title   string   `json:"title" yaml:"title"`
+	Year    int32    `json:"year" yaml:"year"`
+	Runtime int32    `json:"runtime" yaml:"runtime"`
+	Genres  []string `json:"genres" yaml:"genres"`
+}
+
+type userFixture struct {
+	Name        string         `json:"name" yaml:"name"`
+	Email       string         `json:"email" yaml:"email"`
+	Password    string         `json:"password" yaml:"password"`
+	Activated   bool           `json:"activated" yaml:"activated"`
+	Permissions []string       `json:"permissions" yaml:"permissions"`
+	Tokens      []tokenFixture `json:"tokens" yaml:"tokens"`
+}
+
+type tokenFixture struct {
+	Scope string `json:"scope" yaml:"scope"`
+	Token string `json:"token" yaml:"token"`
+	TTL   string `json:"ttl" yaml:"ttl"`
+}
+
+// seedStats counts what a seed run changed.
+type seedStats struct {
+	moviesCreated, moviesSkipped               int
+	usersCreated, usersUpdated, usersUnchanged int
+	tokens                                     int
+}
+
+// runSeed implements the "seed [-file path] [-random N]" command. Running it
+// twice with the same file leaves the database as a single run would, except
+// for random movies, which are always new.
+func runSeed(logger *jsonlog.Logger, models data.Models, args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	file := fs.String("file", "", "Fixture file to load (.yaml, .yml or .json)")
+	random := fs.Int("random", 0, "Number of random movies to generate")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" && *random <= 0 {
+		return errors.New("seed: provide -file, -random or both")
+	}
+
+	var fx fixtures
+	if *file != "" {
+		var err error
+		fx, err = readFixtures(*file)
+		if err != nil {
+			return err
+		}
+	}
+
+	movies, err := fixtureMovies(fx.Movies)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < *random; i++ {
+		movies = append(movies, randomMovie(i))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	var stats seedStats
+	err = models.WithTx(ctx, func(m data.Models) error {
+		stats = seedStats{}
+		for _, movie := range movies {
+			if err := seedMovie(ctx, m, movie, &stats); err != nil {
+				return err
+			}
+		}
+		for _, user := range fx.Users {
+			if err := seedUser(ctx, m, user, &stats); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.PrintInfo("seed complete", map[string]string{
+		"movies_created":  strconv.Itoa(stats.moviesCreated),
+		"movies_skipped":  strconv.Itoa(stats.moviesSkipped),
+		"users_created":   strconv.Itoa(stats.usersCreated),
+		"users_updated":   strconv.Itoa(stats.usersUpdated),
+		"users_unchanged": strconv.Itoa(stats.usersUnchanged),
+		"tokens":          strconv.Itoa(stats.tokens),
+	})
+	return nil
+}
+
+func readFixtures(path string) (fixtures, error) {
+	var fx fixtures
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return fx, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(contents))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&fx)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(contents, &fx)
+	default:
+		return fx, fmt.Errorf("seed: unsupported fixture format %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return fx, fmt.Errorf("seed: reading %s: %w", path, err)
+	}
+	return fx, nil
+}
+
+// fixtureMovies converts and validates the movie fixtures, reporting every
+// invalid movie at once.
+func fixtureMovies(fixtures []movieFixture) ([]*data.Movie, error) {
+	var movies []*data.Movie
+	var problems []string
+	for i, f := range fixtures {
+		movie := &data.Movie{
+			Title:   f.Title,
+			Year:    f.Year,
+			Runtime: data.Runtime(f.Runtime),
+			Genres:  f.Genres,
+		}
+		v := validator.New()
+		if data.ValidateMovie(v, movie); !v.Valid() {
+			problems = append(problems, fmt.Sprintf("movie %d (%q): %v", i, f.Title, v.Errors))
+			continue
+		}
+		movies = append(movies, movie)
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("seed: invalid movies:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return movies, nil
+}
+
+func seedMovie(ctx context.Context, m data.Models, movie *data.Movie, stats *seedStats) error {
+	_, err := m.Movies.GetByTitle(ctx, movie.Title, movie.Year)
+	switch {
+	case err == nil:
+		stats.moviesSkipped++
+		return nil
+	case !errors.Is(err, data.ErrRecordNotFound):
+		return err
+	}
+
+	stats.moviesCreated++
+	return m.Movies.Insert(ctx, movie)
+}
+
+func seedUser(ctx context.Context, m data.Models, f userFixture, stats *seedStats) error {
+	user, err := m.Users.GetByEmail(ctx, f.Email)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		user = &data.User{Email: f.Email}
+	case err != nil:
+		return err
+	}
+
+	// Hashing a password is deliberately slow and yields a new hash every
+	// time, so an existing user keeps theirs when it already matches.
+	changed := user.ID == 0 || user.Name != f.Name || user.Activated != f.Activated
+	passwordMatches := false
+	if user.ID != 0 {
+		passwordMatches, err = user.Password.Matches(f.Password)
+		if err != nil {
+			return err
+		}
+	}
+	user.Name = f.Name
+	user.Activated = f.Activated
+	if !passwordMatches {
+		changed = true
+		err = user.Password.Set(f.Password)
+		if err != nil {
+			return err
+		}
+	}
+
+	v := validator.New()
+	if data.ValidateUser(v, user); !v.Valid() {
+		return fmt.Errorf("seed: invalid user %q: %v", f.Email, v.Errors)
+	}
+
+	switch {
+	case user.ID == 0:
+		err = m.Users.Insert(ctx, user)
+		stats.usersCreated++
+	case changed:
+		err = m.Users.Update(ctx, user)
+		stats.usersUpdated++
+	default:
+		stats.usersUnchanged++
+	}
+	if err != nil {
+		return fmt.Errorf("seed: user %q: %w", f.Email, err)
+	}
+
+	if len(f.Permissions) > 0 {
+		known, err := m.Permissions.GetAll(ctx)
+		if err != nil {
+			return err
+		}
+		var unknown []string
+		for _, code := range f.Permissions {
+			if !known.Include(code) {
+				unknown = append(unknown, code)
+			}
+		}
+		if len(unknown) > 0 {
+			return fmt.Errorf("seed: user %q: unknown permission codes %s (known: %s)", f.Email, strings.Join(unknown, ", "), strings.Join(known, ", "))
+		}
+
+		err = m.Permissions.AddForUser(ctx, user.ID, f.Permissions...)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, tf := range f.Tokens {
+		v := validator.New()
+		data.ValidateTokenPlaintext(v, tf.Token)
+		v.CheckCode(validator.In(tf.Scope, data.ScopeActivation, data.ScopeAuthentication), "scope", validator.CodeNotAllowed, "must be activation or authentication")
+		ttl, err := time.ParseDuration(tf.TTL)
+		v.CheckCode(err == nil && ttl > 0, "ttl", validator.CodeTooSmall, "must be a positive duration")
+		if !v.Valid() {
+			return fmt.Errorf("seed: invalid token for %q: %v", f.Email, v.Errors)
+		}
+
+		err = m.Tokens.Upsert(ctx, data.TokenFromPlaintext(tf.Token, user.ID, ttl, tf.Scope))
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return fmt.Errorf("seed: token for %q is already issued to another user or scope", f.Email)
+		case err != nil:
+			return err
+		}
+		stats.tokens++
+	}
+	return nil
+}
+
+var (
+	randomTitleWords = []string{"Silent", "Crimson", "Last", "Midnight", "Hidden", "Broken", "Golden", "Distant", "Electric", "Forgotten"}
+	randomTitleNouns = []string{"Harbor", "Empire", "Garden", "Signal", "Frontier", "Orchard", "Machine", "River", "Witness", "Summer"}
+	randomGenres     = []string{"action", "adventure", "animation", "comedy", "crime", "documentary", "drama", "fantasy", "horror", "romance", "sci-fi", "thriller", "western"}
+)
+
+// randomMovie returns a movie that passes data.ValidateMovie. n keeps the
+// titles of one run distinct.
+func randomMovie(n int) *data.Movie {
+	for {
+		genres := rand.Perm(len(randomGenres))[:1+rand.Intn(3)]
+		movie := &data.Movie{
+			Title: fmt.Sprintf("The %s %s %d",
+				randomTitleWords[rand.Intn(len(randomTitleWords))],
+				randomTitleNouns[rand.Intn(len(randomTitleNouns))],
+				n+1),
+			Year:    int32(1888 + rand.Intn(time.Now().Year()-1888+1)),
+			Runtime: data.Runtime(60 + rand.Intn(120)),
+		}
+		for _, g := range genres {
+			movie.Genres = append(movie.Genres, randomGenres[g])
+		}
+
+		v := validator.New()
+		if data.ValidateMovie(v, movie); v.Valid() {
+			return movie
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"greenlight.samedarslan28.net/internal/data"
+	"greenlight.samedarslan28.net/internal/validator"
+)
+
+func TestReadDevelopmentFixtures(t *testing.T) {
+	fx, err := readFixtures("../../fixtures/development.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fx.Movies) == 0 || len(fx.Users) == 0 {
+		t.Fatalf("expected movies and users, got %d and %d", len(fx.Movies), len(fx.Users))
+	}
+	if _, err := fixtureMovies(fx.Movies); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRandomMovieIsValid(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		v := validator.New()
+		if data.ValidateMovie(v, randomMovie(i)); !v.Valid() {
+			t.Fatalf("random movie failed validation: %v", v.Errors)
+		}
+	}
+}
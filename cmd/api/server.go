@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,28 +14,67 @@ import (
 )
 
 func (app *application) serve() error {
+	// Every request context derives from baseCtx, so cancelling it aborts the
+	// database queries of requests that outlive the shutdown grace period.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.port),
 		Handler:      app.routes(),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
 	}
+	// Event streams never finish on their own, so end them as soon as the
+	// shutdown starts instead of letting them hold it up.
+	srv.RegisterOnShutdown(app.movieEvents.Close)
+
 	shutdownError := make(chan error)
 
+	go app.listenMovieEvents(baseCtx)
+	if cache := app.models.Movies.Cache; cache != nil {
+		go app.invalidateMovieCache(cache)
+	}
+	go app.handleHangup(baseCtx)
+	if app.config.metrics.addr != "" {
+		go app.serveMetrics(baseCtx)
+	}
+
+	// The queue workers and the scheduler stop taking new work when baseCtx
+	// is cancelled and the shutdown below waits for what they have in hand.
+	for _, worker := range []func(context.Context){app.runWebhookWorker, app.runJobs, app.runScheduler} {
+		app.wg.Add(1)
+		go func() {
+			defer app.wg.Done()
+			worker(baseCtx)
+		}()
+	}
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 		s := <-quit
 
+		// Fail readiness first, and keep serving for the drain delay, so
+		// that load balancers move traffic elsewhere before the listener
+		// closes.
+		app.shuttingDown.Store(true)
 		app.logger.PrintInfo("shutting down server", map[string]string{
-			"signal": s.String(),
+			"signal":      s.String(),
+			"drain_delay": app.config.health.drainDelay.String(),
 		})
+		time.Sleep(app.config.health.drainDelay)
 
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
 		err := srv.Shutdown(ctx)
+		cancelBase()
 		if err != nil {
 			shutdownError <- err
 		}
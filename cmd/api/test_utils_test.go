@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/joho/godotenv"
 	"log"
+	"os"
 	"sync"
 )
 
@@ -17,3 +18,12 @@ func LoadEnvOnce(path string) {
 		}
 	})
 }
+
+// mustGetEnv returns the value of an environment variable the tests need.
+func mustGetEnv(key string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		log.Fatalf("Environment variable %s is not set", key)
+	}
+	return value
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/felixge/httpsnoop"
+	"greenlight.samedarslan28.net/internal/jsonlog"
+	"greenlight.samedarslan28.net/internal/trace"
+)
+
+// newTracer returns the tracer for the exporter chosen by -trace-exporter,
+// or nil when tracing is off.
+func newTracer(cfg config, logger *jsonlog.Logger) (*trace.Tracer, error) {
+	var exporter trace.Exporter
+	switch cfg.tracing.exporter {
+	case "", "none":
+		return nil, nil
+	case "stdout":
+		exporter = trace.NewJSONExporter(os.Stdout)
+	case "otlp":
+		exporter = &trace.OTLPExporter{Endpoint: cfg.tracing.otlpEndpoint}
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q (want none, stdout or otlp)", cfg.tracing.exporter)
+	}
+
+	return trace.NewTracer(exporter, trace.Options{
+		Service:     "greenlight",
+		SampleRatio: cfg.tracing.sampleRatio,
+		OnError: func(err error) {
+			logger.PrintError(err, map[string]string{"task": "export spans"})
+		},
+	}), nil
+}
+
+// traceRequest starts the server span of a request, continuing the trace of
+// a valid traceparent header. The span is named after the route pattern, so
+// that requests for different movies are grouped together.
+func (app *application) traceRequest(next http.Handler) http.Handler {
+	if app.tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeFromContext(r.Context())
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := app.tracer.Start(trace.Extract(r), r.Method+" "+route, trace.KindServer)
+		defer span.End()
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("request_id", requestIDFromContext(ctx))
+
+		m := httpsnoop.CaptureMetrics(next, w, r.WithContext(ctx))
+		span.SetAttribute("http.response.status_code", m.Code)
+		if m.Code >= http.StatusInternalServerError {
+			span.RecordError(errors.New(http.StatusText(m.Code)))
+		}
+	})
+}
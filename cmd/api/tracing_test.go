@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"greenlight.samedarslan28.net/internal/jsonlog"
+	"greenlight.samedarslan28.net/internal/trace"
+)
+
+type spanRecorder struct{ spans []trace.SpanData }
+
+func (r *spanRecorder) ExportSpans(_ context.Context, spans []trace.SpanData) error {
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *spanRecorder) Shutdown(context.Context) error { return nil }
+
+func TestTraceRequest(t *testing.T) {
+	recorder := &spanRecorder{}
+	app := &application{
+		logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelInfo),
+		tracer: trace.NewTracer(recorder, trace.Options{SampleRatio: 0}),
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/healthcheck", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	app.routes().ServeHTTP(httptest.NewRecorder(), r)
+	app.tracer.Shutdown(context.Background())
+
+	spans := make(map[string]trace.SpanData)
+	for _, s := range recorder.spans {
+		spans[s.Name] = s
+	}
+	server, ok := spans["GET /v1/healthcheck"]
+	if !ok {
+		t.Fatalf("no server span among %d spans", len(recorder.spans))
+	}
+	if server.SpanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.ParentSpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("server span does not continue the incoming trace: %+v", server)
+	}
+	if got := server.Attributes["http.response.status_code"]; got != http.StatusOK {
+		t.Errorf("status attribute = %v; want 200", got)
+	}
+	for _, name := range []string{"middleware recoverPanic", "middleware authenticate", "middleware requireAcceptable"} {
+		if got := spans[name].ParentSpanID; got != server.SpanContext.SpanID {
+			t.Errorf("%s span parent = %s; want the server span", name, got)
+		}
+	}
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"errors"
 	"net/http"
-	"time"
 
 	"greenlight.samedarslan28.net/internal/data"
 	"greenlight.samedarslan28.net/internal/validator"
@@ -59,49 +58,38 @@ func (app *application) registerUserHandler(writer http.ResponseWriter, request
 	v := validator.New()
 
 	if data.ValidateUser(v, user); !v.Valid() {
-		app.failedValidationResponse(writer, request, v.Errors)
+		app.failedValidationResponse(writer, request, v)
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	// The welcome email is queued in the same transaction, so it survives a
+	// crash of this process and is never sent for a rolled-back registration.
+	err = app.models.WithTx(request.Context(), func(m data.Models) error {
+		err := m.Users.Insert(request.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		err = m.Permissions.AddForUser(request.Context(), user.ID, "movies:read")
+		if err != nil {
+			return err
+		}
+
+		_, err = m.Jobs.Enqueue(request.Context(), jobWelcomeEmail, welcomeEmailJob{UserID: user.ID, Email: user.Email})
+		return err
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
-			v.AddError("email", "a user with this email address already exists")
-			app.failedValidationResponse(writer, request, v.Errors)
+			v.AddErrorCode("email", validator.CodeAlreadyExists, "a user with this email address already exists")
+			app.failedValidationResponse(writer, request, v)
 		default:
 			app.serverErrorResponse(writer, request, err)
 		}
 		return
 	}
 
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
-	if err != nil {
-		app.serverErrorResponse(writer, request, err)
-		return
-	}
-
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
-	if err != nil {
-		app.serverErrorResponse(writer, request, err)
-		return
-	}
-
-	app.background(func() {
-
-		d := map[string]interface{}{
-			"activationToken": token.Plaintext,
-			"userID":          user.ID,
-		}
-
-		err = app.mailer.Send(user.Email, "user_welcome.gohtml", d)
-		if err != nil {
-			app.logger.PrintError(err, nil)
-			return
-		}
-	})
-
-	err = app.writeJSON(writer, http.StatusCreated, envelope{"user": user}, nil)
+	err = app.writeResponse(writer, request, http.StatusCreated, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 	}
@@ -135,45 +123,44 @@ func (app *application) activateUserHandler(writer http.ResponseWriter, request
 	err := app.readJSON(writer, request, &input)
 	if err != nil {
 		app.badRequestResponseHelper(writer, request, err)
+		return
 	}
 
 	v := validator.New()
 	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
-		app.failedValidationResponse(writer, request, v.Errors)
+		app.failedValidationResponse(writer, request, v)
 		return
 	}
-	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("token", "invalid or expired activation token")
-			app.failedValidationResponse(writer, request, v.Errors)
-		default:
-			app.serverErrorResponse(writer, request, err)
+	var user *data.User
+	err = app.models.WithTx(request.Context(), func(m data.Models) error {
+		var err error
+		user, err = m.Users.GetForToken(request.Context(), data.ScopeActivation, input.TokenPlaintext)
+		if err != nil {
+			return err
 		}
-		return
-	}
-	user.Activated = true
+		user.Activated = true
 
-	err = app.models.Users.Update(user)
+		err = m.Users.Update(request.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		return m.Tokens.DeleDeleteAllForUser(request.Context(), data.ScopeActivation, user.ID)
+	})
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddErrorCode("token", validator.CodeInvalid, "invalid or expired activation token")
+			app.failedValidationResponse(writer, request, v)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(writer, request)
 		default:
-			app.logger.PrintInfo("Error on getting update", nil)
 			app.serverErrorResponse(writer, request, err)
 		}
 		return
 	}
 
-	err = app.models.Tokens.DeleDeleteAllForUser(data.ScopeActivation, user.ID)
-	if err != nil {
-		app.serverErrorResponse(writer, request, err)
-		return
-	}
-
-	err = app.writeJSON(writer, http.StatusOK, envelope{"user": user}, nil)
+	err = app.writeResponse(writer, request, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 	}
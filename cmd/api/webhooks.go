@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"greenlight.samedarslan28.net/internal/data"
+	"greenlight.samedarslan28.net/internal/validator"
+	"greenlight.samedarslan28.net/internal/webhook"
+)
+
+// CreateWebhookHandler godoc
+//
+//	@Summary		Subscribe to catalogue events
+//	@Description	Creates a webhook subscription. Deliveries are signed with HMAC-SHA256 using the secret, which is generated when omitted and only returned by this call.
+//	@Tags			webhooks
+//	@Accept			json
+//	@Produce		json
+//	@Success		201	{object}	map[string]data.WebhookSubscription
+//	@Failure		400	{object}	map[string]string
+//	@Failure		422	{object}	map[string]string
+//	@Router			/v1/webhooks [post]
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponseHelper(w, r, err)
+		return
+	}
+
+	if input.Secret == "" {
+		input.Secret, err = generateWebhookSecret()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	if input.Events == nil {
+		input.Events = []string{}
+	}
+
+	subscription := &data.WebhookSubscription{
+		URL:    input.URL,
+		Events: input.Events,
+		Secret: input.Secret,
+		UserID: app.contextGetUser(r).ID,
+	}
+
+	v := validator.New()
+	if data.ValidateWebhookSubscription(v, subscription); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.Webhooks.InsertSubscription(r.Context(), subscription)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d", subscription.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"webhook": subscription}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ListWebhooksHandler godoc
+//
+//	@Summary		List webhook subscriptions
+//	@Tags			webhooks
+//	@Produce		json
+//	@Success		200	{object}	map[string][]data.WebhookSubscription
+//	@Router			/v1/webhooks [get]
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := app.models.Webhooks.GetAllSubscriptions(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"webhooks": subscriptions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ShowWebhookHandler godoc
+//
+//	@Summary		Get a webhook subscription
+//	@Tags			webhooks
+//	@Produce		json
+//	@Param			id	path		int	true	"Subscription ID"
+//	@Success		200	{object}	map[string]data.WebhookSubscription
+//	@Failure		404	{object}	map[string]string
+//	@Router			/v1/webhooks/{id} [get]
+func (app *application) showWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	subscription, err := app.models.Webhooks.GetSubscription(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"webhook": subscription}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// DeleteWebhookHandler godoc
+//
+//	@Summary		Delete a webhook subscription
+//	@Description	Removes the subscription and its delivery log.
+//	@Tags			webhooks
+//	@Produce		json
+//	@Param			id	path		int	true	"Subscription ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Router			/v1/webhooks/{id} [delete]
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Webhooks.DeleteSubscription(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "webhook deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ListWebhookDeliveriesHandler godoc
+//
+//	@Summary		Webhook delivery log
+//	@Description	Lists the deliveries of a subscription, newest first.
+//	@Tags			webhooks
+//	@Produce		json
+//	@Param			id			path		int		true	"Subscription ID"
+//	@Param			status		query		string	false	"pending, delivered or dead"
+//	@Param			page		query		int		false	"Page number"
+//	@Param			page_size	query		int		false	"Page size"
+//	@Success		200			{object}	map[string]interface{}
+//	@Failure		404			{object}	map[string]string
+//	@Failure		422			{object}	map[string]string
+//	@Router			/v1/webhooks/{id}/deliveries [get]
+func (app *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	status := app.readString(qs, "status", "")
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-id",
+		SortSafelist: []string{"-id"},
+	}
+	v.CheckCode(status == "" || validator.In(status, data.DeliveryPending, data.DeliveryDelivered, data.DeliveryDead), "status", validator.CodeNotAllowed, "must be pending, delivered or dead")
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	if _, err := app.models.Webhooks.GetSubscription(r.Context(), id); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	deliveries, metadata, err := app.models.Webhooks.GetDeliveries(r.Context(), id, status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"deliveries": deliveries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ReplayWebhookDeliveryHandler godoc
+//
+//	@Summary		Replay a webhook delivery
+//	@Description	Queues a delivery again, including delivered and dead ones, with a fresh retry budget.
+//	@Tags			webhooks
+//	@Produce		json
+//	@Param			id			path		int	true	"Subscription ID"
+//	@Param			delivery_id	path		int	true	"Delivery ID"
+//	@Success		202			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		409			{object}	map[string]string
+//	@Router			/v1/webhooks/{id}/deliveries/{delivery_id}/replay [post]
+func (app *application) replayWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	deliveryID, err := app.readInt64Param(r, "delivery_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Webhooks.ReplayDelivery(r.Context(), id, deliveryID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.errorResponse(w, r, http.StatusConflict, errCodeDeliveryInFlight, "the delivery is being sent and cannot be replayed")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusAccepted, envelope{"message": "delivery queued"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runWebhookWorker delivers queued webhooks until ctx is cancelled. It runs
+// independently of request handling; handlers only ever write to the queue.
+// A worker count of zero disables deliveries from this instance.
+func (app *application) runWebhookWorker(ctx context.Context) {
+	if app.config.webhooks.workers <= 0 {
+		return
+	}
+
+	worker := &webhook.Worker{
+		Store:        app.models.Webhooks,
+		Client:       &http.Client{},
+		Concurrency:  app.config.webhooks.workers,
+		PollInterval: app.config.webhooks.pollInterval,
+		Timeout:      app.config.webhooks.timeout,
+		MaxAttempts:  app.config.webhooks.maxAttempts,
+		OnError: func(err error, properties map[string]string) {
+			if properties == nil {
+				properties = map[string]string{}
+			}
+			properties["task"] = "deliver webhooks"
+			app.logger.PrintError(err, properties)
+		},
+	}
+	worker.Run(ctx)
+}
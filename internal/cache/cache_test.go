@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := New[string, int](2, time.Minute)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Set("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("Get(a) = %d, %v; want 1, true", v, ok)
+	}
+	if got := c.Stats().Evictions; got != 1 {
+		t.Errorf("Evictions = %d, want 1", got)
+	}
+}
+
+func TestCacheExpiresEntries(t *testing.T) {
+	now := time.Now()
+	c := New[string, int](10, time.Second)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", 1)
+	now = now.Add(2 * time.Second)
+
+	if _, ok := c.Get("a"); ok {
+		t.Error("expected a to have expired")
+	}
+	if got := c.Stats().Size; got != 0 {
+		t.Errorf("Size = %d, want 0", got)
+	}
+}
+
+func TestCacheCoalescesLoads(t *testing.T) {
+	c := New[string, int](10, time.Minute)
+
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func(context.Context) (int, error) {
+		loads.Add(1)
+		<-release
+		return 42, nil
+	}
+	store := func() bool { return true }
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.GetOrLoad(context.Background(), "k", load, store)
+			if err != nil || v != 42 {
+				t.Errorf("GetOrLoad() = %d, %v; want 42, nil", v, err)
+			}
+		}()
+	}
+
+	for c.Stats().Misses < 10 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if got := loads.Load(); got != 1 {
+		t.Errorf("loader ran %d times, want 1", got)
+	}
+	if v, ok := c.Get("k"); !ok || v != 42 {
+		t.Errorf("Get(k) = %d, %v; want 42, true", v, ok)
+	}
+}
+
+func TestCacheSkipsStoreWhenInvalidated(t *testing.T) {
+	c := New[string, int](10, time.Minute)
+
+	_, err := c.GetOrLoad(context.Background(), "k",
+		func(context.Context) (int, error) { return 1, nil },
+		func() bool { return false },
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("k"); ok {
+		t.Error("expected the loaded value not to be stored")
+	}
+}
@@ -0,0 +1,304 @@
+// Package compress compresses HTTP responses with the best content coding a
+// client accepts in its Accept-Encoding header.
+//
+// Responses are held back until they reach a minimum size, so that small
+// responses, which gain little from compression, are sent as they are. A
+// flush, as a streaming handler does after every chunk, ends the wait early.
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encodings are the content codings the middleware supports, in the order
+// it prefers them when the client accepts several equally.
+var Encodings = []string{"zstd", "br", "gzip"}
+
+// Options configure the middleware.
+type Options struct {
+	// Encodings are the content codings to offer, most preferred first.
+	// Empty means all of Encodings.
+	Encodings []string
+	// MinSize is the size in bytes below which responses are not compressed.
+	MinSize int
+}
+
+// encoder is the interface shared by the gzip, brotli and zstd writers.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// pools hold idle encoders by content coding, as they are costly to allocate.
+var pools = map[string]*sync.Pool{
+	"gzip": {New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	}},
+	"br": {New: func() interface{} {
+		return brotli.NewWriterLevel(nil, 4)
+	}},
+	"zstd": {New: func() interface{} {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return w
+	}},
+}
+
+// Middleware returns middleware that compresses responses.
+func Middleware(opts Options) func(http.Handler) http.Handler {
+	offered := opts.Encodings
+	if len(offered) == 0 {
+		offered = Encodings
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addVary(w.Header(), "Accept-Encoding")
+
+			encoding := Negotiate(r.Header.Get("Accept-Encoding"), offered)
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &responseWriter{ResponseWriter: w, encoding: encoding, minSize: opts.MinSize}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// Negotiate returns the content coding from offered that best matches
+// accept, or "" when the response should be sent uncompressed. Ties in
+// quality go to the coding listed first in offered.
+func Negotiate(accept string, offered []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return ""
+	}
+
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					q = v
+				}
+			}
+		}
+		qualities[coding] = q
+	}
+
+	var candidates []string
+	quality := func(coding string) float64 {
+		if q, ok := qualities[coding]; ok {
+			return q
+		}
+		return qualities["*"]
+	}
+	for _, coding := range offered {
+		if _, ok := pools[coding]; ok && quality(coding) > 0 {
+			candidates = append(candidates, coding)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return quality(candidates[i]) > quality(candidates[j])
+	})
+	return candidates[0]
+}
+
+// responseWriter buffers the start of a response until it knows whether to
+// compress it.
+type responseWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     encoder
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	switch {
+	case w.decided:
+		w.ResponseWriter.WriteHeader(status)
+	case status >= 100 && status < 200 && status != http.StatusSwitchingProtocols:
+		// Informational responses such as 103 Early Hints go straight out.
+		w.ResponseWriter.WriteHeader(status)
+	case w.status == 0:
+		w.status = status
+		if !bodyAllowed(status) {
+			w.decide(false)
+		}
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.minSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends what has been written so far, compressing it if the response
+// is of a kind that is worth compressing, whatever its size so far.
+func (w *responseWriter) Flush() {
+	w.FlushError()
+}
+
+// FlushError is Flush for http.ResponseController.
+func (w *responseWriter) FlushError() error {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		if err := w.decide(true); err != nil {
+			return err
+		}
+	}
+	if w.enc != nil {
+		if err := w.enc.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, for
+// deadlines and the like.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// decide writes the header and the buffered body. It starts compression when
+// bigEnough is set and the status and content type of the response suit it.
+func (w *responseWriter) decide(bigEnough bool) error {
+	w.decided = true
+	h := w.Header()
+
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 && bodyAllowed(w.status) {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if bigEnough && bodyAllowed(w.status) && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.encoding)
+		w.enc = pools[w.encoding].Get().(encoder)
+		w.enc.Reset(w.ResponseWriter)
+	}
+
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.enc != nil {
+		_, err := w.enc.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// close sends a response that never reached the minimum size as it is, and
+// finishes the compressed stream of one that did.
+func (w *responseWriter) close() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.enc != nil {
+		w.enc.Close()
+		w.enc.Reset(nil)
+		pools[w.encoding].Put(w.enc)
+		w.enc = nil
+	}
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// incompressible lists media types whose content is already compressed, or
+// which are streamed in chunks too small to compress well.
+var incompressible = map[string]bool{
+	"application/gzip":            true,
+	"application/x-gzip":          true,
+	"application/zip":             true,
+	"application/zstd":            true,
+	"application/x-brotli":        true,
+	"application/x-bzip2":         true,
+	"application/x-xz":            true,
+	"application/x-7z-compressed": true,
+	"application/pdf":             true,
+	"application/octet-stream":    true,
+	"text/event-stream":           true,
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if incompressible[mediaType] {
+		return false
+	}
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+	switch typ {
+	case "image":
+		return subtype == "svg+xml"
+	case "audio", "video":
+		return false
+	case "font":
+		return subtype != "woff" && subtype != "woff2"
+	}
+	return true
+}
+
+// addVary adds value to the Vary header unless it is already listed.
+func addVary(h http.Header, value string) {
+	for _, line := range h.Values("Vary") {
+		for _, v := range strings.Split(line, ",") {
+			if v = strings.TrimSpace(v); v == "*" || strings.EqualFold(v, value) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", value)
+}
@@ -0,0 +1,146 @@
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"gzip, br, zstd", "zstd"},
+		{"gzip;q=1, br;q=0.5", "gzip"},
+		{"br;q=0, gzip", "gzip"},
+		{"*", "zstd"},
+		{"*, zstd;q=0", "br"},
+		{"identity", ""},
+		{"deflate", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Negotiate(tt.accept, Encodings); got != tt.want {
+			t.Errorf("Negotiate(%q) = %q; want %q", tt.accept, got, tt.want)
+		}
+	}
+
+	if got := Negotiate("gzip, br", []string{"gzip"}); got != "gzip" {
+		t.Errorf("Negotiate with gzip offered = %q; want gzip", got)
+	}
+}
+
+func serve(h http.Handler, acceptEncoding string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", acceptEncoding)
+	rr := httptest.NewRecorder()
+	Middleware(Options{MinSize: 100})(h).ServeHTTP(rr, r)
+	return rr
+}
+
+func TestMiddleware(t *testing.T) {
+	body := strings.Repeat(`{"title": "Moana"}`, 50)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "900")
+		io.WriteString(w, body)
+	})
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		"zstd": func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	}
+	for encoding, decode := range decoders {
+		rr := serve(h, encoding)
+		if got := rr.Header().Get("Content-Encoding"); got != encoding {
+			t.Fatalf("Content-Encoding = %q; want %q", got, encoding)
+		}
+		if got := rr.Header().Get("Content-Length"); got != "" {
+			t.Errorf("%s: Content-Length = %q; want it removed", encoding, got)
+		}
+		if got := rr.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("%s: Vary = %q", encoding, got)
+		}
+		dr, err := decode(rr.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(dr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != body {
+			t.Errorf("%s: decoded body differs from the original", encoding)
+		}
+	}
+}
+
+func TestMiddlewareSkips(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		status      int
+		body        string
+	}{
+		{"small", "application/json", http.StatusOK, `{"status": "available"}`},
+		{"compressed type", "image/png", http.StatusOK, strings.Repeat("x", 500)},
+		{"already encoded", "", http.StatusOK, strings.Repeat("x", 500)},
+		{"not modified", "application/json", http.StatusNotModified, ""},
+	}
+
+	for _, tt := range tests {
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.contentType != "" {
+				w.Header().Set("Content-Type", tt.contentType)
+			} else {
+				w.Header().Set("Content-Encoding", "gzip")
+			}
+			w.WriteHeader(tt.status)
+			io.WriteString(w, tt.body)
+		})
+
+		rr := serve(h, "gzip")
+		if rr.Code != tt.status {
+			t.Errorf("%s: status = %d; want %d", tt.name, rr.Code, tt.status)
+		}
+		if tt.contentType != "" && rr.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s: response was compressed", tt.name)
+		}
+		if rr.Body.String() != tt.body {
+			t.Errorf("%s: body = %q; want %q", tt.name, rr.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestMiddlewareStreaming(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		rc := http.NewResponseController(w)
+		io.WriteString(w, "event: ping\n\n")
+		if err := rc.Flush(); err != nil {
+			t.Errorf("Flush: %v", err)
+		}
+		if !w.(*responseWriter).ResponseWriter.(*httptest.ResponseRecorder).Flushed {
+			t.Error("the flush didn't reach the underlying writer")
+		}
+	})
+
+	rr := serve(h, "gzip")
+	if rr.Header().Get("Content-Encoding") != "" {
+		t.Error("event stream was compressed")
+	}
+	if rr.Body.String() != "event: ping\n\n" {
+		t.Errorf("body = %q", rr.Body.String())
+	}
+}
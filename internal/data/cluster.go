@@ -0,0 +1,268 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type contextKey string
+
+const (
+	sessionContextKey = contextKey("session")
+	primaryContextKey = contextKey("primary")
+)
+
+// ContextWithSession tags ctx with a key identifying who is making the
+// request. Writes made under a session key pin that session's reads to the
+// primary for the cluster's sticky window, so callers read their own writes
+// even when the replicas lag behind.
+func ContextWithSession(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, sessionContextKey, key)
+}
+
+// UsePrimary forces every read made with the returned context to go to the
+// primary. It is meant for read-modify-write sequences where a stale replica
+// read would only lead to an edit conflict.
+func UsePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryContextKey, true)
+}
+
+type replica struct {
+	name      string
+	db        *sql.DB
+	healthy   atomic.Bool
+	mu        sync.Mutex
+	lastCheck time.Time
+	lastError string
+}
+
+// Cluster is a Postgres primary together with zero or more read replicas.
+// Writes always go to the primary; reads are spread over the replicas that
+// passed their last health check and fall back to the primary when there are
+// none.
+type Cluster struct {
+	Primary *sql.DB
+
+	replicas    []*replica
+	next        atomic.Uint64
+	stickyFor   time.Duration
+	mu          sync.Mutex
+	recentWrite map[string]time.Time
+	lastWrite   atomic.Int64 // unix nanoseconds of the latest write by anyone
+}
+
+// NewCluster wraps primary and replicas. Replicas start out unhealthy, so
+// reads go to the primary until MonitorReplicas has seen each replica answer
+// and keeps their status up to date from then on. stickyFor is how long a
+// session keeps reading from the primary after it writes.
+func NewCluster(primary *sql.DB, replicas []*sql.DB, stickyFor time.Duration) *Cluster {
+	c := &Cluster{
+		Primary:     primary,
+		stickyFor:   stickyFor,
+		recentWrite: make(map[string]time.Time),
+	}
+	for i, db := range replicas {
+		r := &replica{name: fmt.Sprintf("replica-%d", i), db: db}
+		c.replicas = append(c.replicas, r)
+	}
+	return c
+}
+
+// Reader returns a DBTX that routes each query to a healthy replica.
+func (c *Cluster) Reader() DBTX {
+	return clusterReader{c}
+}
+
+// Writer returns a DBTX that sends each query to the primary and marks the
+// calling session as having written.
+func (c *Cluster) Writer() DBTX {
+	return clusterWriter{c}
+}
+
+// Close closes the primary and every replica.
+func (c *Cluster) Close() error {
+	err := c.Primary.Close()
+	for _, r := range c.replicas {
+		if rerr := r.db.Close(); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// MonitorReplicas pings every replica each interval until ctx is done,
+// marking replicas that fail as unhealthy until they answer again. It also
+// forgets sessions whose sticky window has passed.
+func (c *Cluster) MonitorReplicas(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.checkReplicas(ctx, interval)
+		c.pruneSessions()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Cluster) checkReplicas(ctx context.Context, timeout time.Duration) {
+	for _, r := range c.replicas {
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := r.db.PingContext(pingCtx)
+		cancel()
+
+		r.mu.Lock()
+		r.lastCheck = time.Now()
+		r.lastError = ""
+		if err != nil {
+			r.lastError = err.Error()
+		}
+		r.mu.Unlock()
+		r.healthy.Store(err == nil)
+	}
+}
+
+func (c *Cluster) pruneSessions() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, at := range c.recentWrite {
+		if time.Since(at) > c.stickyFor {
+			delete(c.recentWrite, key)
+		}
+	}
+}
+
+// ReplicaStatus describes the last health check of a single replica.
+type ReplicaStatus struct {
+	Name      string    `json:"name"`
+	Healthy   bool      `json:"healthy"`
+	LastCheck time.Time `json:"last_check"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// ReplicaStatus reports the health of every replica, in configuration order.
+func (c *Cluster) ReplicaStatus() []ReplicaStatus {
+	statuses := make([]ReplicaStatus, 0, len(c.replicas))
+	for _, r := range c.replicas {
+		r.mu.Lock()
+		statuses = append(statuses, ReplicaStatus{
+			Name:      r.name,
+			Healthy:   r.healthy.Load(),
+			LastCheck: r.lastCheck,
+			LastError: r.lastError,
+		})
+		r.mu.Unlock()
+	}
+	return statuses
+}
+
+// ReplicaStats returns the connection pool statistics of every replica,
+// keyed by replica name.
+func (c *Cluster) ReplicaStats() map[string]sql.DBStats {
+	stats := make(map[string]sql.DBStats, len(c.replicas))
+	for _, r := range c.replicas {
+		stats[r.name] = r.db.Stats()
+	}
+	return stats
+}
+
+func (c *Cluster) markWrite(ctx context.Context) {
+	if len(c.replicas) == 0 {
+		return
+	}
+	c.lastWrite.Store(time.Now().UnixNano())
+
+	key, ok := ctx.Value(sessionContextKey).(string)
+	if !ok || key == "" {
+		return
+	}
+	c.mu.Lock()
+	c.recentWrite[key] = time.Now()
+	c.mu.Unlock()
+}
+
+func primaryForced(ctx context.Context) bool {
+	forced, _ := ctx.Value(primaryContextKey).(bool)
+	return forced
+}
+
+func (c *Cluster) sticky(ctx context.Context) bool {
+	if primaryForced(ctx) {
+		return true
+	}
+	key, ok := ctx.Value(sessionContextKey).(string)
+	if !ok || key == "" {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	at, ok := c.recentWrite[key]
+	return ok && time.Since(at) <= c.stickyFor
+}
+
+// recentlyWritten reports whether anyone wrote within the sticky window, so
+// that a replica may not have caught up yet. It is always false without
+// replicas.
+func (c *Cluster) recentlyWritten() bool {
+	at := c.lastWrite.Load()
+	return at != 0 && time.Since(time.Unix(0, at)) <= c.stickyFor
+}
+
+// reader picks the database a read made with ctx should go to.
+func (c *Cluster) reader(ctx context.Context) DBTX {
+	if len(c.replicas) == 0 || c.sticky(ctx) {
+		return c.Primary
+	}
+
+	start := c.next.Add(1)
+	for i := range c.replicas {
+		r := c.replicas[(start+uint64(i))%uint64(len(c.replicas))]
+		if r.healthy.Load() {
+			return r.db
+		}
+	}
+	return c.Primary
+}
+
+type clusterReader struct {
+	c *Cluster
+}
+
+func (r clusterReader) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return r.c.reader(ctx).ExecContext(ctx, query, args...)
+}
+
+func (r clusterReader) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return r.c.reader(ctx).QueryContext(ctx, query, args...)
+}
+
+func (r clusterReader) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return r.c.reader(ctx).QueryRowContext(ctx, query, args...)
+}
+
+type clusterWriter struct {
+	c *Cluster
+}
+
+func (w clusterWriter) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	w.c.markWrite(ctx)
+	return w.c.Primary.ExecContext(ctx, query, args...)
+}
+
+func (w clusterWriter) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	w.c.markWrite(ctx)
+	return w.c.Primary.QueryContext(ctx, query, args...)
+}
+
+func (w clusterWriter) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	w.c.markWrite(ctx)
+	return w.c.Primary.QueryRowContext(ctx, query, args...)
+}
@@ -0,0 +1,67 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+func openUnconnected(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("postgres", "postgres://localhost/unused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestClusterReader(t *testing.T) {
+	primary, replica := openUnconnected(t), openUnconnected(t)
+	c := NewCluster(primary, []*sql.DB{replica}, time.Minute)
+
+	if got := c.reader(context.Background()); got != primary {
+		t.Error("expected reads to avoid a replica that was never checked")
+	}
+	c.replicas[0].healthy.Store(true) // as after a successful check
+
+	alice := ContextWithSession(context.Background(), "user:1")
+	bob := ContextWithSession(context.Background(), "user:2")
+
+	if got := c.reader(alice); got != replica {
+		t.Error("expected reads to go to the healthy replica")
+	}
+	if got := c.reader(UsePrimary(alice)); got != primary {
+		t.Error("expected UsePrimary to force the primary")
+	}
+
+	c.markWrite(alice)
+	if got := c.reader(alice); got != primary {
+		t.Error("expected a session that just wrote to read from the primary")
+	}
+	if got := c.reader(bob); got != replica {
+		t.Error("expected other sessions to keep reading from the replica")
+	}
+
+	c.replicas[0].healthy.Store(false)
+	if got := c.reader(bob); got != primary {
+		t.Error("expected a fall back to the primary when no replica is healthy")
+	}
+}
+
+func TestClusterWithoutReplicas(t *testing.T) {
+	primary := openUnconnected(t)
+	c := NewCluster(primary, nil, time.Minute)
+
+	ctx := ContextWithSession(context.Background(), "user:1")
+	c.markWrite(ctx)
+	if len(c.recentWrite) != 0 {
+		t.Error("expected no session tracking without replicas")
+	}
+	if got := c.reader(ctx); got != primary {
+		t.Error("expected reads to go to the primary")
+	}
+}
@@ -43,13 +43,13 @@ type Metadata struct {
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
-	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
+	v.CheckCode(f.Page > 0, "page", validator.CodeTooSmall, "must be greater than zero")
+	v.CheckCode(f.Page <= 10_000_000, "page", validator.CodeTooLarge, "must be a maximum of 10 million")
 
-	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	v.CheckCode(f.PageSize > 0, "page_size", validator.CodeTooSmall, "must be greater than zero")
+	v.CheckCode(f.PageSize <= 100, "page_size", validator.CodeTooLarge, "must be a maximum of 100")
 
-	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.CheckCode(validator.In(f.Sort, f.SortSafelist...), "sort", validator.CodeNotAllowed, "invalid sort value")
 }
 
 func (f Filters) sortColumn() string {
@@ -0,0 +1,281 @@
+package data
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"greenlight.samedarslan28.net/internal/trace"
+)
+
+const (
+	queryNameContextKey = contextKey("query_name")
+	requestIDContextKey = contextKey("request_id")
+)
+
+// WithQueryName labels the queries made with ctx for QueryStats. Every model
+// method names its queries so that statistics and slow-query logs can be
+// traced back to the code that issued them.
+func WithQueryName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, queryNameContextKey, name)
+}
+
+// WithRequestID records the ID of the request that ctx belongs to. Jobs
+// queued with ctx keep the ID, so that their log lines can be matched with
+// the request that started them.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, id)
+}
+
+// RequestID returns the ID recorded with WithRequestID, or an empty string
+// for work that did not start from a request.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+func queryName(ctx context.Context) string {
+	if name, ok := ctx.Value(queryNameContextKey).(string); ok {
+		return name
+	}
+	return "unnamed"
+}
+
+// QueryEvent describes a finished query. It deliberately carries neither the
+// SQL text nor its arguments, which may include password hashes and tokens.
+type QueryEvent struct {
+	Name     string
+	Duration time.Duration
+	Rows     int64
+	Err      error
+}
+
+// QueryStat holds the running totals for one query name.
+type QueryStat struct {
+	Count       int64 `json:"count"`
+	Errors      int64 `json:"errors"`
+	Rows        int64 `json:"rows"`
+	TotalMicros int64 `json:"total_μs"`
+	MaxMicros   int64 `json:"max_μs"`
+	SlowQueries int64 `json:"slow"`
+}
+
+// QueryStats records the duration, row count and outcome of every query run
+// through a connector returned by Connector, grouped by query name. Queries
+// slower than the threshold are also passed to the slow-query hook.
+type QueryStats struct {
+	threshold time.Duration
+	onSlow    func(ctx context.Context, event QueryEvent)
+
+	mu     sync.Mutex
+	byName map[string]*QueryStat
+}
+
+// NewQueryStats returns a QueryStats that calls onSlow for every query taking
+// at least threshold. A zero threshold or a nil onSlow disables the hook.
+func NewQueryStats(threshold time.Duration, onSlow func(ctx context.Context, event QueryEvent)) *QueryStats {
+	return &QueryStats{
+		threshold: threshold,
+		onSlow:    onSlow,
+		byName:    make(map[string]*QueryStat),
+	}
+}
+
+// Snapshot returns a copy of the statistics, keyed by query name.
+func (s *QueryStats) Snapshot() map[string]QueryStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := make(map[string]QueryStat, len(s.byName))
+	for name, stat := range s.byName {
+		snapshot[name] = *stat
+	}
+	return snapshot
+}
+
+func (s *QueryStats) record(ctx context.Context, event QueryEvent) {
+	slow := s.threshold > 0 && event.Duration >= s.threshold
+	micros := event.Duration.Microseconds()
+
+	s.mu.Lock()
+	stat, ok := s.byName[event.Name]
+	if !ok {
+		stat = &QueryStat{}
+		s.byName[event.Name] = stat
+	}
+	stat.Count++
+	stat.Rows += event.Rows
+	stat.TotalMicros += micros
+	if micros > stat.MaxMicros {
+		stat.MaxMicros = micros
+	}
+	if event.Err != nil {
+		stat.Errors++
+	}
+	if slow {
+		stat.SlowQueries++
+	}
+	s.mu.Unlock()
+
+	if slow && s.onSlow != nil {
+		s.onSlow(ctx, event)
+	}
+}
+
+// Connector wraps c so that every query and exec on its connections is
+// recorded in s, and in a span if the context is being traced. Open the pool
+// with sql.OpenDB to use it.
+func (s *QueryStats) Connector(c driver.Connector) driver.Connector {
+	return instrumentedConnector{Connector: c, stats: s}
+}
+
+type instrumentedConnector struct {
+	driver.Connector
+	stats *QueryStats
+}
+
+func (c instrumentedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConn{Conn: conn, stats: c.stats}, nil
+}
+
+type instrumentedConn struct {
+	driver.Conn
+	stats *QueryStats
+}
+
+func (c *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	span := startQuerySpan(ctx)
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err != nil {
+		if !errors.Is(err, driver.ErrSkip) {
+			c.stats.record(ctx, QueryEvent{Name: queryName(ctx), Duration: time.Since(start), Err: err})
+			span.RecordError(err)
+		}
+		span.End()
+		return nil, err
+	}
+	return &instrumentedRows{Rows: rows, ctx: ctx, span: span, start: start, stats: c.stats}, nil
+}
+
+func (c *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	span := startQuerySpan(ctx)
+	defer span.End()
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	if errors.Is(err, driver.ErrSkip) {
+		return nil, err
+	}
+
+	event := QueryEvent{Name: queryName(ctx), Duration: time.Since(start), Err: err}
+	if err == nil {
+		event.Rows, _ = result.RowsAffected()
+	}
+	c.stats.record(ctx, event)
+	endQuerySpan(span, event)
+	return result, err
+}
+
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return nil, errors.New("data: driver does not support BeginTx")
+}
+
+func (c *instrumentedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *instrumentedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *instrumentedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// instrumentedRows counts the rows read and records the query when the rows
+// are closed, so the duration includes the time spent streaming results.
+type instrumentedRows struct {
+	driver.Rows
+	ctx   context.Context
+	span  *trace.Span
+	start time.Time
+	stats *QueryStats
+	count int64
+	err   error
+}
+
+func (r *instrumentedRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	switch {
+	case err == nil:
+		r.count++
+	case !errors.Is(err, io.EOF):
+		r.err = err
+	}
+	return err
+}
+
+func (r *instrumentedRows) Close() error {
+	err := r.Rows.Close()
+	event := QueryEvent{
+		Name:     queryName(r.ctx),
+		Duration: time.Since(r.start),
+		Rows:     r.count,
+		Err:      r.err,
+	}
+	r.stats.record(r.ctx, event)
+	endQuerySpan(r.span, event)
+	r.span.End()
+	return err
+}
+
+// startQuerySpan starts the span of a query, named after the query name
+// rather than the SQL text for the same reason QueryEvent leaves it out.
+func startQuerySpan(ctx context.Context) *trace.Span {
+	name := queryName(ctx)
+	_, span := trace.Start(ctx, "db "+name, trace.KindClient)
+	span.SetAttribute("db.system", "postgresql")
+	span.SetAttribute("db.operation.name", name)
+	return span
+}
+
+func endQuerySpan(span *trace.Span, event QueryEvent) {
+	span.SetAttribute("db.rows", event.Rows)
+	span.RecordError(event.Err)
+}
@@ -0,0 +1,133 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"greenlight.samedarslan28.net/internal/trace"
+)
+
+// fakeConnector hands out connections whose queries return three rows, or
+// fail when the query text is "fail".
+type fakeConnector struct{}
+
+func (fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn{}, nil }
+func (fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (fakeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if query == "fail" {
+		return nil, errors.New("query failed")
+	}
+	return &fakeRows{remaining: 3}, nil
+}
+
+func (fakeConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(2), nil
+}
+
+type fakeRows struct{ remaining int }
+
+func (r *fakeRows) Columns() []string { return []string{"n"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.remaining == 0 {
+		return io.EOF
+	}
+	r.remaining--
+	dest[0] = int64(r.remaining)
+	return nil
+}
+
+func TestQueryStats(t *testing.T) {
+	var slow []QueryEvent
+	stats := NewQueryStats(time.Nanosecond, func(_ context.Context, event QueryEvent) {
+		slow = append(slow, event)
+	})
+
+	db := sql.OpenDB(stats.Connector(fakeConnector{}))
+	defer db.Close()
+
+	ctx := WithQueryName(context.Background(), "things.list")
+	rows, err := db.QueryContext(ctx, "SELECT n FROM things")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.ExecContext(WithQueryName(context.Background(), "things.delete"), "DELETE FROM things"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.QueryContext(context.Background(), "fail"); err == nil {
+		t.Fatal("expected the query to fail")
+	}
+
+	snapshot := stats.Snapshot()
+	if got := snapshot["things.list"]; got.Count != 1 || got.Rows != 3 || got.Errors != 0 {
+		t.Errorf("things.list = %+v, want 1 query returning 3 rows", got)
+	}
+	if got := snapshot["things.delete"]; got.Count != 1 || got.Rows != 2 {
+		t.Errorf("things.delete = %+v, want 1 exec affecting 2 rows", got)
+	}
+	if got := snapshot["unnamed"]; got.Count != 1 || got.Errors != 1 {
+		t.Errorf("unnamed = %+v, want 1 failed query", got)
+	}
+	if len(slow) != 3 {
+		t.Errorf("slow-query hook called %d times, want 3", len(slow))
+	}
+}
+
+type spanRecorder struct{ spans []trace.SpanData }
+
+func (r *spanRecorder) ExportSpans(_ context.Context, spans []trace.SpanData) error {
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *spanRecorder) Shutdown(context.Context) error { return nil }
+
+func TestQuerySpans(t *testing.T) {
+	recorder := &spanRecorder{}
+	tracer := trace.NewTracer(recorder, trace.Options{SampleRatio: 1})
+
+	db := sql.OpenDB(NewQueryStats(0, nil).Connector(fakeConnector{}))
+	defer db.Close()
+
+	ctx, root := tracer.Start(context.Background(), "request", trace.KindServer)
+	rows, err := db.QueryContext(WithQueryName(ctx, "things.list"), "SELECT n FROM things")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	db.QueryContext(WithQueryName(ctx, "things.fail"), "fail")
+	root.End()
+	tracer.Shutdown(context.Background())
+
+	spans := make(map[string]trace.SpanData)
+	for _, s := range recorder.spans {
+		spans[s.Name] = s
+	}
+	list := spans["db things.list"]
+	if list.ParentSpanID != root.SpanContext().SpanID || list.Kind != trace.KindClient || list.Attributes["db.rows"] != int64(3) {
+		t.Errorf("things.list span = %+v", list)
+	}
+	if got := spans["db things.fail"].Error; got != "query failed" {
+		t.Errorf("things.fail span error = %q, want %q", got, "query failed")
+	}
+}
@@ -0,0 +1,279 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Job states.
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobDead    = "dead"
+)
+
+// Job is a unit of background work stored in the jobs table.
+type Job struct {
+	ID          int64           `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Kind        string          `json:"kind" example:"email.welcome"`
+	Payload     json.RawMessage `json:"payload" swaggertype:"object"`
+	Status      string          `json:"status" example:"queued"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   *string         `json:"last_error,omitempty"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+	RequestID   string          `json:"request_id,omitempty"`
+}
+
+type JobModel struct {
+	DB       DBTX
+	ReadDB   DBTX
+	Timeouts Timeouts
+}
+
+// Enqueue stores a job of the given kind with payload encoded as JSON. Run
+// it inside WithTx to queue the job only if the rest of the transaction
+// commits. The job keeps the request ID recorded in ctx, if any.
+func (m JobModel) Enqueue(ctx context.Context, kind string, payload interface{}) (*Job, error) {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+INSERT INTO jobs (kind, payload, request_id)
+VALUES ($1, $2, $3)
+RETURNING id, created_at, status, attempts, max_attempts, run_at`
+
+	ctx = WithQueryName(ctx, "jobs.enqueue")
+	ctx, cancel := m.Timeouts.write(ctx)
+	defer cancel()
+
+	job := &Job{Kind: kind, Payload: js, RequestID: RequestID(ctx)}
+	err = m.DB.QueryRowContext(ctx, query, kind, js, job.RequestID).Scan(
+		&job.ID, &job.CreatedAt, &job.Status, &job.Attempts, &job.MaxAttempts, &job.RunAt)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return job, nil
+}
+
+// Claim marks up to limit due jobs as running for lease and counts the
+// attempt. Jobs whose lease ran out without being finished are due again,
+// unless that was their last attempt: those are dead-lettered instead, so a
+// job that keeps crashing or hanging its worker does not run forever.
+func (m JobModel) Claim(ctx context.Context, limit int, lease time.Duration) ([]*Job, error) {
+	if err := m.expire(ctx); err != nil {
+		return nil, err
+	}
+
+	query := `
+UPDATE jobs
+SET status = 'running', attempts = attempts + 1, locked_until = now() + make_interval(secs => $2)
+WHERE id IN (
+    SELECT id FROM jobs
+    WHERE (status = 'queued' AND run_at <= now())
+       OR (status = 'running' AND locked_until < now() AND attempts < max_attempts)
+    ORDER BY run_at
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, created_at, kind, payload, status, attempts, max_attempts, run_at, last_error, request_id`
+
+	ctx = WithQueryName(ctx, "jobs.claim")
+	ctx, cancel := m.Timeouts.write(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	var jobs []*Job
+	for rows.Next() {
+		var job Job
+		err := rows.Scan(&job.ID, &job.CreatedAt, &job.Kind, (*[]byte)(&job.Payload), &job.Status,
+			&job.Attempts, &job.MaxAttempts, &job.RunAt, &job.LastError, &job.RequestID)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		jobs = append(jobs, &job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return jobs, nil
+}
+
+// expire dead-letters running jobs whose lease ran out on their last attempt.
+func (m JobModel) expire(ctx context.Context) error {
+	query := `
+UPDATE jobs
+SET status = 'dead', locked_until = NULL, finished_at = now(),
+    last_error = 'lease expired on the last attempt'
+WHERE status = 'running' AND locked_until < now() AND attempts >= max_attempts`
+
+	ctx = WithQueryName(ctx, "jobs.expire")
+	ctx, cancel := m.Timeouts.write(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query)
+	return contextError(ctx, err)
+}
+
+// Finish records the outcome of a claimed job. A failed job runs again at
+// retryAt, or is dead-lettered when retryAt is zero. The update only applies
+// to the claim that job came from: if its lease expired and another worker
+// claimed it again, Finish returns ErrEditConflict and changes nothing.
+func (m JobModel) Finish(ctx context.Context, job *Job, jobErr error, retryAt time.Time) error {
+	query := `
+UPDATE jobs
+SET status = 'done', locked_until = NULL, finished_at = now()
+WHERE id = $1 AND status = 'running' AND attempts = $2`
+	args := []interface{}{job.ID, job.Attempts}
+
+	switch {
+	case jobErr != nil && !retryAt.IsZero():
+		query = `
+UPDATE jobs
+SET status = 'queued', locked_until = NULL, last_error = $3, run_at = $4
+WHERE id = $1 AND status = 'running' AND attempts = $2`
+		args = append(args, jobErr.Error(), retryAt)
+	case jobErr != nil:
+		query = `
+UPDATE jobs
+SET status = 'dead', locked_until = NULL, last_error = $3, finished_at = now()
+WHERE id = $1 AND status = 'running' AND attempts = $2`
+		args = append(args, jobErr.Error())
+	}
+
+	ctx = WithQueryName(ctx, "jobs.finish")
+	ctx, cancel := m.Timeouts.write(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+func (m JobModel) Get(ctx context.Context, id int64) (*Job, error) {
+	query := `
+SELECT id, created_at, kind, payload, status, attempts, max_attempts, run_at, last_error, finished_at, request_id
+FROM jobs
+WHERE id = $1`
+
+	ctx = WithQueryName(ctx, "jobs.get")
+	ctx, cancel := m.Timeouts.read(ctx)
+	defer cancel()
+
+	var job Job
+	err := m.ReadDB.QueryRowContext(ctx, query, id).Scan(&job.ID, &job.CreatedAt, &job.Kind, (*[]byte)(&job.Payload),
+		&job.Status, &job.Attempts, &job.MaxAttempts, &job.RunAt, &job.LastError, &job.FinishedAt, &job.RequestID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, contextError(ctx, err)
+		}
+	}
+	return &job, nil
+}
+
+// Backlog returns the number of queued jobs that are due to run, which
+// grows when the workers cannot keep up.
+func (m JobModel) Backlog(ctx context.Context) (int, error) {
+	query := `
+SELECT count(*)
+FROM jobs
+WHERE status = 'queued' AND run_at <= now()`
+
+	ctx = WithQueryName(ctx, "jobs.backlog")
+	ctx, cancel := m.Timeouts.read(ctx)
+	defer cancel()
+
+	var n int
+	err := m.ReadDB.QueryRowContext(ctx, query).Scan(&n)
+	if err != nil {
+		return 0, contextError(ctx, err)
+	}
+	return n, nil
+}
+
+// GetAll returns a page of jobs, newest first. Empty status and kind match
+// every job.
+func (m JobModel) GetAll(ctx context.Context, status, kind string, filters Filters) ([]*Job, Metadata, error) {
+	query := `
+SELECT count(*) OVER(), id, created_at, kind, payload, status, attempts, max_attempts, run_at, last_error, finished_at, request_id
+FROM jobs
+WHERE (status = $1 OR $1 = '') AND (kind = $2 OR $2 = '')
+ORDER BY id DESC
+LIMIT $3 OFFSET $4`
+
+	ctx = WithQueryName(ctx, "jobs.get_all")
+	ctx, cancel := m.Timeouts.read(ctx)
+	defer cancel()
+
+	rows, err := m.ReadDB.QueryContext(ctx, query, status, kind, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	jobs := []*Job{}
+	for rows.Next() {
+		var job Job
+		err := rows.Scan(&totalRecords, &job.ID, &job.CreatedAt, &job.Kind, (*[]byte)(&job.Payload),
+			&job.Status, &job.Attempts, &job.MaxAttempts, &job.RunAt, &job.LastError, &job.FinishedAt, &job.RequestID)
+		if err != nil {
+			return nil, Metadata{}, contextError(ctx, err)
+		}
+		jobs = append(jobs, &job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, contextError(ctx, err)
+	}
+	return jobs, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Requeue makes a dead or finished job run again straight away with a fresh
+// attempt budget. Running jobs cannot be requeued.
+func (m JobModel) Requeue(ctx context.Context, id int64) (*Job, error) {
+	query := `
+UPDATE jobs
+SET status = 'queued', attempts = 0, run_at = now(), finished_at = NULL
+WHERE id = $1 AND status <> 'running'
+RETURNING id`
+
+	ctx = WithQueryName(ctx, "jobs.requeue")
+	ctx, cancel := m.Timeouts.write(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, contextError(ctx, err)
+		}
+	}
+	return m.Get(UsePrimary(ctx), id)
+}
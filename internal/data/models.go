@@ -1,27 +1,177 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
 )
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
+	ErrQueryCanceled  = errors.New("query canceled")
+	ErrQueryTimeout   = errors.New("query timed out")
 )
 
+// maxTxAttempts is the number of times WithTx runs a transaction before
+// giving up on repeated serialization failures.
+const maxTxAttempts = 3
+
+// Timeouts bounds how long a single model operation may run. Read applies to
+// queries that only select rows, Write to everything else. The bound is
+// applied on top of the caller's context, so a request that is cancelled
+// earlier still stops its queries straight away.
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+}
+
+func (t Timeouts) read(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, t.Read)
+}
+
+func (t Timeouts) write(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, t.Write)
+}
+
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// contextError translates a failure caused by ctx ending into ErrQueryCanceled
+// or ErrQueryTimeout, keeping the original error in the chain. Any other error
+// is returned unchanged.
+func contextError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", ErrQueryTimeout, err)
+	case ctx.Err() != nil, errors.Is(err, context.Canceled):
+		return fmt.Errorf("%w: %w", ErrQueryCanceled, err)
+	default:
+		return err
+	}
+}
+
+// DBTX is the subset of methods shared by *sql.DB and *sql.Tx, so that the
+// models can run either directly against the pool or inside a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 type Models struct {
 	Movies      MovieModel
 	Users       UserModel
 	Tokens      TokenModel
 	Permissions PermissionModel
+	Webhooks    WebhookModel
+	Jobs        JobModel
+	Tasks       ScheduledTaskModel
+
+	cluster *Cluster
 }
 
-func NewModels(db *sql.DB) Models {
+// NewModels returns the models backed by cluster. movieCache may be nil to
+// disable caching of movie reads.
+func NewModels(cluster *Cluster, timeouts Timeouts, movieCache *MovieCache) Models {
+	writer, reader := cluster.Writer(), cluster.Reader()
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
+		Movies:      MovieModel{DB: writer, ReadDB: reader, Timeouts: timeouts, Cache: movieCache, cluster: cluster},
+		Users:       UserModel{DB: writer, ReadDB: reader, Timeouts: timeouts},
+		Tokens:      TokenModel{DB: writer, Timeouts: timeouts},
+		Permissions: PermissionModel{DB: writer, ReadDB: reader, Timeouts: timeouts},
+		Webhooks:    WebhookModel{DB: writer, ReadDB: reader, Timeouts: timeouts},
+		Jobs:        JobModel{DB: writer, ReadDB: reader, Timeouts: timeouts},
+		Tasks:       ScheduledTaskModel{DB: writer, ReadDB: reader, Timeouts: timeouts},
+		cluster:     cluster,
+	}
+}
+
+// withDB returns a copy of the models with every model bound to db for both
+// reads and writes. It is used for transactions, so movie cache invalidations
+// are held back until the caller commits.
+func (m Models) withDB(db DBTX) Models {
+	m.Movies.DB, m.Movies.ReadDB = db, db
+	m.Movies.pending = &pendingInvalidation{}
+	m.Users.DB, m.Users.ReadDB = db, db
+	m.Tokens.DB = db
+	m.Permissions.DB, m.Permissions.ReadDB = db, db
+	m.Webhooks.DB, m.Webhooks.ReadDB = db, db
+	m.Jobs.DB, m.Jobs.ReadDB = db, db
+	m.Tasks.DB, m.Tasks.ReadDB = db, db
+	return m
+}
+
+// WithTx runs fn inside a single serializable transaction on the primary.
+// The Models passed to fn are bound to that transaction for reads and
+// writes; the transaction is committed when fn returns nil and rolled back
+// otherwise. If Postgres aborts the transaction with a serialization failure
+// or deadlock, fn is run again from the start, so it must not have side
+// effects outside the database.
+func (m Models) WithTx(ctx context.Context, fn func(Models) error) error {
+	var err error
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+		err = m.runTx(ctx, fn)
+		if err == nil || !isSerializationFailure(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return contextError(ctx, ctx.Err())
+		case <-time.After(time.Duration(attempt) * 10 * time.Millisecond):
+		}
+	}
+	return err
+}
+
+func (m Models) runTx(ctx context.Context, fn func(Models) error) error {
+	tx, err := m.cluster.Primary.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return contextError(ctx, err)
+	}
+
+	txModels := m.withDB(tx)
+	err = fn(txModels)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return contextError(ctx, err)
+	}
+
+	m.cluster.markWrite(ctx)
+	if pending := txModels.Movies.pending; pending.dirty && m.Movies.Cache != nil {
+		m.Movies.Cache.Invalidate(pending.ids...)
+	}
+	return nil
+}
+
+// isSerializationFailure reports whether err is a Postgres error that means
+// the transaction can safely be retried.
+func isSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	switch pqErr.Code {
+	case "40001", "40P01": // serialization_failure, deadlock_detected
+		return true
+	default:
+		return false
 	}
 }
@@ -0,0 +1,79 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestIsSerializationFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "serialization failure", err: &pq.Error{Code: "40001"}, want: true},
+		{name: "deadlock", err: &pq.Error{Code: "40P01"}, want: true},
+		{name: "wrapped", err: fmt.Errorf("commit: %w", &pq.Error{Code: "40001"}), want: true},
+		{name: "unique violation", err: &pq.Error{Code: "23505"}, want: false},
+		{name: "plain error", err: errors.New("boom"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSerializationFailure(tt.err); got != tt.want {
+				t.Errorf("isSerializationFailure() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContextError(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	queryCanceled := &pq.Error{Code: "57014"}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want error
+	}{
+		{name: "nil error", ctx: context.Background(), err: nil, want: nil},
+		{name: "client went away", ctx: canceled, err: queryCanceled, want: ErrQueryCanceled},
+		{name: "deadline exceeded", ctx: expired, err: queryCanceled, want: ErrQueryTimeout},
+		{name: "bare context error", ctx: context.Background(), err: context.DeadlineExceeded, want: ErrQueryTimeout},
+		{name: "unrelated error", ctx: context.Background(), err: sql.ErrConnDone, want: sql.ErrConnDone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := contextError(tt.ctx, tt.err)
+			if !errors.Is(got, tt.want) {
+				t.Errorf("contextError() = %v, want %v", got, tt.want)
+			}
+			if tt.err != nil && !errors.Is(got, tt.err) {
+				t.Errorf("contextError() = %v, lost original error %v", got, tt.err)
+			}
+		})
+	}
+}
+
+// DeleteUnactivated must refuse before it reaches the database, which the
+// zero UserModel does not have.
+func TestDeleteUnactivatedRejectsNonPositiveAge(t *testing.T) {
+	for _, age := range []time.Duration{0, -time.Hour} {
+		if _, err := (UserModel{}).DeleteUnactivated(context.Background(), age); err == nil {
+			t.Errorf("age %s was accepted", age)
+		}
+	}
+}
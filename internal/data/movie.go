@@ -37,67 +37,124 @@ type Movie struct {
 }
 
 func ValidateMovie(v *validator.Validator, input *Movie) {
-	v.Check(input.Title != "",
+	v.CheckCode(input.Title != "",
 		"title",
+		validator.CodeRequired,
 		"must be provided")
 
-	v.Check(len(input.Title) <= 500,
+	v.CheckCode(len(input.Title) <= 500,
 		"title",
+		validator.CodeTooLong,
 		"must not be more than 500 bytes long",
 	)
 
-	v.Check(input.Year != 0,
+	v.CheckCode(input.Year != 0,
 		"year",
+		validator.CodeRequired,
 		"must be provided",
 	)
 
-	v.Check(input.Year >= 1888,
+	v.CheckCode(input.Year >= 1888,
 		"year",
+		validator.CodeTooSmall,
 		"must be greater than or equal to 1888",
 	)
 
-	v.Check(input.Year <= int32(time.Now().Year()),
+	v.CheckCode(input.Year <= int32(time.Now().Year()),
 		"year",
+		validator.CodeTooLarge,
 		"must not be in the future",
 	)
 
-	v.Check(input.Runtime != 0,
+	v.CheckCode(input.Runtime != 0,
 		"runtime",
+		validator.CodeRequired,
 		"must be provided",
 	)
 
-	v.Check(input.Runtime > 0,
+	v.CheckCode(input.Runtime > 0,
 		"runtime",
+		validator.CodeTooSmall,
 		"must be a positive integer",
 	)
 
-	v.Check(input.Genres != nil,
+	v.CheckCode(input.Genres != nil,
 		"genres",
+		validator.CodeRequired,
 		"must be provided",
 	)
 
-	v.Check(len(input.Genres) >= 1,
+	v.CheckCode(len(input.Genres) >= 1,
 		"genres",
+		validator.CodeTooShort,
 		"must contain at least 1 genre",
 	)
 
-	v.Check(len(input.Genres) <= 5,
+	v.CheckCode(len(input.Genres) <= 5,
 		"genres",
+		validator.CodeTooLong,
 		"must not contain more than 5 genres",
 	)
 
-	v.Check(validator.Unique(input.Genres),
+	v.CheckCode(validator.Unique(input.Genres),
 		"genres",
+		validator.CodeDuplicate,
 		"must not contain duplicate values",
 	)
 }
 
 type MovieModel struct {
-	DB *sql.DB
+	DB       DBTX
+	ReadDB   DBTX
+	Timeouts Timeouts
+	Cache    *MovieCache // nil disables caching
+
+	// cluster tells which reads must see the primary, and whether a replica
+	// may still lag behind a write; it is nil in tests.
+	cluster *Cluster
+
+	// pending is set on models bound to a transaction. Cache invalidations
+	// are collected there and applied once the transaction commits, and reads
+	// bypass the cache.
+	pending *pendingInvalidation
+}
+
+type pendingInvalidation struct {
+	dirty bool
+	ids   []int64
+}
+
+// invalidate drops cached copies of the given movies and all cached listings.
+func (m MovieModel) invalidate(ids ...int64) {
+	switch {
+	case m.pending != nil:
+		m.pending.dirty = true
+		m.pending.ids = append(m.pending.ids, ids...)
+	case m.Cache != nil:
+		m.Cache.Invalidate(ids...)
+	}
+}
+
+// cached reports whether reads made with ctx may be served from the cache.
+// Reads that asked for the primary, or that come from a session within its
+// read-your-writes window, want the current row, so they skip it. Loads for
+// the cache therefore always run with a context that may read a replica.
+func (m MovieModel) cached(ctx context.Context) bool {
+	if m.Cache == nil || m.pending != nil || primaryForced(ctx) {
+		return false
+	}
+	return m.cluster == nil || !m.cluster.sticky(ctx)
+}
+
+// storable reports whether a freshly loaded result may be cached. Right
+// after a write a replica may still return the old rows, and caching them
+// would serve them to the writer once its sticky window ends.
+func (m MovieModel) storable() bool {
+	return m.cluster == nil || !m.cluster.recentlyWritten()
 }
 
 // Insert inserts a new movie into the database.
-func (m MovieModel) Insert(movie *Movie) error {
+func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
 	query := `
         INSERT INTO movies (title, year, runtime, genres)
         VALUES ($1, $2, $3, $4)
@@ -106,22 +163,38 @@ func (m MovieModel) Insert(movie *Movie) error {
 
 	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx = WithQueryName(ctx, "movies.insert")
+	ctx, cancel := m.Timeouts.write(ctx)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Version,
 	)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+
+	m.invalidate()
+	return nil
 }
 
 // Get retrieves a movie by its ID.
-func (m MovieModel) Get(id int64) (*Movie, error) {
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
+	if m.cached(ctx) {
+		return m.Cache.get(ctx, id, func(ctx context.Context) (*Movie, error) {
+			return m.get(ctx, id)
+		}, m.storable)
+	}
+	return m.get(ctx, id)
+}
+
+func (m MovieModel) get(ctx context.Context, id int64) (*Movie, error) {
 	query := `
         SELECT id, created_at, title, year, runtime, genres, version
         FROM movies
@@ -130,10 +203,11 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 
 	var movie Movie
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx = WithQueryName(ctx, "movies.get")
+	ctx, cancel := m.Timeouts.read(ctx)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := m.ReadDB.QueryRowContext(ctx, query, id).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Title,
@@ -147,7 +221,85 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, contextError(ctx, err)
+		}
+	}
+
+	return &movie, nil
+}
+
+// GetMany retrieves the movies with the given IDs in a single query. The
+// result is keyed by ID; IDs with no matching movie are left out.
+func (m MovieModel) GetMany(ctx context.Context, ids []int64) (map[int64]*Movie, error) {
+	query := `
+        SELECT id, created_at, title, year, runtime, genres, version
+        FROM movies
+        WHERE id = ANY($1)
+    `
+
+	ctx = WithQueryName(ctx, "movies.get_many")
+	ctx, cancel := m.Timeouts.read(ctx)
+	defer cancel()
+
+	rows, err := m.ReadDB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	movies := make(map[int64]*Movie, len(ids))
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		movies[movie.ID] = &movie
+	}
+	if err = rows.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return movies, nil
+}
+
+// GetByTitle retrieves the movie with exactly the given title and year.
+func (m MovieModel) GetByTitle(ctx context.Context, title string, year int32) (*Movie, error) {
+	query := `
+        SELECT id, created_at, title, year, runtime, genres, version
+        FROM movies
+        WHERE title = $1 AND year = $2
+        ORDER BY id
+        LIMIT 1
+    `
+
+	var movie Movie
+
+	ctx = WithQueryName(ctx, "movies.get_by_title")
+	ctx, cancel := m.Timeouts.read(ctx)
+	defer cancel()
+
+	err := m.ReadDB.QueryRowContext(ctx, query, title, year).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, contextError(ctx, err)
 		}
 	}
 
@@ -155,7 +307,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 }
 
 // Update updates an existing movie using optimistic locking.
-func (m MovieModel) Update(movie *Movie) error {
+func (m MovieModel) Update(ctx context.Context, movie *Movie) error {
 	query := `
         UPDATE movies
         SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
@@ -172,7 +324,8 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx = WithQueryName(ctx, "movies.update")
+	ctx, cancel := m.Timeouts.write(ctx)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
@@ -181,15 +334,16 @@ func (m MovieModel) Update(movie *Movie) error {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
-			return err
+			return contextError(ctx, err)
 		}
 	}
 
+	m.invalidate(movie.ID)
 	return nil
 }
 
 // Delete deletes a movie by its ID.
-func (m MovieModel) Delete(id int64) error {
+func (m MovieModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
@@ -199,12 +353,13 @@ func (m MovieModel) Delete(id int64) error {
         WHERE id = $1
     `
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx = WithQueryName(ctx, "movies.delete")
+	ctx, cancel := m.Timeouts.write(ctx)
 	defer cancel()
 
 	result, err := m.DB.ExecContext(ctx, query, id)
 	if err != nil {
-		return err
+		return contextError(ctx, err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
@@ -216,10 +371,21 @@ func (m MovieModel) Delete(id int64) error {
 		return ErrRecordNotFound
 	}
 
+	m.invalidate(id)
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+func (m MovieModel) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+	if m.cached(ctx) {
+		return m.Cache.getAll(ctx, title, genres, filters, func(ctx context.Context) (movieList, error) {
+			movies, metadata, err := m.getAll(ctx, title, genres, filters)
+			return movieList{movies: movies, metadata: metadata}, err
+		}, m.storable)
+	}
+	return m.getAll(ctx, title, genres, filters)
+}
+
+func (m MovieModel) getAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
 	query := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
 	FROM movies
 	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
@@ -228,17 +394,18 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	LIMIT $3 OFFSET  $4`,
 		filters.sortColumn(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx = WithQueryName(ctx, "movies.get_all")
+	ctx, cancel := m.Timeouts.read(ctx)
 	defer cancel()
 
 	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
 
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := m.ReadDB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, contextError(ctx, err)
 	}
 	defer rows.Close()
-	var movies []*Movie
+	movies := []*Movie{}
 	totalRecords := 0
 
 	for rows.Next() {
@@ -253,12 +420,12 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			pq.Array(&movie.Genres),
 			&movie.Version)
 		if err != nil {
-			return nil, Metadata{}, err
+			return nil, Metadata{}, contextError(ctx, err)
 		}
 		movies = append(movies, &movie)
 	}
 	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, contextError(ctx, err)
 	}
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
 
@@ -0,0 +1,131 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"greenlight.samedarslan28.net/internal/cache"
+)
+
+// movieList is a cached page of GetAll results.
+type movieList struct {
+	movies   []*Movie
+	metadata Metadata
+}
+
+// MovieCache keeps recently read movies and movie listings in memory.
+//
+// Single movies are cached by ID and dropped when that movie changes.
+// Listings can be affected by any write, so their keys include a generation
+// number that every write bumps; stale listings then simply stop being
+// looked up and age out of the LRU. A load only stores its result if no write
+// happened while it was running.
+//
+// Writes invalidate this process's cache directly. Writes made through other
+// instances are only seen through the movie events feed; while that feed is
+// not connected, they may be served stale for up to the cache TTL.
+type MovieCache struct {
+	movies     *cache.Cache[int64, *Movie]
+	lists      *cache.Cache[string, movieList]
+	generation atomic.Uint64
+}
+
+// NewMovieCache returns a cache holding up to size movies and size listings,
+// each for at most ttl.
+func NewMovieCache(size int, ttl time.Duration) *MovieCache {
+	return &MovieCache{
+		movies: cache.New[int64, *Movie](size, ttl),
+		lists:  cache.New[string, movieList](size, ttl),
+	}
+}
+
+// Stats returns the cache counters for single movies and listings.
+func (c *MovieCache) Stats() map[string]cache.Stats {
+	return map[string]cache.Stats{
+		"movies": c.movies.Stats(),
+		"lists":  c.lists.Stats(),
+	}
+}
+
+// Purge drops every cached movie and listing. It is used when changes made
+// by other instances may have been missed.
+func (c *MovieCache) Purge() {
+	c.generation.Add(1)
+	c.movies.Purge()
+	c.lists.Purge()
+}
+
+// Invalidate drops the cached copies of the given movies and every cached
+// listing.
+func (c *MovieCache) Invalidate(ids ...int64) {
+	c.generation.Add(1)
+	for _, id := range ids {
+		c.movies.Delete(id)
+	}
+}
+
+func (c *MovieCache) get(ctx context.Context, id int64, load func(context.Context) (*Movie, error), storable func() bool) (*Movie, error) {
+	gen := c.generation.Load()
+	movie, err := c.movies.GetOrLoad(ctx, id, load, func() bool {
+		return c.generation.Load() == gen && storable()
+	})
+	if err != nil {
+		// A caller that stops waiting gets a bare ctx.Err() from the cache.
+		return nil, contextError(ctx, err)
+	}
+	return movie.clone(), nil
+}
+
+func (c *MovieCache) getAll(ctx context.Context, title string, genres []string, filters Filters, load func(context.Context) (movieList, error), storable func() bool) ([]*Movie, Metadata, error) {
+	gen := c.generation.Load()
+	key := fmt.Sprintf("%d:%s", gen, listKey(title, genres, filters))
+
+	list, err := c.lists.GetOrLoad(ctx, key, load, func() bool {
+		return c.generation.Load() == gen && storable()
+	})
+	if err != nil {
+		return nil, Metadata{}, contextError(ctx, err)
+	}
+
+	movies := make([]*Movie, len(list.movies))
+	for i, movie := range list.movies {
+		movies[i] = movie.clone()
+	}
+	return movies, list.metadata, nil
+}
+
+// listKey normalises the GetAll arguments so that requests which must return
+// the same rows share a cache entry: title matching is case-insensitive and
+// genre containment ignores order and duplicates.
+func listKey(title string, genres []string, filters Filters) string {
+	normalised := make([]string, 0, len(genres))
+	seen := make(map[string]bool, len(genres))
+	for _, genre := range genres {
+		if !seen[genre] {
+			seen[genre] = true
+			normalised = append(normalised, genre)
+		}
+	}
+	sort.Strings(normalised)
+
+	key, _ := json.Marshal([]interface{}{
+		strings.Join(strings.Fields(strings.ToLower(title)), " "),
+		normalised,
+		filters.Page,
+		filters.PageSize,
+		filters.Sort,
+	})
+	return string(key)
+}
+
+// clone returns a deep copy, so callers can modify cached movies freely.
+func (m *Movie) clone() *Movie {
+	c := *m
+	c.Genres = append([]string(nil), m.Genres...)
+	return &c
+}
@@ -0,0 +1,62 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestListKeyNormalisesFilters(t *testing.T) {
+	filters := Filters{Page: 1, PageSize: 20, Sort: "id"}
+
+	a := listKey("The  Matrix", []string{"sci-fi", "action", "action"}, filters)
+	b := listKey("the matrix", []string{"action", "sci-fi"}, filters)
+	if a != b {
+		t.Errorf("expected equivalent filters to share a key, got %s and %s", a, b)
+	}
+
+	filters.Page = 2
+	if c := listKey("the matrix", []string{"action", "sci-fi"}, filters); c == b {
+		t.Error("expected a different page to use a different key")
+	}
+}
+
+func TestMovieCacheWaiterTimeout(t *testing.T) {
+	c := NewMovieCache(10, time.Minute)
+	release := make(chan struct{})
+	defer close(release)
+	load := func(context.Context) (*Movie, error) {
+		<-release
+		return &Movie{ID: 1}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := c.get(ctx, 1, load, func() bool { return true })
+	if !errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("got %v; want ErrQueryTimeout", err)
+	}
+}
+
+func TestMovieCacheReadYourWrites(t *testing.T) {
+	cluster := NewCluster(nil, []*sql.DB{nil}, time.Minute)
+	m := MovieModel{Cache: NewMovieCache(10, time.Minute), cluster: cluster}
+	writer := ContextWithSession(context.Background(), "writer")
+	other := ContextWithSession(context.Background(), "other")
+
+	if !m.cached(writer) || !m.storable() {
+		t.Fatal("expected the cache to be used before any write")
+	}
+	cluster.markWrite(writer)
+	if m.cached(writer) {
+		t.Error("a session within its sticky window read from the cache")
+	}
+	if !m.cached(other) {
+		t.Error("an unrelated session bypassed the cache")
+	}
+	if m.storable() {
+		t.Error("a load right after a write may be cached")
+	}
+}
@@ -0,0 +1,124 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MovieStats holds aggregates over the movie catalogue, or over the movies
+// matching a title and genre filter.
+type MovieStats struct {
+	// Number of movies
+	Total int64 `json:"total" example:"1200"`
+
+	// Average runtime in minutes
+	AverageRuntime float64 `json:"average_runtime" example:"112.5"`
+
+	// Median runtime in minutes
+	MedianRuntime float64 `json:"median_runtime" example:"108"`
+
+	// Number of movies per genre
+	Genres map[string]int64 `json:"genres"`
+
+	// Number of movies per release decade, keyed like "1990s"
+	Decades map[string]int64 `json:"decades"`
+
+	// Number of movies added per month over the last twelve months, keyed like "2024-05"
+	AdditionsByMonth map[string]int64 `json:"additions_by_month"`
+
+	// When the figures were computed. Unfiltered statistics come from a
+	// periodically refreshed snapshot and may be older than the request.
+	RefreshedAt time.Time `json:"refreshed_at"`
+
+	// Either "snapshot" or "live"
+	Source string `json:"source" example:"snapshot"`
+}
+
+// Stats returns aggregates for the movies matching title and genres. The
+// unfiltered statistics are read from the movie_stats materialized view;
+// filtered ones are computed on demand.
+func (m MovieModel) Stats(ctx context.Context, title string, genres []string) (*MovieStats, error) {
+	if title == "" && len(genres) == 0 {
+		return m.snapshotStats(ctx)
+	}
+
+	query := `
+	SELECT now(), total, average_runtime, median_runtime, by_genre, by_decade, additions_by_month
+	FROM movie_statistics($1, $2)`
+
+	ctx = WithQueryName(ctx, "movies.stats_live")
+	ctx, cancel := m.Timeouts.read(ctx)
+	defer cancel()
+
+	stats, err := scanMovieStats(m.ReadDB.QueryRowContext(ctx, query, title, pq.Array(genres)))
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	stats.Source = "live"
+	return stats, nil
+}
+
+func (m MovieModel) snapshotStats(ctx context.Context) (*MovieStats, error) {
+	query := `
+	SELECT refreshed_at, total, average_runtime, median_runtime, by_genre, by_decade, additions_by_month
+	FROM movie_stats`
+
+	ctx = WithQueryName(ctx, "movies.stats_snapshot")
+	ctx, cancel := m.Timeouts.read(ctx)
+	defer cancel()
+
+	stats, err := scanMovieStats(m.ReadDB.QueryRowContext(ctx, query))
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	stats.Source = "snapshot"
+	return stats, nil
+}
+
+// RefreshStats recomputes the movie_stats materialized view. The refresh runs
+// concurrently, so readers keep seeing the previous snapshot until it is done.
+func (m MovieModel) RefreshStats(ctx context.Context) error {
+	ctx = WithQueryName(ctx, "movies.refresh_stats")
+
+	_, err := m.DB.ExecContext(ctx, `REFRESH MATERIALIZED VIEW CONCURRENTLY movie_stats`)
+	return contextError(ctx, err)
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMovieStats(row rowScanner) (*MovieStats, error) {
+	var stats MovieStats
+	var genres, decades, additions []byte
+
+	err := row.Scan(
+		&stats.RefreshedAt,
+		&stats.Total,
+		&stats.AverageRuntime,
+		&stats.MedianRuntime,
+		&genres,
+		&decades,
+		&additions,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range []struct {
+		raw []byte
+		dst *map[string]int64
+	}{
+		{genres, &stats.Genres},
+		{decades, &stats.Decades},
+		{additions, &stats.AdditionsByMonth},
+	} {
+		if err := json.Unmarshal(field.raw, field.dst); err != nil {
+			return nil, err
+		}
+	}
+	return &stats, nil
+}
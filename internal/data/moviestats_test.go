@@ -0,0 +1,42 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeRow []interface{}
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	for i, d := range dest {
+		switch d := d.(type) {
+		case *time.Time:
+			*d = r[i].(time.Time)
+		case *int64:
+			*d = r[i].(int64)
+		case *float64:
+			*d = r[i].(float64)
+		case *[]byte:
+			*d = []byte(r[i].(string))
+		}
+	}
+	return nil
+}
+
+func TestScanMovieStats(t *testing.T) {
+	now := time.Now()
+	stats, err := scanMovieStats(fakeRow{now, int64(3), 100.0, 95.0, `{"drama": 2, "comedy": 1}`, `{"1990s": 3}`, `{}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Total != 3 || stats.Genres["drama"] != 2 || stats.Decades["1990s"] != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if stats.AdditionsByMonth == nil {
+		t.Fatal("expected an empty, non-nil additions map")
+	}
+	if !stats.RefreshedAt.Equal(now) {
+		t.Fatalf("refreshed_at = %v, want %v", stats.RefreshedAt, now)
+	}
+}
@@ -3,7 +3,6 @@ package data
 import (
 	"context"
 	"database/sql"
-	"time"
 
 	"github.com/lib/pq"
 )
@@ -11,7 +10,9 @@ import (
 type Permissions []string
 
 type PermissionModel struct {
-	DB *sql.DB
+	DB       DBTX
+	ReadDB   DBTX
+	Timeouts Timeouts
 }
 
 func (p Permissions) Include(code string) bool {
@@ -23,7 +24,7 @@ func (p Permissions) Include(code string) bool {
 	return false
 }
 
-func (p PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+func (p PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
 	query := `
 SELECT permissions.code
 FROM permissions
@@ -31,12 +32,13 @@ INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
 INNER JOIN users ON users_permissions.user_id = users.id
 WHERE users.id = $1;
 `
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx = WithQueryName(ctx, "permissions.get_all_for_user")
+	ctx, cancel := p.Timeouts.read(ctx)
 	defer cancel()
 
-	rows, err := p.DB.QueryContext(ctx, query, userID)
+	rows, err := p.ReadDB.QueryContext(ctx, query, userID)
 	if err != nil {
-		return nil, err
+		return nil, contextError(ctx, err)
 	}
 	defer func(rows *sql.Rows) {
 		err := rows.Close()
@@ -49,27 +51,77 @@ WHERE users.id = $1;
 		var permission string
 		err := rows.Scan(&permission)
 		if err != nil {
-			return nil, err
+			return nil, contextError(ctx, err)
 		}
 		permissions = append(permissions, permission)
 		if err = rows.Err(); err != nil {
-			return nil, err
+			return nil, contextError(ctx, err)
 		}
 	}
 	return permissions, nil
 }
 
-func (p PermissionModel) AddForUser(userID int64, codes ...string) error {
+func (p PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
 	query := `
 INSERT INTO users_permissions
 SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+ON CONFLICT DO NOTHING
 `
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx = WithQueryName(ctx, "permissions.add_for_user")
+	ctx, cancel := p.Timeouts.write(ctx)
 	defer cancel()
 	_, err := p.DB.ExecContext(ctx, query, userID, pq.Array(codes))
 	if err != nil {
-		return err
+		return contextError(ctx, err)
 	}
 	return nil
 
 }
+
+// GetAll returns every permission code that exists.
+func (p PermissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	query := `SELECT code FROM permissions ORDER BY code`
+
+	ctx = WithQueryName(ctx, "permissions.get_all")
+	ctx, cancel := p.Timeouts.read(ctx)
+	defer cancel()
+
+	rows, err := p.ReadDB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, contextError(ctx, err)
+		}
+		permissions = append(permissions, permission)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return permissions, nil
+}
+
+// RemoveForUser revokes the given permission codes from a user. Codes the
+// user does not have are ignored.
+func (p PermissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	query := `
+DELETE FROM users_permissions
+USING permissions
+WHERE users_permissions.permission_id = permissions.id
+  AND users_permissions.user_id = $1
+  AND permissions.code = ANY($2)
+`
+	ctx = WithQueryName(ctx, "permissions.remove_for_user")
+	ctx, cancel := p.Timeouts.write(ctx)
+	defer cancel()
+	_, err := p.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// ScheduledTask is the outcome of the latest run of a scheduler task.
+type ScheduledTask struct {
+	Name          string     `json:"name" example:"purge-expired-tokens"`
+	LastStartedAt time.Time  `json:"last_started_at"`
+	LastDuration  Duration   `json:"last_duration" swaggertype:"string" example:"1.2s"`
+	LastError     *string    `json:"last_error,omitempty"`
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+	Runs          int64      `json:"runs"`
+	Failures      int64      `json:"failures"`
+}
+
+// Duration is a time.Duration that is written to JSON in the 1m30s form.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + time.Duration(d).String() + `"`), nil
+}
+
+type ScheduledTaskModel struct {
+	DB       DBTX
+	ReadDB   DBTX
+	Timeouts Timeouts
+}
+
+// RecordRun stores the outcome of a task run that started at startedAt and
+// took duration. A nil runErr records a success.
+func (m ScheduledTaskModel) RecordRun(ctx context.Context, name string, startedAt time.Time, duration time.Duration, runErr error) error {
+	query := `
+INSERT INTO scheduled_tasks (name, last_started_at, last_duration, last_error, last_success_at, runs, failures)
+VALUES ($1, $2, make_interval(secs => $3), $4, CASE WHEN $4::text IS NULL THEN now() END, 1, CASE WHEN $4::text IS NULL THEN 0 ELSE 1 END)
+ON CONFLICT (name) DO UPDATE
+SET last_started_at = EXCLUDED.last_started_at,
+    last_duration = EXCLUDED.last_duration,
+    last_error = EXCLUDED.last_error,
+    last_success_at = COALESCE(EXCLUDED.last_success_at, scheduled_tasks.last_success_at),
+    runs = scheduled_tasks.runs + 1,
+    failures = scheduled_tasks.failures + EXCLUDED.failures`
+
+	var lastError *string
+	if runErr != nil {
+		msg := runErr.Error()
+		lastError = &msg
+	}
+
+	ctx = WithQueryName(ctx, "scheduled_tasks.record_run")
+	ctx, cancel := m.Timeouts.write(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, name, startedAt, duration.Seconds(), lastError)
+	return contextError(ctx, err)
+}
+
+// GetAll returns the latest run of every task that has run at least once.
+func (m ScheduledTaskModel) GetAll(ctx context.Context) ([]*ScheduledTask, error) {
+	query := `
+SELECT name, last_started_at, extract(epoch FROM last_duration), last_error, last_success_at, runs, failures
+FROM scheduled_tasks
+ORDER BY name`
+
+	ctx = WithQueryName(ctx, "scheduled_tasks.get_all")
+	ctx, cancel := m.Timeouts.read(ctx)
+	defer cancel()
+
+	rows, err := m.ReadDB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	tasks := []*ScheduledTask{}
+	for rows.Next() {
+		var task ScheduledTask
+		var seconds float64
+		err := rows.Scan(&task.Name, &task.LastStartedAt, &seconds, &task.LastError, &task.LastSuccessAt,
+			&task.Runs, &task.Failures)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		task.LastDuration = Duration(seconds * float64(time.Second))
+		tasks = append(tasks, &task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return tasks, nil
+}
+
+// AdvisoryLock is a session-level Postgres advisory lock. It is held on a
+// connection of its own, so Postgres releases it as soon as that connection
+// is lost, for example when the instance holding it dies.
+type AdvisoryLock struct {
+	db  *sql.DB
+	key int64
+
+	mu   sync.Mutex
+	conn *sql.Conn
+}
+
+// AdvisoryLock returns the advisory lock with the given name on the primary.
+// Instances that use the same name compete for the same lock.
+func (m Models) AdvisoryLock(name string) *AdvisoryLock {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return &AdvisoryLock{db: m.cluster.Primary, key: int64(h.Sum64())}
+}
+
+// TryAcquire reports whether this instance holds the lock, taking it if it
+// is free. A lock that was held is checked to still be alive, so a lost
+// connection is noticed the next time TryAcquire is called.
+func (l *AdvisoryLock) TryAcquire(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn != nil {
+		if err := l.conn.PingContext(ctx); err == nil {
+			return true, nil
+		}
+		l.discard()
+	}
+
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, contextError(ctx, err)
+	}
+
+	var acquired bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, l.key).Scan(&acquired)
+	if err != nil || !acquired {
+		_ = conn.Close()
+		return false, contextError(ctx, err)
+	}
+	l.conn = conn
+	return true, nil
+}
+
+// Release gives the lock up if it is held.
+func (l *AdvisoryLock) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := l.conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, l.key); err != nil {
+		l.discard()
+		return
+	}
+	_ = l.conn.Close()
+	l.conn = nil
+}
+
+// discard closes the lock's connection without returning it to the pool, so
+// that a lock that could not be released ends with the session.
+func (l *AdvisoryLock) discard() {
+	_ = l.conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	_ = l.conn.Close()
+	l.conn = nil
+}
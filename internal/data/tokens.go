@@ -4,7 +4,6 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
-	"database/sql"
 	"encoding/base32"
 	"time"
 
@@ -43,51 +42,112 @@ func generateToken(scope string, userID int64, ttl time.Duration) (*Token, error
 	return token, nil
 }
 
+// TokenFromPlaintext builds a token around a plaintext chosen by the caller,
+// such as a pre-issued token in a development fixture.
+func TokenFromPlaintext(plaintext string, userID int64, ttl time.Duration, scope string) *Token {
+	hash := sha256.Sum256([]byte(plaintext))
+	return &Token{
+		Plaintext: plaintext,
+		Hash:      hash[:],
+		UserID:    userID,
+		Expiry:    time.Now().Add(ttl),
+		Scope:     scope,
+	}
+}
+
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
-	v.Check(tokenPlaintext != "", "token", "must be provided")
-	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+	v.CheckCode(tokenPlaintext != "", "token", validator.CodeRequired, "must be provided")
+	v.CheckCode(len(tokenPlaintext) == 26, "token", validator.CodeWrongLength, "must be 26 bytes long")
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB       DBTX
+	Timeouts Timeouts
 }
 
-func (t TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (t TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(scope, userID, ttl)
 	if err != nil {
 		return nil, err
 	}
 
-	err = t.Insert(token)
+	err = t.Insert(ctx, token)
 	if err != nil {
 		return nil, err
 	}
 	return token, nil
 }
 
-func (t TokenModel) Insert(token *Token) error {
+func (t TokenModel) Insert(ctx context.Context, token *Token) error {
 	query := `
 INSERT INTO tokens (hash, user_id, expiry, scope)
 VALUES ($1, $2, $3, $4);
 `
 	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx = WithQueryName(ctx, "tokens.insert")
+	ctx, cancel := t.Timeouts.write(ctx)
 	defer cancel()
 
 	_, err := t.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	return nil
+}
+
+// Upsert inserts a token with a known plaintext, refreshing its expiry when
+// the same token already exists for the same user and scope. It exists for
+// fixtures that are loaded repeatedly; regular token issuance uses Insert. A
+// token that already exists for another user or scope is left alone, and
+// Upsert returns ErrEditConflict.
+func (t TokenModel) Upsert(ctx context.Context, token *Token) error {
+	query := `
+INSERT INTO tokens (hash, user_id, expiry, scope)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (hash) DO UPDATE SET expiry = EXCLUDED.expiry
+WHERE tokens.user_id = EXCLUDED.user_id AND tokens.scope = EXCLUDED.scope;
+`
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+	ctx = WithQueryName(ctx, "tokens.upsert")
+	ctx, cancel := t.Timeouts.write(ctx)
+	defer cancel()
+
+	result, err := t.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
 	return nil
 }
 
-func (t TokenModel) DeleDeleteAllForUser(scope string, userID int64) error {
+func (t TokenModel) DeleDeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	query := `DELETE FROM tokens WHERE user_id = $1 AND scope = $2;`
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx = WithQueryName(ctx, "tokens.delete_all_for_user")
+	ctx, cancel := t.Timeouts.write(ctx)
 	defer cancel()
 	_, err := t.DB.ExecContext(ctx, query, userID, scope)
 	if err != nil {
-		return err
+		return contextError(ctx, err)
 	}
 	return nil
 }
+
+// DeleteExpired removes every token whose expiry has passed and returns how
+// many were removed.
+func (t TokenModel) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `DELETE FROM tokens WHERE expiry < now();`
+	ctx = WithQueryName(ctx, "tokens.delete_expired")
+	ctx, cancel := t.Timeouts.write(ctx)
+	defer cancel()
+	result, err := t.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, contextError(ctx, err)
+	}
+	return result.RowsAffected()
+}
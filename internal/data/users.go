@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -55,19 +56,19 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
-	v.Check(email != "", "email", "must be provided")
-	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+	v.CheckCode(email != "", "email", validator.CodeRequired, "must be provided")
+	v.CheckCode(validator.Matches(email, validator.EmailRX), "email", validator.CodeInvalidFormat, "must be a valid email address")
 }
 
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
-	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.CheckCode(password != "", "password", validator.CodeRequired, "must be provided")
+	v.CheckCode(len(password) >= 8, "password", validator.CodeTooShort, "must be at least 8 bytes long")
+	v.CheckCode(len(password) <= 72, "password", validator.CodeTooLong, "must not be more than 72 bytes long")
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
-	v.Check(user.Name != "", "name", "must be provided")
-	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.CheckCode(user.Name != "", "name", validator.CodeRequired, "must be provided")
+	v.CheckCode(len(user.Name) <= 500, "name", validator.CodeTooLong, "must not be more than 500 bytes long")
 	ValidateEmail(v, user.Email)
 	if user.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.plaintext)
@@ -78,17 +79,20 @@ func ValidateUser(v *validator.Validator, user *User) {
 }
 
 type UserModel struct {
-	DB *sql.DB
+	DB       DBTX
+	ReadDB   DBTX
+	Timeouts Timeouts
 }
 
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
 INSERT INTO users (name, email, password_hash, activated)
 VALUES ($1, $2, $3, $4)
 RETURNING id, created_at, version;
 `
 	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx = WithQueryName(ctx, "users.insert")
+	ctx, cancel := m.Timeouts.write(ctx)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
@@ -96,22 +100,23 @@ RETURNING id, created_at, version;
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
 		default:
-			return err
+			return contextError(ctx, err)
 		}
 	}
 	return nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
 				SELECT id, created_at, name, email, password_hash, activated, version
 				FROM users
 				WHERE email = $1;
 `
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx = WithQueryName(ctx, "users.get_by_email")
+	ctx, cancel := m.Timeouts.read(ctx)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, email).Scan(&user.ID,
+	err := m.ReadDB.QueryRowContext(ctx, query, email).Scan(&user.ID,
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
@@ -123,13 +128,13 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, contextError(ctx, err)
 		}
 	}
 	return &user, nil
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := `
 UPDATE users
 SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
@@ -137,8 +142,9 @@ WHERE id = $5 AND version = $6
 RETURNING version
 `
 
-	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated, user.ID}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated, user.ID, user.Version}
+	ctx = WithQueryName(ctx, "users.update")
+	ctx, cancel := m.Timeouts.write(ctx)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
@@ -148,13 +154,13 @@ RETURNING version
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
-			return err
+			return contextError(ctx, err)
 		}
 	}
 	return nil
 }
 
-func (m UserModel) GetForToken(scope, tokenPlaintext string) (*User, error) {
+func (m UserModel) GetForToken(ctx context.Context, scope, tokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 	query := `
 SELECT 
@@ -173,10 +179,11 @@ WHERE tokens.hash = $1
 `
 	var user User
 	args := []interface{}{tokenHash[:], scope, time.Now()}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx = WithQueryName(ctx, "users.get_for_token")
+	ctx, cancel := m.Timeouts.read(ctx)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+	err := m.ReadDB.QueryRowContext(ctx, query, args...).Scan(
 		&user.ID,
 		&user.CreatedAt,
 		&user.Name,
@@ -190,7 +197,7 @@ WHERE tokens.hash = $1
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, contextError(ctx, err)
 		}
 	}
 	return &user, nil
@@ -201,3 +208,26 @@ var AnonymousUser = &User{}
 func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
+
+// DeleteUnactivated removes users that registered more than age ago and never
+// activated their account, together with their tokens and permissions. It
+// returns how many users were removed. age must be positive, since a zero
+// age would also remove accounts that registered moments ago.
+func (m UserModel) DeleteUnactivated(ctx context.Context, age time.Duration) (int64, error) {
+	if age <= 0 {
+		return 0, fmt.Errorf("data: unactivated user age must be positive, got %s", age)
+	}
+
+	query := `
+DELETE FROM users
+WHERE activated = false AND created_at < now() - make_interval(secs => $1)`
+
+	ctx = WithQueryName(ctx, "users.delete_unactivated")
+	ctx, cancel := m.Timeouts.write(ctx)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, age.Seconds())
+	if err != nil {
+		return 0, contextError(ctx, err)
+	}
+	return result.RowsAffected()
+}
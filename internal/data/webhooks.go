@@ -0,0 +1,350 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.samedarslan28.net/internal/validator"
+)
+
+// WebhookEvents lists the events a webhook subscription can filter on.
+var WebhookEvents = []string{"movie.created", "movie.updated", "movie.deleted"}
+
+// Webhook delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// WebhookSubscription is a partner endpoint that is notified of catalogue
+// changes.
+type WebhookSubscription struct {
+	// Unique identifier for the subscription
+	ID int64 `json:"id" example:"3"`
+
+	// Timestamp when the subscription was created
+	CreatedAt time.Time `json:"created_at"`
+
+	// URL that deliveries are POSTed to
+	URL string `json:"url" example:"https://partner.example.com/hooks/greenlight"`
+
+	// Events to deliver; empty means every event
+	Events []string `json:"events" example:"[\"movie.created\"]"`
+
+	// Key for the HMAC-SHA256 delivery signature. Only returned when the
+	// subscription is created.
+	Secret string `json:"secret,omitempty"`
+
+	// Whether deliveries are queued for the subscription
+	Active bool `json:"active" example:"true"`
+
+	// ID of the user who created the subscription
+	UserID int64 `json:"-"`
+}
+
+// WebhookDelivery is one event queued for, or delivered to, a subscription.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	SubscriptionID int64           `json:"subscription_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload" swaggertype:"object"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at,omitempty"`
+	LastStatus     *int            `json:"last_status,omitempty"`
+	LastError      *string         `json:"last_error,omitempty"`
+
+	// Target of a claimed delivery, filled in by ClaimDeliveries only
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+func ValidateWebhookSubscription(v *validator.Validator, s *WebhookSubscription) {
+	u, err := url.Parse(s.URL)
+	v.CheckCode(s.URL != "", "url", validator.CodeRequired, "must be provided")
+	v.CheckCode(err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "", "url", validator.CodeInvalidFormat, "must be an absolute http or https URL")
+	v.CheckCode(len(s.URL) <= 2048, "url", validator.CodeTooLong, "must not be more than 2048 bytes long")
+
+	for _, event := range s.Events {
+		v.CheckCode(validator.In(event, WebhookEvents...), "events", validator.CodeNotAllowed, "must only contain movie.created, movie.updated or movie.deleted")
+	}
+	v.CheckCode(validator.Unique(s.Events), "events", validator.CodeDuplicate, "must not contain duplicate values")
+
+	v.CheckCode(len(s.Secret) >= 16, "secret", validator.CodeTooShort, "must be at least 16 bytes long")
+	v.CheckCode(len(s.Secret) <= 256, "secret", validator.CodeTooLong, "must not be more than 256 bytes long")
+}
+
+type WebhookModel struct {
+	DB       DBTX
+	ReadDB   DBTX
+	Timeouts Timeouts
+}
+
+func (m WebhookModel) InsertSubscription(ctx context.Context, s *WebhookSubscription) error {
+	query := `
+INSERT INTO webhook_subscriptions (user_id, url, events, secret)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at, active`
+
+	ctx = WithQueryName(ctx, "webhooks.insert_subscription")
+	ctx, cancel := m.Timeouts.write(ctx)
+	defer cancel()
+
+	args := []interface{}{s.UserID, s.URL, pq.Array(s.Events), s.Secret}
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&s.ID, &s.CreatedAt, &s.Active)
+	return contextError(ctx, err)
+}
+
+func (m WebhookModel) GetSubscription(ctx context.Context, id int64) (*WebhookSubscription, error) {
+	query := `
+SELECT id, created_at, url, events, active
+FROM webhook_subscriptions
+WHERE id = $1`
+
+	ctx = WithQueryName(ctx, "webhooks.get_subscription")
+	ctx, cancel := m.Timeouts.read(ctx)
+	defer cancel()
+
+	var s WebhookSubscription
+	err := m.ReadDB.QueryRowContext(ctx, query, id).Scan(&s.ID, &s.CreatedAt, &s.URL, pq.Array(&s.Events), &s.Active)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, contextError(ctx, err)
+		}
+	}
+	return &s, nil
+}
+
+func (m WebhookModel) GetAllSubscriptions(ctx context.Context) ([]*WebhookSubscription, error) {
+	query := `
+SELECT id, created_at, url, events, active
+FROM webhook_subscriptions
+ORDER BY id`
+
+	ctx = WithQueryName(ctx, "webhooks.get_all_subscriptions")
+	ctx, cancel := m.Timeouts.read(ctx)
+	defer cancel()
+
+	rows, err := m.ReadDB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	subscriptions := []*WebhookSubscription{}
+	for rows.Next() {
+		var s WebhookSubscription
+		err := rows.Scan(&s.ID, &s.CreatedAt, &s.URL, pq.Array(&s.Events), &s.Active)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		subscriptions = append(subscriptions, &s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return subscriptions, nil
+}
+
+// DeleteSubscription removes a subscription together with its delivery log.
+func (m WebhookModel) DeleteSubscription(ctx context.Context, id int64) error {
+	query := `DELETE FROM webhook_subscriptions WHERE id = $1`
+
+	ctx = WithQueryName(ctx, "webhooks.delete_subscription")
+	ctx, cancel := m.Timeouts.write(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetDeliveries returns a page of the delivery log of a subscription, newest
+// first. An empty status returns deliveries in every state.
+func (m WebhookModel) GetDeliveries(ctx context.Context, subscriptionID int64, status string, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	query := `
+SELECT count(*) OVER(), id, created_at, subscription_id, event, payload, status, attempts,
+       next_attempt_at, last_attempt_at, last_status, last_error
+FROM webhook_deliveries
+WHERE subscription_id = $1 AND (status = $2 OR $2 = '')
+ORDER BY id DESC
+LIMIT $3 OFFSET $4`
+
+	ctx = WithQueryName(ctx, "webhooks.get_deliveries")
+	ctx, cancel := m.Timeouts.read(ctx)
+	defer cancel()
+
+	rows, err := m.ReadDB.QueryContext(ctx, query, subscriptionID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		var nextAttempt time.Time
+		err := rows.Scan(&totalRecords, &d.ID, &d.CreatedAt, &d.SubscriptionID, &d.Event, (*[]byte)(&d.Payload),
+			&d.Status, &d.Attempts, &nextAttempt, &d.LastAttemptAt, &d.LastStatus, &d.LastError)
+		if err != nil {
+			return nil, Metadata{}, contextError(ctx, err)
+		}
+		if d.Status == DeliveryPending {
+			d.NextAttemptAt = &nextAttempt
+		}
+		deliveries = append(deliveries, &d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, contextError(ctx, err)
+	}
+	return deliveries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// ReplayDelivery queues a delivery of the subscription again with a fresh
+// attempt budget. A delivery that a worker is sending right now cannot be
+// replayed: ReplayDelivery returns ErrEditConflict for it.
+func (m WebhookModel) ReplayDelivery(ctx context.Context, subscriptionID, id int64) error {
+	query := `
+WITH replayed AS (
+    UPDATE webhook_deliveries
+    SET status = 'pending', attempts = 0, next_attempt_at = now(), claimed_until = NULL
+    WHERE id = $1 AND subscription_id = $2 AND (claimed_until IS NULL OR claimed_until <= now())
+    RETURNING id
+)
+SELECT EXISTS (SELECT 1 FROM replayed),
+       EXISTS (SELECT 1 FROM webhook_deliveries WHERE id = $1 AND subscription_id = $2)`
+
+	ctx = WithQueryName(ctx, "webhooks.replay_delivery")
+	ctx, cancel := m.Timeouts.write(ctx)
+	defer cancel()
+
+	var replayed, exists bool
+	err := m.DB.QueryRowContext(ctx, query, id, subscriptionID).Scan(&replayed, &exists)
+	switch {
+	case err != nil:
+		return contextError(ctx, err)
+	case replayed:
+		return nil
+	case exists:
+		return ErrEditConflict
+	default:
+		return ErrRecordNotFound
+	}
+}
+
+// ClaimDeliveries takes up to limit due deliveries of active subscriptions
+// and counts the attempt. Claimed deliveries are not due again until lease
+// has passed, so a worker that dies mid-delivery only delays them.
+func (m WebhookModel) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	query := `
+UPDATE webhook_deliveries d
+SET attempts = d.attempts + 1,
+    next_attempt_at = now() + make_interval(secs => $2),
+    claimed_until = now() + make_interval(secs => $2),
+    last_attempt_at = now()
+FROM webhook_subscriptions s
+WHERE s.id = d.subscription_id
+  AND d.id IN (
+    SELECT dd.id
+    FROM webhook_deliveries dd
+    JOIN webhook_subscriptions ss ON ss.id = dd.subscription_id
+    WHERE dd.status = 'pending' AND dd.next_attempt_at <= now() AND ss.active
+    ORDER BY dd.next_attempt_at
+    LIMIT $1
+    FOR UPDATE OF dd SKIP LOCKED
+  )
+RETURNING d.id, d.created_at, d.subscription_id, d.event, d.payload, d.attempts, s.url, s.secret`
+
+	ctx = WithQueryName(ctx, "webhooks.claim_deliveries")
+	ctx, cancel := m.Timeouts.write(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		d := WebhookDelivery{Status: DeliveryPending}
+		err := rows.Scan(&d.ID, &d.CreatedAt, &d.SubscriptionID, &d.Event, (*[]byte)(&d.Payload), &d.Attempts, &d.URL, &d.Secret)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		deliveries = append(deliveries, &d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return deliveries, nil
+}
+
+// RecordAttempt stores the outcome of an attempt at the claimed delivery d.
+// status is the receiver's HTTP status, or zero if no response arrived. A
+// failed delivery is retried at retryAt, or dead-lettered when retryAt is
+// zero. If the delivery was claimed again or replayed since d was claimed,
+// RecordAttempt returns ErrEditConflict and changes nothing.
+func (m WebhookModel) RecordAttempt(ctx context.Context, d *WebhookDelivery, status int, attemptErr error, retryAt time.Time) error {
+	var (
+		state     = DeliveryDelivered
+		lastError *string
+		next      interface{}
+	)
+	if attemptErr != nil {
+		msg := attemptErr.Error()
+		lastError = &msg
+		state = DeliveryDead
+		if !retryAt.IsZero() {
+			state, next = DeliveryPending, retryAt
+		}
+	}
+	var lastStatus *int
+	if status != 0 {
+		lastStatus = &status
+	}
+
+	query := `
+UPDATE webhook_deliveries
+SET status = $3, last_status = $4, last_error = $5, next_attempt_at = coalesce($6, next_attempt_at),
+    claimed_until = NULL
+WHERE id = $1 AND status = 'pending' AND attempts = $2`
+
+	ctx = WithQueryName(ctx, "webhooks.record_attempt")
+	ctx, cancel := m.Timeouts.write(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, d.ID, d.Attempts, state, lastStatus, lastError, next)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+// Package events fans movie change notifications out to streaming clients.
+//
+// Changes are announced by a trigger on the movies table through Postgres
+// NOTIFY, so every API instance sees every change in the same order,
+// whichever instance made it. Each instance keeps the most recent events in
+// a bounded buffer so that clients can resume after reconnecting.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Channel is the NOTIFY channel used by the movies trigger.
+const Channel = "movie_events"
+
+// Event types.
+const (
+	Created = "created"
+	Updated = "updated"
+	Deleted = "deleted"
+)
+
+// ErrClosed is returned by Subscribe once the broker is closed.
+var ErrClosed = errors.New("events: broker closed")
+
+// subscriberBuffer is how many events a subscriber may fall behind before it
+// is disconnected. A disconnected client resumes from the replay buffer.
+const subscriberBuffer = 64
+
+// Event is a single change to a movie.
+type Event struct {
+	ID      int64  `json:"id"`
+	Type    string `json:"type"`
+	MovieID int64  `json:"movie_id"`
+	Version int32  `json:"version"`
+}
+
+// Subscription receives the events published after it was created. C is
+// closed when the subscriber falls too far behind, when the broker loses
+// track of changes, or when the broker is closed.
+type Subscription struct {
+	C      <-chan Event
+	c      chan Event
+	broker *Broker
+}
+
+// Close stops the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.broker.unsubscribe(s)
+}
+
+// Broker keeps the recent events and the current subscribers.
+type Broker struct {
+	mu          sync.Mutex
+	replay      []Event // ring buffer
+	next        int     // index of the slot for the next event
+	full        bool
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// NewBroker returns a broker that keeps the last replaySize events.
+func NewBroker(replaySize int) *Broker {
+	if replaySize < 1 {
+		replaySize = 1
+	}
+	return &Broker{
+		replay:      make([]Event, replaySize),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe registers a subscriber. If lastID is the ID of a buffered event,
+// the events after it are returned for replay. If lastID is set but cannot be
+// found, resync is true and the client should reload its state: the events
+// it missed are no longer known.
+func (b *Broker) Subscribe(lastID int64, resume bool) (sub *Subscription, replay []Event, resync bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, nil, false, ErrClosed
+	}
+
+	if resume {
+		replay, resync = b.since(lastID)
+	}
+
+	c := make(chan Event, subscriberBuffer)
+	sub = &Subscription{C: c, c: c, broker: b}
+	b.subscribers[sub] = struct{}{}
+	return sub, replay, resync, nil
+}
+
+// Latest returns the ID of the newest buffered event, if there is one.
+func (b *Broker) Latest() (int64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	buffered := b.buffered()
+	if len(buffered) == 0 {
+		return 0, false
+	}
+	return buffered[len(buffered)-1].ID, true
+}
+
+// buffered returns the buffered events, oldest first.
+func (b *Broker) buffered() []Event {
+	if !b.full {
+		return append([]Event(nil), b.replay[:b.next]...)
+	}
+	return append(append([]Event(nil), b.replay[b.next:]...), b.replay[:b.next]...)
+}
+
+// since returns the events after the one with the given ID. IDs come from a
+// database sequence and may arrive out of numeric order, so the position of
+// lastID in the buffer is what matters, not its value.
+func (b *Broker) since(lastID int64) ([]Event, bool) {
+	buffered := b.buffered()
+	for i, e := range buffered {
+		if e.ID == lastID {
+			return buffered[i+1:], false
+		}
+	}
+	return nil, true
+}
+
+// Publish buffers e and delivers it to every subscriber.
+func (b *Broker) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	b.replay[b.next] = e
+	b.next = (b.next + 1) % len(b.replay)
+	if b.next == 0 {
+		b.full = true
+	}
+
+	for sub := range b.subscribers {
+		select {
+		case sub.c <- e:
+		default:
+			// The subscriber is too slow; drop it rather than block
+			// everyone else. It can resume from the replay buffer.
+			b.remove(sub)
+		}
+	}
+}
+
+// Reset forgets the buffered events and disconnects every subscriber. It is
+// used when notifications may have been missed, so that clients resync
+// instead of silently skipping changes.
+func (b *Broker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.next, b.full = 0, false
+	for sub := range b.subscribers {
+		b.remove(sub)
+	}
+}
+
+// Close disconnects every subscriber and refuses new ones. It is meant to be
+// called when the server shuts down, so that open streams do not hold it up.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subscribers {
+		b.remove(sub)
+	}
+}
+
+func (b *Broker) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(sub)
+}
+
+func (b *Broker) remove(sub *Subscription) {
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.c)
+	}
+}
+
+// Listen publishes the notifications sent on Channel until ctx is cancelled.
+// The connection is re-established automatically; because notifications sent
+// while it was down are lost, the broker is reset after a reconnect. Errors
+// are reported to onError, which may be nil.
+func (b *Broker) Listen(ctx context.Context, dsn string, onError func(error)) error {
+	report := func(err error) {
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		report(err)
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(Channel); err != nil {
+		return err
+	}
+
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			if n == nil {
+				// The connection was lost and re-established.
+				b.Reset()
+				continue
+			}
+			var e Event
+			if err := json.Unmarshal([]byte(n.Extra), &e); err != nil {
+				report(err)
+				continue
+			}
+			b.Publish(e)
+		case <-ping.C:
+			go func() {
+				report(listener.Ping())
+			}()
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+)
+
+func ids(events []Event) []int64 {
+	var ids []int64
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestReplay(t *testing.T) {
+	b := NewBroker(3)
+	for _, id := range []int64{1, 3, 2, 4} {
+		b.Publish(Event{ID: id, Type: Updated})
+	}
+
+	tests := []struct {
+		name       string
+		lastID     int64
+		resume     bool
+		wantReplay []int64
+		wantResync bool
+	}{
+		{"fresh", 0, false, nil, false},
+		{"arrival order", 3, true, []int64{2, 4}, false},
+		{"up to date", 4, true, []int64{}, false},
+		{"evicted", 1, true, nil, true},
+		{"unknown", 99, true, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, replay, resync, err := b.Subscribe(tt.lastID, tt.resume)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Close()
+
+			if resync != tt.wantResync {
+				t.Fatalf("resync = %t, want %t", resync, tt.wantResync)
+			}
+			if got := ids(replay); len(got)+len(tt.wantReplay) > 0 && !reflect.DeepEqual(got, tt.wantReplay) {
+				t.Fatalf("replay = %v, want %v", got, tt.wantReplay)
+			}
+		})
+	}
+
+	if latest, ok := b.Latest(); !ok || latest != 4 {
+		t.Fatalf("Latest() = %d, %t; want 4, true", latest, ok)
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	b := NewBroker(10)
+	slow, _, _, _ := b.Subscribe(0, false)
+	fast, _, _, _ := b.Subscribe(0, false)
+	defer fast.Close()
+
+	for i := 1; i <= subscriberBuffer+1; i++ {
+		b.Publish(Event{ID: int64(i)})
+		<-fast.C
+	}
+
+	n := 0
+	for range slow.C {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Fatalf("slow subscriber received %d events before being dropped, want %d", n, subscriberBuffer)
+	}
+}
+
+func TestResetAndClose(t *testing.T) {
+	b := NewBroker(10)
+	b.Publish(Event{ID: 1})
+	sub, _, _, _ := b.Subscribe(0, false)
+
+	b.Reset()
+	if _, ok := <-sub.C; ok {
+		t.Fatal("expected the subscription to be closed by Reset")
+	}
+	if _, ok := b.Latest(); ok {
+		t.Fatal("expected Reset to empty the replay buffer")
+	}
+	sub.Close()
+
+	b.Close()
+	if _, _, _, err := b.Subscribe(0, false); err != ErrClosed {
+		t.Fatalf("Subscribe after Close: err = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,202 @@
+// Package jobs runs background work stored in the database.
+//
+// Jobs are queued with data.JobModel.Enqueue, usually in the same
+// transaction as the change that needs them, so that they survive restarts
+// and are never queued for a change that was rolled back. A Pool claims due
+// jobs with FOR UPDATE SKIP LOCKED, so any number of API instances can share
+// the queue.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"greenlight.samedarslan28.net/internal/data"
+)
+
+// Handler runs one job. Returning an error schedules a retry until the job
+// runs out of attempts.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+// Handle registers a handler for kind that receives the payload decoded into
+// a T. A payload that cannot be decoded fails the job.
+func Handle[T any](p *Pool, kind string, fn func(ctx context.Context, payload T) error) {
+	p.Register(kind, func(ctx context.Context, raw json.RawMessage) error {
+		var payload T
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return fmt.Errorf("jobs: decoding %s payload: %w", kind, err)
+		}
+		return fn(ctx, payload)
+	})
+}
+
+// Backoff returns the delay before retrying after the given attempt: 10s,
+// 20s, 40s, ... doubling up to an hour.
+func Backoff(attempt int) time.Duration {
+	const base, max = 10 * time.Second, time.Hour
+	if attempt < 1 {
+		attempt = 1
+	}
+	if attempt > 20 {
+		return max
+	}
+	return min(base<<(attempt-1), max)
+}
+
+// Store is the queue a Pool takes jobs from. data.JobModel implements it.
+type Store interface {
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*data.Job, error)
+	Finish(ctx context.Context, job *data.Job, jobErr error, retryAt time.Time) error
+}
+
+// Pool runs queued jobs on a fixed number of workers.
+type Pool struct {
+	Store Store
+
+	// Workers is the number of jobs run at once.
+	Workers int
+	// PollInterval is how long the pool waits when the queue is empty.
+	PollInterval time.Duration
+	// Timeout bounds a single run of a job. A job that is still running
+	// after Timeout plus a minute is considered abandoned and runs again.
+	Timeout time.Duration
+
+	// OnError, if set, is called with failed jobs and queue errors.
+	OnError func(err error, properties map[string]string)
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// Register sets the handler for jobs of the given kind.
+func (p *Pool) Register(kind string, h Handler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.handlers == nil {
+		p.handlers = make(map[string]Handler)
+	}
+	p.handlers[kind] = h
+}
+
+func (p *Pool) handler(kind string) (Handler, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	h, ok := p.handlers[kind]
+	return h, ok
+}
+
+// Run processes jobs until ctx is cancelled, then waits for the jobs that
+// are already running. Those are not cancelled with ctx, so a shutdown lets
+// them finish within their Timeout.
+func (p *Pool) Run(ctx context.Context) {
+	sem := make(chan struct{}, max(p.Workers, 1))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		free := cap(sem) - len(sem)
+		var claimed []*data.Job
+		if free > 0 && ctx.Err() == nil {
+			var err error
+			claimed, err = p.Store.Claim(ctx, free, p.Timeout+time.Minute)
+			if err != nil && ctx.Err() == nil {
+				p.report(err, nil)
+			}
+		}
+
+		for _, job := range claimed {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				p.run(job)
+			}()
+		}
+
+		if free > 0 && len(claimed) == free {
+			continue // there may be more waiting
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.PollInterval):
+		}
+	}
+}
+
+// run executes a claimed job and records the outcome.
+func (p *Pool) run(job *data.Job) {
+	err := p.execute(job)
+
+	var retryAt time.Time
+	if err != nil {
+		if job.Attempts < job.MaxAttempts {
+			retryAt = time.Now().Add(Backoff(job.Attempts))
+		}
+		properties := map[string]string{
+			"job_id":   strconv.FormatInt(job.ID, 10),
+			"job_kind": job.Kind,
+			"attempt":  strconv.Itoa(job.Attempts),
+		}
+		if retryAt.IsZero() {
+			properties["dead"] = "true"
+		}
+		if job.RequestID != "" {
+			properties["request_id"] = job.RequestID
+		}
+		p.report(err, properties)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ferr := p.Store.Finish(ctx, job, err, retryAt)
+	if errors.Is(ferr, data.ErrEditConflict) {
+		// The lease ran out and the job was claimed again; that run will
+		// record its own outcome.
+		ferr = fmt.Errorf("jobs: lease expired before the job finished: %w", ferr)
+	}
+	if ferr != nil {
+		p.report(ferr, map[string]string{
+			"job_id":  strconv.FormatInt(job.ID, 10),
+			"attempt": strconv.Itoa(job.Attempts),
+		})
+	}
+}
+
+// execute calls the job's handler, turning a panic into an error so that one
+// bad job cannot take the process down. The handler's context carries the ID
+// of the request that queued the job.
+func (p *Pool) execute(job *data.Job) (err error) {
+	h, ok := p.handler(job.Kind)
+	if !ok {
+		return fmt.Errorf("jobs: no handler for kind %q", job.Kind)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("jobs: %s panicked: %v", job.Kind, r)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+	if job.RequestID != "" {
+		ctx = data.WithRequestID(ctx, job.RequestID)
+	}
+	return h(ctx, job.Payload)
+}
+
+func (p *Pool) report(err error, properties map[string]string) {
+	if p.OnError != nil {
+		p.OnError(err, properties)
+	}
+}
@@ -0,0 +1,109 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"greenlight.samedarslan28.net/internal/data"
+)
+
+type outcome struct {
+	id      int64
+	err     error
+	retryAt time.Time
+}
+
+// fakeStore hands out its jobs once and records how they finished.
+type fakeStore struct {
+	mu       sync.Mutex
+	jobs     []*data.Job
+	finished chan outcome
+}
+
+func (s *fakeStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]*data.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := min(limit, len(s.jobs))
+	claimed := s.jobs[:n]
+	s.jobs = s.jobs[n:]
+	for _, job := range claimed {
+		job.Attempts++
+	}
+	return claimed, nil
+}
+
+func (s *fakeStore) Finish(ctx context.Context, job *data.Job, jobErr error, retryAt time.Time) error {
+	s.finished <- outcome{id: job.ID, err: jobErr, retryAt: retryAt}
+	return nil
+}
+
+func TestPool(t *testing.T) {
+	store := &fakeStore{
+		finished: make(chan outcome, 5),
+		jobs: []*data.Job{
+			{ID: 1, Kind: "greet", Payload: []byte(`{"name":"ada"}`), MaxAttempts: 3, RequestID: "req-1"},
+			{ID: 2, Kind: "fail", Payload: []byte(`{}`), MaxAttempts: 3},
+			{ID: 3, Kind: "fail", Payload: []byte(`{}`), MaxAttempts: 3, Attempts: 2},
+			{ID: 4, Kind: "unknown", Payload: []byte(`{}`), MaxAttempts: 1},
+			{ID: 5, Kind: "panic", Payload: []byte(`{}`), MaxAttempts: 1},
+		},
+	}
+
+	var greeted, requestID string
+	p := &Pool{Store: store, Workers: 2, PollInterval: 10 * time.Millisecond, Timeout: time.Second}
+	Handle(p, "greet", func(ctx context.Context, payload struct{ Name string }) error {
+		greeted = payload.Name
+		requestID = data.RequestID(ctx)
+		return nil
+	})
+	p.Register("fail", func(ctx context.Context, _ json.RawMessage) error { return errors.New("boom") })
+	p.Register("panic", func(ctx context.Context, _ json.RawMessage) error { panic("oops") })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	results := map[int64]outcome{}
+	for len(results) < 5 {
+		select {
+		case o := <-store.finished:
+			results[o.id] = o
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out; finished %v", results)
+		}
+	}
+	cancel()
+	<-done
+
+	if results[1].err != nil || greeted != "ada" || requestID != "req-1" {
+		t.Errorf("job 1: err = %v, greeted = %q, request ID = %q", results[1].err, greeted, requestID)
+	}
+	if o := results[2]; o.err == nil || o.retryAt.IsZero() {
+		t.Errorf("job 2: %+v, want a scheduled retry", o)
+	}
+	if o := results[3]; o.err == nil || !o.retryAt.IsZero() {
+		t.Errorf("job 3: %+v, want dead after its last attempt", o)
+	}
+	if o := results[4]; o.err == nil || !strings.Contains(o.err.Error(), "no handler") {
+		t.Errorf("job 4: %+v, want a missing handler error", o)
+	}
+	if o := results[5]; o.err == nil || !strings.Contains(o.err.Error(), "panicked") {
+		t.Errorf("job 5: %+v, want the panic reported as an error", o)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt, want := range map[int]time.Duration{1: 10 * time.Second, 3: 40 * time.Second, 12: time.Hour} {
+		if got := Backoff(attempt); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package jsonlog
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedTimeFormat stamps rotated files, as in api.log.20250102T150405.000.
+const rotatedTimeFormat = "20060102T150405.000"
+
+// FileSink writes log lines to a file that it rotates by size and by age.
+// A rotated file is renamed with the time of the rotation appended, then
+// compressed if Compress is set, and the oldest rotated files beyond
+// MaxBackups are deleted. Compression and deletion happen in the
+// background, so rotating never waits for them.
+type FileSink struct {
+	// Path is the file lines are written to.
+	Path string
+	// MaxSize rotates the file before it grows beyond this many bytes. Zero
+	// means no limit.
+	MaxSize int64
+	// MaxAge rotates the file once it has been written to for this long.
+	// Zero means no limit.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files kept. Zero keeps them all.
+	MaxBackups int
+	// Compress gzips rotated files.
+	Compress bool
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+
+	cleanupMu sync.Mutex
+	cleanups  sync.WaitGroup
+}
+
+// Open opens the file for appending, creating it and its directory if
+// needed. It must be called before the first Write.
+func (s *FileSink) Open() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.open()
+}
+
+func (s *FileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file, s.size, s.openedAt = f, info.Size(), time.Now()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p would take it past
+// MaxSize or it has reached MaxAge.
+func (s *FileSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return 0, errors.New("jsonlog: write to closed file sink")
+	}
+
+	tooBig := s.MaxSize > 0 && s.size > 0 && s.size+int64(len(p)) > s.MaxSize
+	tooOld := s.MaxAge > 0 && time.Since(s.openedAt) >= s.MaxAge
+	if tooBig || tooOld {
+		if err := s.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := s.file.Write(p)
+	s.size += int64(n)
+	return n, err
+}
+
+// Rotate starts a new file now, as on SIGHUP.
+func (s *FileSink) Rotate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return errors.New("jsonlog: rotate of closed file sink")
+	}
+	return s.rotate()
+}
+
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+
+	// The file is reopened even if the rename fails, so that logging goes
+	// on, into the old file if need be.
+	rotated := s.Path + "." + time.Now().UTC().Format(rotatedTimeFormat)
+	renameErr := os.Rename(s.Path, rotated)
+	if err := s.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		if errors.Is(renameErr, os.ErrNotExist) {
+			return nil
+		}
+		return renameErr
+	}
+
+	s.cleanups.Add(1)
+	go func() {
+		defer s.cleanups.Done()
+		s.cleanup(rotated)
+	}()
+	return nil
+}
+
+// cleanup compresses a newly rotated file and deletes old ones. Errors are
+// reported on stderr, as the log itself may be what is failing.
+func (s *FileSink) cleanup(rotated string) {
+	s.cleanupMu.Lock()
+	defer s.cleanupMu.Unlock()
+
+	if s.Compress {
+		if err := compressFile(rotated); err != nil {
+			fmt.Fprintf(os.Stderr, "jsonlog: compressing %s: %v\n", rotated, err)
+		}
+	}
+	if s.MaxBackups <= 0 {
+		return
+	}
+
+	backups, err := s.backups()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "jsonlog: listing rotated logs: %v\n", err)
+		return
+	}
+	for len(backups) > s.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "jsonlog: removing %s: %v\n", backups[0], err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// backups returns the rotated files, oldest first.
+func (s *FileSink) backups() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(s.Path))
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Base(s.Path) + "."
+	var names []string
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		stamp = strings.TrimSuffix(stamp, ".gz")
+		if _, err := time.Parse(rotatedTimeFormat, stamp); err != nil {
+			continue
+		}
+		names = append(names, filepath.Join(filepath.Dir(s.Path), entry.Name()))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func compressFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+// Sync commits the file to disk.
+func (s *FileSink) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Sync()
+}
+
+// Close closes the file and waits for background compression to finish.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	var err error
+	if s.file != nil {
+		err = s.file.Close()
+		s.file = nil
+	}
+	s.mu.Unlock()
+
+	s.cleanups.Wait()
+	return err
+}
@@ -1,10 +1,13 @@
 package jsonlog
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 )
@@ -12,7 +15,9 @@ import (
 type Level int8
 
 const (
-	LevelInfo Level = iota
+	LevelDebug Level = iota - 1
+	LevelInfo
+	LevelWarn
 	LevelError
 	LevelFatal
 	LevelOff
@@ -20,8 +25,12 @@ const (
 
 func (level Level) String() string {
 	switch level {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
@@ -33,30 +42,134 @@ func (level Level) String() string {
 	}
 }
 
+// ParseLevel returns the level with the given name, in any case.
+func ParseLevel(name string) (Level, error) {
+	for level := LevelDebug; level <= LevelOff; level++ {
+		if strings.EqualFold(name, level.String()) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("jsonlog: unknown level %q", name)
+}
+
+// Fields are the properties of a log line. Values keep their JSON type, so
+// numbers and booleans are logged as such. Errors, durations and other
+// fmt.Stringers are logged as strings.
+type Fields map[string]any
+
 type Logger struct {
-	out      io.Writer
-	minLevel Level
-	mu       sync.Mutex
+	out         io.Writer
+	minLevel    Level
+	stackLevel  Level
+	mu          sync.Mutex
+	contextFunc func(ctx context.Context) map[string]string
+
+	// root is the logger that a child logger made by With writes through,
+	// and fields are the fields bound to the child. root is nil for loggers
+	// made by NewLogger.
+	root   *Logger
+	fields Fields
 }
 
+// NewLogger returns a logger that writes lines at minLevel and above to out.
+// Error and fatal lines carry a stack trace until SetStackLevel says
+// otherwise.
 func NewLogger(out io.Writer, minLevel Level) *Logger {
 	return &Logger{
-		out:      out,
-		minLevel: minLevel,
+		out:        out,
+		minLevel:   minLevel,
+		stackLevel: LevelError,
+	}
+}
+
+func (logger *Logger) base() *Logger {
+	if logger.root != nil {
+		return logger.root
+	}
+	return logger
+}
+
+// With returns a child logger that adds fields to every line it writes. The
+// child shares the output, level and settings of logger.
+func (logger *Logger) With(fields Fields) *Logger {
+	bound := make(Fields, len(logger.fields)+len(fields))
+	for key, value := range logger.fields {
+		bound[key] = value
+	}
+	for key, value := range fields {
+		bound[key] = value
 	}
+	return &Logger{root: logger.base(), fields: bound}
 }
 
-func (logger *Logger) print(level Level, message string, properties map[string]string) (int, error) {
-	if level < logger.minLevel {
+// SetLevel changes the minimum level of logger and its children.
+func (logger *Logger) SetLevel(level Level) {
+	b := logger.base()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.minLevel = level
+}
+
+// Enabled reports whether lines at level are written.
+func (logger *Logger) Enabled(level Level) bool {
+	b := logger.base()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return level >= b.minLevel
+}
+
+// SetStackLevel makes lines at level and above carry a stack trace.
+// LevelOff turns stack traces off.
+func (logger *Logger) SetStackLevel(level Level) {
+	b := logger.base()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stackLevel = level
+}
+
+// SetContextFunc sets the function the Context methods use to find the
+// properties, such as a request ID, of the work a context belongs to.
+func (logger *Logger) SetContextFunc(fn func(ctx context.Context) map[string]string) {
+	logger.base().contextFunc = fn
+}
+
+// Log writes a line at level. The properties of ctx, which may be nil, come
+// first, then the fields bound with With, then fields, each overriding the
+// ones before.
+func (logger *Logger) Log(ctx context.Context, level Level, message string, fields Fields) {
+	logger.log(ctx, level, message, fields)
+}
+
+func (logger *Logger) log(ctx context.Context, level Level, message string, fields Fields) (int, error) {
+	b := logger.base()
+	b.mu.Lock()
+	minLevel, stackLevel := b.minLevel, b.stackLevel
+	b.mu.Unlock()
+	if level < minLevel {
 		return 0, nil
 	}
 
+	properties := make(map[string]any, len(logger.fields)+len(fields))
+	if ctx != nil && b.contextFunc != nil {
+		for key, value := range b.contextFunc(ctx) {
+			if value != "" {
+				properties[key] = value
+			}
+		}
+	}
+	for key, value := range logger.fields {
+		properties[key] = jsonValue(value)
+	}
+	for key, value := range fields {
+		properties[key] = jsonValue(value)
+	}
+
 	aux := struct {
-		Level      string            `json:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
-		Trace      string            `json:"trace,omitempty"`
+		Level      string         `json:"level"`
+		Time       string         `json:"time"`
+		Message    string         `json:"message"`
+		Properties map[string]any `json:"properties,omitempty"`
+		Trace      string         `json:"trace,omitempty"`
 	}{
 		Level:      level.String(),
 		Time:       time.Now().UTC().Format(time.RFC3339),
@@ -64,7 +177,7 @@ func (logger *Logger) print(level Level, message string, properties map[string]s
 		Properties: properties,
 	}
 
-	if level >= LevelError {
+	if level >= stackLevel {
 		aux.Trace = string(debug.Stack())
 	}
 
@@ -75,24 +188,79 @@ func (logger *Logger) print(level Level, message string, properties map[string]s
 		line = []byte(LevelError.String() + ": unable to marshal log message:" + err.Error())
 	}
 
-	logger.mu.Lock()
-	defer logger.mu.Unlock()
-	return logger.out.Write(append(line, '\n'))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.out.Write(append(line, '\n'))
+}
+
+// jsonValue returns v in the form it is logged in.
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case json.Marshaler:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return v
+	}
+}
+
+func stringFields(properties map[string]string) Fields {
+	if properties == nil {
+		return nil
+	}
+	fields := make(Fields, len(properties))
+	for key, value := range properties {
+		fields[key] = value
+	}
+	return fields
+}
+
+func (logger *Logger) Debug(message string, fields Fields) {
+	logger.log(nil, LevelDebug, message, fields)
+}
+
+func (logger *Logger) Info(message string, fields Fields) {
+	logger.log(nil, LevelInfo, message, fields)
+}
+
+func (logger *Logger) Warn(message string, fields Fields) {
+	logger.log(nil, LevelWarn, message, fields)
+}
+
+func (logger *Logger) Error(err error, fields Fields) {
+	logger.log(nil, LevelError, err.Error(), fields)
 }
 
 func (logger *Logger) Write(message []byte) (n int, err error) {
-	return logger.print(LevelError, string(message), nil)
+	return logger.log(nil, LevelError, string(message), nil)
 }
 
 func (logger *Logger) PrintInfo(message string, properties map[string]string) {
-	logger.print(LevelInfo, message, properties)
+	logger.log(nil, LevelInfo, message, stringFields(properties))
 }
 
 func (logger *Logger) PrintError(err error, properties map[string]string) {
-	logger.print(LevelError, err.Error(), properties)
+	logger.log(nil, LevelError, err.Error(), stringFields(properties))
 }
 
+// PrintInfoContext is PrintInfo with the properties of ctx added.
+func (logger *Logger) PrintInfoContext(ctx context.Context, message string, properties map[string]string) {
+	logger.log(ctx, LevelInfo, message, stringFields(properties))
+}
+
+// PrintErrorContext is PrintError with the properties of ctx added.
+func (logger *Logger) PrintErrorContext(ctx context.Context, err error, properties map[string]string) {
+	logger.log(ctx, LevelError, err.Error(), stringFields(properties))
+}
+
+// PrintFatal logs at FATAL level, flushes buffered output and exits.
 func (logger *Logger) PrintFatal(error error, properties map[string]string) {
-	logger.print(LevelFatal, error.Error(), properties)
+	logger.log(nil, LevelFatal, error.Error(), stringFields(properties))
+	if s, ok := logger.base().out.(syncer); ok {
+		s.Sync()
+	}
 	os.Exit(1)
 }
@@ -2,7 +2,13 @@ package jsonlog
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewLogger(t *testing.T) {
@@ -23,3 +29,96 @@ func TestNewLogger(t *testing.T) {
 		t.Errorf("expected minLevel %d, got %d", minLevel, logger.minLevel)
 	}
 }
+
+func TestPrintInfoContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, LevelInfo)
+
+	type key struct{}
+	logger.SetContextFunc(func(ctx context.Context) map[string]string {
+		id, _ := ctx.Value(key{}).(string)
+		return map[string]string{"request_id": id}
+	})
+
+	ctx := context.WithValue(context.Background(), key{}, "abc123")
+	logger.PrintInfoContext(ctx, "hello", map[string]string{"user": "ada"})
+	logger.PrintInfoContext(context.Background(), "no request", nil)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+
+	var line struct {
+		Properties map[string]string `json:"properties"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line.Properties["request_id"] != "abc123" || line.Properties["user"] != "ada" {
+		t.Errorf("properties = %v", line.Properties)
+	}
+
+	line.Properties = nil
+	if err := json.Unmarshal([]byte(lines[1]), &line); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := line.Properties["request_id"]; ok {
+		t.Errorf("empty request ID was logged: %v", line.Properties)
+	}
+}
+
+func TestLevelsAndFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, LevelWarn)
+	logger.SetStackLevel(LevelOff)
+
+	child := logger.With(Fields{"component": "mailer", "attempt": 1})
+	child.Info("dropped", nil)
+	child.Warn("slow", Fields{"attempt": 2, "delay": 1500 * time.Millisecond, "retry": true})
+	child.Error(errors.New("boom"), nil)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %q", len(lines), buf.String())
+	}
+
+	var line struct {
+		Level      string         `json:"level"`
+		Properties map[string]any `json:"properties"`
+		Trace      string         `json:"trace"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"component": "mailer", "attempt": float64(2), "delay": "1.5s", "retry": true}
+	if line.Level != "WARN" || !reflect.DeepEqual(line.Properties, want) {
+		t.Errorf("got %s %v; want WARN %v", line.Level, line.Properties, want)
+	}
+
+	line.Trace = ""
+	if err := json.Unmarshal([]byte(lines[1]), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line.Level != "ERROR" || line.Trace != "" {
+		t.Errorf("got %s with trace %t; want ERROR without a trace", line.Level, line.Trace != "")
+	}
+
+	// Children follow level changes made on the parent.
+	logger.SetLevel(LevelDebug)
+	if !child.Enabled(LevelDebug) {
+		t.Error("child did not follow the parent's level")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, level := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError, LevelFatal, LevelOff} {
+		got, err := ParseLevel(strings.ToLower(level.String()))
+		if err != nil || got != level {
+			t.Errorf("ParseLevel(%q) = %v, %v", level, got, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel accepted an unknown level")
+	}
+}
@@ -0,0 +1,180 @@
+package jsonlog
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// syncer is implemented by outputs that can flush what they have buffered,
+// such as *os.File, FileSink and AsyncWriter.
+type syncer interface {
+	Sync() error
+}
+
+// MultiWriter returns a writer that writes every line to all of writers. A
+// failing writer does not stop the others; the first error is returned.
+func MultiWriter(writers ...io.Writer) io.Writer {
+	return multiWriter(writers)
+}
+
+type multiWriter []io.Writer
+
+func (mw multiWriter) Write(p []byte) (int, error) {
+	var first error
+	for _, w := range mw {
+		if _, err := w.Write(p); err != nil && first == nil {
+			first = err
+		}
+	}
+	return len(p), first
+}
+
+func (mw multiWriter) Sync() error {
+	var first error
+	for _, w := range mw {
+		if s, ok := w.(syncer); ok {
+			if err := s.Sync(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// AsyncWriter hands lines to a goroutine that writes them to its output, so
+// that a slow disk or a blocked pipe never holds up the code that logs. When
+// its buffer is full, lines are dropped rather than waited for, and a WARN
+// line saying how many were lost is written once the output catches up.
+type AsyncWriter struct {
+	out     io.Writer
+	queue   chan asyncItem
+	done    chan struct{}
+	dropped atomic.Int64
+	total   atomic.Int64
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+type asyncItem struct {
+	line []byte
+	// synced, if set, is closed once every line queued before it is written.
+	synced chan struct{}
+}
+
+// NewAsyncWriter returns an AsyncWriter that buffers up to buffer lines on
+// their way to out.
+func NewAsyncWriter(out io.Writer, buffer int) *AsyncWriter {
+	w := &AsyncWriter{
+		out:   out,
+		queue: make(chan asyncItem, max(buffer, 1)),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Write queues a copy of p. It never blocks and never fails; a line that
+// does not fit in the buffer is counted as dropped.
+func (w *AsyncWriter) Write(p []byte) (int, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		w.drop()
+		return len(p), nil
+	}
+
+	select {
+	case w.queue <- asyncItem{line: append([]byte(nil), p...)}:
+	default:
+		w.drop()
+	}
+	return len(p), nil
+}
+
+func (w *AsyncWriter) drop() {
+	w.dropped.Add(1)
+	w.total.Add(1)
+}
+
+// Dropped returns the number of lines dropped so far.
+func (w *AsyncWriter) Dropped() int64 {
+	return w.total.Load()
+}
+
+// Sync waits up to five seconds for the lines queued so far to be written,
+// then syncs the output.
+func (w *AsyncWriter) Sync() error {
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		return nil
+	}
+	synced := make(chan struct{})
+	select {
+	case w.queue <- asyncItem{synced: synced}:
+	case <-time.After(5 * time.Second):
+		w.mu.RUnlock()
+		return nil
+	}
+	w.mu.RUnlock()
+
+	select {
+	case <-synced:
+	case <-time.After(5 * time.Second):
+		return nil
+	}
+	if s, ok := w.out.(syncer); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+// Close writes the lines still queued and stops the writer. Lines written
+// after Close are dropped. It does not close the output.
+func (w *AsyncWriter) Close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	<-w.done
+	if s, ok := w.out.(syncer); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+func (w *AsyncWriter) run() {
+	defer close(w.done)
+	for item := range w.queue {
+		if n := w.dropped.Swap(0); n > 0 {
+			w.out.Write(droppedLine(n))
+		}
+		if item.synced != nil {
+			close(item.synced)
+			continue
+		}
+		w.out.Write(item.line)
+	}
+}
+
+func droppedLine(n int64) []byte {
+	line, _ := json.Marshal(struct {
+		Level      string         `json:"level"`
+		Time       string         `json:"time"`
+		Message    string         `json:"message"`
+		Properties map[string]any `json:"properties"`
+	}{
+		Level:      LevelWarn.String(),
+		Time:       time.Now().UTC().Format(time.RFC3339),
+		Message:    "log output was too slow, lines were dropped",
+		Properties: map[string]any{"dropped": n},
+	})
+	return append(line, '\n')
+}
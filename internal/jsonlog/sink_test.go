@@ -0,0 +1,146 @@
+package jsonlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMultiWriter(t *testing.T) {
+	var a, b bytes.Buffer
+	w := MultiWriter(&a, failingWriter{}, &b)
+
+	_, err := w.Write([]byte("line\n"))
+	if err == nil {
+		t.Error("the failing writer's error was not returned")
+	}
+	if a.String() != "line\n" || b.String() != "line\n" {
+		t.Errorf("got %q and %q; want the line in both", a.String(), b.String())
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+
+// blockingWriter holds every write until release is closed.
+type blockingWriter struct {
+	release chan struct{}
+	mu      sync.Mutex
+	buf     bytes.Buffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func TestAsyncWriter(t *testing.T) {
+	out := &blockingWriter{release: make(chan struct{})}
+	w := NewAsyncWriter(out, 2)
+
+	// With the output stuck, writes return at once and the excess is dropped.
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			w.Write([]byte("line\n"))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Write blocked on a slow output")
+	}
+
+	close(out.release)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := out.buf.String()
+	if w.Dropped() == 0 || !strings.Contains(got, `"dropped":`) {
+		t.Errorf("dropped = %d, output %q; want drops reported", w.Dropped(), got)
+	}
+	if n := strings.Count(got, "line\n"); int64(n)+w.Dropped() != 10 {
+		t.Errorf("%d lines written and %d dropped; want 10 in all", n, w.Dropped())
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	dir := t.TempDir()
+	s := &FileSink{Path: filepath.Join(dir, "api.log"), MaxSize: 10, MaxBackups: 2, Compress: true}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := s.Write([]byte("12345678\n")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond) // keep rotation stamps distinct
+	}
+	if err := s.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := s.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("got backups %v; want the newest 2", backups)
+	}
+	for _, name := range backups {
+		if !strings.HasSuffix(name, ".gz") {
+			t.Errorf("%s was not compressed", name)
+			continue
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(gz)
+		f.Close()
+		if string(content) != "12345678\n" {
+			t.Errorf("%s holds %q", name, content)
+		}
+	}
+
+	if info, err := os.Stat(s.Path); err != nil || info.Size() != 0 {
+		t.Errorf("current file after Rotate: %v, %v; want it empty", info, err)
+	}
+}
+
+func TestFileSinkMaxAge(t *testing.T) {
+	s := &FileSink{Path: filepath.Join(t.TempDir(), "api.log"), MaxAge: time.Millisecond}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.Write([]byte("old\n"))
+	time.Sleep(5 * time.Millisecond)
+	s.Write([]byte("new\n"))
+
+	content, err := os.ReadFile(s.Path)
+	if err != nil || string(content) != "new\n" {
+		t.Errorf("current file holds %q (%v); want only the line written after MaxAge", content, err)
+	}
+}
@@ -0,0 +1,78 @@
+package jsonlog
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Handler returns a slog.Handler that writes through logger, so that code
+// using log/slog, and a *log.Logger made with slog.NewLogLogger, produce the
+// same JSON lines. Attributes in groups are logged with dotted keys.
+func (logger *Logger) Handler() slog.Handler {
+	return &handler{logger: logger}
+}
+
+type handler struct {
+	logger *Logger
+	prefix string
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.Enabled(fromSlogLevel(level))
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	fields := make(Fields, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(fields, h.prefix, a)
+		return true
+	})
+	_, err := h.logger.log(ctx, fromSlogLevel(r.Level), r.Message, fields)
+	return err
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make(Fields, len(attrs))
+	for _, a := range attrs {
+		addAttr(fields, h.prefix, a)
+	}
+	return &handler{logger: h.logger.With(fields), prefix: h.prefix}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &handler{logger: h.logger, prefix: h.prefix + name + "."}
+}
+
+func addAttr(fields Fields, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			addAttr(fields, prefix, ga)
+		}
+		return
+	}
+	fields[prefix+a.Key] = a.Value.Any()
+}
+
+// fromSlogLevel maps slog's levels, and those in between, onto ours.
+func fromSlogLevel(level slog.Level) Level {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
@@ -0,0 +1,38 @@
+package jsonlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, LevelInfo)
+	logger.SetStackLevel(LevelOff)
+
+	sl := slog.New(logger.Handler()).With("service", "api").WithGroup("http")
+	sl.Debug("not written")
+	sl.Warn("slow request", "status", 200, slog.Group("client", "ip", "10.0.0.1"))
+
+	var line struct {
+		Level      string         `json:"level"`
+		Message    string         `json:"message"`
+		Properties map[string]any `json:"properties"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("%v in %q", err, buf.String())
+	}
+	want := map[string]any{"service": "api", "http.status": float64(200), "http.client.ip": "10.0.0.1"}
+	if line.Level != "WARN" || line.Message != "slow request" || !reflect.DeepEqual(line.Properties, want) {
+		t.Errorf("got %s %q %v; want WARN %v", line.Level, line.Message, line.Properties, want)
+	}
+
+	buf.Reset()
+	slog.NewLogLogger(logger.Handler(), slog.LevelError).Print("http: TLS handshake error")
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil || line.Level != "ERROR" {
+		t.Errorf("log.Logger line = %q (%v)", buf.String(), err)
+	}
+}
@@ -2,11 +2,15 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	"html/template"
+	"net"
+	"strconv"
 	"time"
 
 	"github.com/go-mail/mail/v2"
+	"greenlight.samedarslan28.net/internal/trace"
 )
 
 //go:embed "templates"
@@ -26,7 +30,17 @@ func New(host string, port int, username, password, sender string) Mailer {
 	}
 }
 
-func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
+// Send renders templateFile with data and mails it to recipient. The
+// context only carries the trace the send belongs to; the SMTP exchange is
+// bounded by the dialer timeout.
+func (m Mailer) Send(ctx context.Context, recipient, templateFile string, data interface{}) (err error) {
+	_, span := trace.Start(ctx, "smtp send", trace.KindClient)
+	span.SetAttribute("mail.template", templateFile)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
 	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
 	if err != nil {
 		return err
@@ -66,3 +80,14 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
 
 	return nil
 }
+
+// Ping checks that the SMTP server accepts connections, without logging in
+// or sending anything.
+func (m Mailer) Ping(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(m.dialer.Host, strconv.Itoa(m.dialer.Port)))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
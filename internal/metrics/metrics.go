@@ -0,0 +1,340 @@
+// Package metrics keeps counters, gauges and histograms and serves them in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are histogram upper bounds, in seconds, suited to the
+// duration of HTTP requests.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metric families and writes them out in the order they were
+// registered.
+type Registry struct {
+	mu       sync.Mutex
+	names    map[string]bool
+	families []family
+}
+
+type family interface {
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.families = append(r.families, f)
+}
+
+// WriteTo writes every metric in the Prometheus text format.
+func (r *Registry) WriteTo(out io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: out}
+	w := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(w)
+	}
+	err := w.Flush()
+	return cw.n, err
+}
+
+// Handler returns a handler that serves the metrics to a Prometheus scraper.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	n atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.n.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.n.Add(n)
+}
+
+// Value returns the current count.
+func (c *Counter) Value() uint64 {
+	return c.n.Load()
+}
+
+// NewCounter registers a counter without labels.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).WithLabelValues()
+}
+
+// CounterVec is a family of counters told apart by their label values.
+type CounterVec struct {
+	header
+	children vec[*Counter]
+}
+
+// NewCounterVec registers a family of counters with the given label names.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	cv := &CounterVec{header: header{name, help, "counter", labels}}
+	r.register(name, cv)
+	return cv
+}
+
+// WithLabelValues returns the counter for values, given in the order of the
+// label names, creating it on first use.
+func (cv *CounterVec) WithLabelValues(values ...string) *Counter {
+	cv.checkValues(values)
+	return cv.children.get(values, func() *Counter { return new(Counter) })
+}
+
+func (cv *CounterVec) write(w *bufio.Writer) {
+	cv.writeHeader(w)
+	cv.children.each(func(values []string, c *Counter) {
+		writeSample(w, cv.name, cv.labels, values, "", "", float64(c.Value()))
+	})
+}
+
+// Histogram counts observations in buckets by their upper bound.
+type Histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// Observe records v.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < len(h.buckets) {
+		h.buckets[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+// HistogramVec is a family of histograms told apart by their label values.
+type HistogramVec struct {
+	header
+	bounds   []float64
+	children vec[*Histogram]
+}
+
+// NewHistogramVec registers a family of histograms with the given bucket
+// upper bounds, in increasing order, and label names.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: histogram buckets of " + name + " are not in increasing order")
+	}
+	hv := &HistogramVec{header: header{name, help, "histogram", labels}, bounds: buckets}
+	r.register(name, hv)
+	return hv
+}
+
+// WithLabelValues returns the histogram for values, given in the order of
+// the label names, creating it on first use.
+func (hv *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	hv.checkValues(values)
+	return hv.children.get(values, func() *Histogram {
+		return &Histogram{bounds: hv.bounds, buckets: make([]uint64, len(hv.bounds))}
+	})
+}
+
+func (hv *HistogramVec) write(w *bufio.Writer) {
+	hv.writeHeader(w)
+	hv.children.each(func(values []string, h *Histogram) {
+		h.mu.Lock()
+		buckets := append([]uint64(nil), h.buckets...)
+		count, sum := h.count, h.sum
+		h.mu.Unlock()
+
+		var cumulative uint64
+		for i, bound := range hv.bounds {
+			cumulative += buckets[i]
+			writeSample(w, hv.name+"_bucket", hv.labels, values, "le", formatFloat(bound), float64(cumulative))
+		}
+		writeSample(w, hv.name+"_bucket", hv.labels, values, "le", "+Inf", float64(count))
+		writeSample(w, hv.name+"_sum", hv.labels, values, "", "", sum)
+		writeSample(w, hv.name+"_count", hv.labels, values, "", "", float64(count))
+	})
+}
+
+// CollectFunc reports the current values of a metric read from elsewhere,
+// such as a connection pool, by calling emit once for each set of label
+// values.
+type CollectFunc func(emit func(value float64, labelValues ...string))
+
+type funcFamily struct {
+	header
+	collect CollectFunc
+}
+
+// NewGaugeFunc registers a gauge whose values are read by collect at every
+// scrape.
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, collect CollectFunc) {
+	r.register(name, &funcFamily{header{name, help, "gauge", labels}, collect})
+}
+
+// NewCounterFunc registers a counter whose values are read by collect at
+// every scrape.
+func (r *Registry) NewCounterFunc(name, help string, labels []string, collect CollectFunc) {
+	r.register(name, &funcFamily{header{name, help, "counter", labels}, collect})
+}
+
+func (f *funcFamily) write(w *bufio.Writer) {
+	f.writeHeader(w)
+	f.collect(func(value float64, values ...string) {
+		f.checkValues(values)
+		writeSample(w, f.name, f.labels, values, "", "", value)
+	})
+}
+
+type header struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (h header) checkValues(values []string) {
+	if len(values) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", h.name, len(h.labels), len(values)))
+	}
+}
+
+func (h header) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", h.name, helpEscaper.Replace(h.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", h.name, h.kind)
+}
+
+// vec holds the members of a family keyed by their label values.
+type vec[T any] struct {
+	mu       sync.RWMutex
+	children map[string]child[T]
+}
+
+type child[T any] struct {
+	values []string
+	metric T
+}
+
+func (v *vec[T]) get(values []string, create func() T) T {
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	c, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return c.metric
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok := v.children[key]; ok {
+		return c.metric
+	}
+	if v.children == nil {
+		v.children = make(map[string]child[T])
+	}
+	c = child[T]{values: append([]string(nil), values...), metric: create()}
+	v.children[key] = c
+	return c.metric
+}
+
+// each calls fn for every member, ordered by label values.
+func (v *vec[T]) each(fn func(values []string, metric T)) {
+	v.mu.RLock()
+	children := make([]child[T], 0, len(v.children))
+	for _, c := range v.children {
+		children = append(children, c)
+	}
+	v.mu.RUnlock()
+
+	sort.Slice(children, func(i, j int) bool {
+		return slices.Compare(children[i].values, children[j].values) < 0
+	})
+
+	for _, c := range children {
+		fn(c.values, c.metric)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// writeSample writes one sample line. extraName and extraValue, if set, add
+// a label after the family's own, as le does for histogram buckets.
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, label, labelEscaper.Replace(values[i]))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests served.", "route", "status")
+	duration := r.NewHistogramVec("request_duration_seconds", "Request duration.", []float64{.1, 1}, "route")
+	rejected := r.NewCounter("rejected_total", "Rejected\nrequests.")
+	r.NewGaugeFunc("pool_open", "Open connections.", []string{"db"}, func(emit func(float64, ...string)) {
+		emit(3, "primary")
+		emit(1, `replica "a"`)
+	})
+
+	requests.WithLabelValues("/v1/movies", "200").Inc()
+	requests.WithLabelValues("/v1/movies", "200").Inc()
+	requests.WithLabelValues("/v1/movies/:id", "404").Add(3)
+	duration.WithLabelValues("/v1/movies").Observe(.05)
+	duration.WithLabelValues("/v1/movies").Observe(.1)
+	duration.WithLabelValues("/v1/movies").Observe(2)
+	rejected.Inc()
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/v1/movies",status="200"} 2
+requests_total{route="/v1/movies/:id",status="404"} 3
+# HELP request_duration_seconds Request duration.
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{route="/v1/movies",le="0.1"} 2
+request_duration_seconds_bucket{route="/v1/movies",le="1"} 2
+request_duration_seconds_bucket{route="/v1/movies",le="+Inf"} 3
+request_duration_seconds_sum{route="/v1/movies"} 2.15
+request_duration_seconds_count{route="/v1/movies"} 3
+# HELP rejected_total Rejected\nrequests.
+# TYPE rejected_total counter
+rejected_total 1
+# HELP pool_open Open connections.
+# TYPE pool_open gauge
+pool_open{db="primary"} 3
+pool_open{db="replica \"a\""} 1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistryHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("up_total", "Scrapes.").Inc()
+
+	rr := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	if got := rr.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if !strings.Contains(rr.Body.String(), "up_total 1\n") {
+		t.Errorf("body = %q", rr.Body.String())
+	}
+}
+
+func TestRegistryDuplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("a_total", "A.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a_total twice did not panic")
+		}
+	}()
+	r.NewCounter("a_total", "A again.")
+}
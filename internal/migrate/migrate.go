@@ -0,0 +1,334 @@
+// Package migrate applies the SQL migrations embedded in the binary.
+//
+// It keeps its state in the same schema_migrations table as the migrate CLI,
+// so databases that were migrated with the CLI can be taken over as they are.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// lockID is the Postgres advisory lock key held while migrations run, so that
+// several instances starting at once do not race each other.
+const lockID = 7_293_114_805_226_011
+
+// undefinedTable is the Postgres error code for a missing table.
+const undefinedTable = "42P01"
+
+var (
+	ErrDirty       = errors.New("database is in a dirty migration state")
+	ErrNoMigration = errors.New("no such migration version")
+)
+
+var filenameRX = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is a single numbered schema change.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status describes the state of the database schema.
+type Status struct {
+	Current    int64
+	Dirty      bool
+	Latest     int64
+	Migrations []Migration
+}
+
+// Pending reports whether there are migrations newer than the current
+// version.
+func (s Status) Pending() bool {
+	return s.Current < s.Latest
+}
+
+// Migrator applies migrations read from a file system to a database.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New reads every migration in fsys and returns a Migrator for db. Each
+// version must have both an up and a down file.
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := filenameRX.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+
+		contents, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has files with different names: %q and %q", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Latest returns the highest migration version known to the binary.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status reports the current and latest schema versions.
+func (m *Migrator) Status(ctx context.Context) (Status, error) {
+	if err := ensureTable(ctx, m.db); err != nil {
+		return Status{}, err
+	}
+
+	current, dirty, err := version(ctx, m.db)
+	if err != nil {
+		return Status{}, err
+	}
+
+	return Status{
+		Current:    current,
+		Dirty:      dirty,
+		Latest:     m.Latest(),
+		Migrations: m.migrations,
+	}, nil
+}
+
+// Inspect is like Status but never writes to the database, which makes it
+// safe for health checks. A database without the schema_migrations table is
+// reported as not migrated.
+func (m *Migrator) Inspect(ctx context.Context) (Status, error) {
+	current, dirty, err := version(ctx, m.db)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == undefinedTable {
+		current, dirty, err = 0, false, nil
+	}
+	if err != nil {
+		return Status{}, err
+	}
+
+	return Status{
+		Current:    current,
+		Dirty:      dirty,
+		Latest:     m.Latest(),
+		Migrations: m.migrations,
+	}, nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.Goto(ctx, m.Latest())
+}
+
+// Down reverts the most recently applied migration.
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn, current int64) error {
+		if current == 0 {
+			return nil
+		}
+		i := m.index(current)
+		if i < 0 {
+			return fmt.Errorf("%w: database is at version %d", ErrNoMigration, current)
+		}
+		var previous int64
+		if i > 0 {
+			previous = m.migrations[i-1].Version
+		}
+		return apply(ctx, conn, m.migrations[i].Down, previous)
+	})
+}
+
+// Goto migrates up or down until the schema is at target. A target of zero
+// reverts every migration.
+func (m *Migrator) Goto(ctx context.Context, target int64) error {
+	if target != 0 && m.index(target) < 0 {
+		return fmt.Errorf("%w: %d", ErrNoMigration, target)
+	}
+
+	return m.withLock(ctx, func(conn *sql.Conn, current int64) error {
+		for _, step := range m.plan(current, target) {
+			if err := apply(ctx, conn, step.sql, step.version); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+type step struct {
+	sql     string
+	version int64 // schema version once the step has run
+}
+
+// plan lists the steps that take the schema from current to target.
+func (m *Migrator) plan(current, target int64) []step {
+	var steps []step
+	if target >= current {
+		for _, mig := range m.migrations {
+			if mig.Version > current && mig.Version <= target {
+				steps = append(steps, step{sql: mig.Up, version: mig.Version})
+			}
+		}
+		return steps
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if mig.Version > current || mig.Version <= target {
+			continue
+		}
+		var previous int64
+		if i > 0 {
+			previous = m.migrations[i-1].Version
+		}
+		steps = append(steps, step{sql: mig.Down, version: previous})
+	}
+	return steps
+}
+
+func (m *Migrator) index(version int64) int {
+	for i, mig := range m.migrations {
+		if mig.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// withLock runs fn on a single connection holding the migration advisory
+// lock, passing it the current schema version.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn, current int64) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, lockID)
+	}()
+
+	if err := ensureTable(ctx, conn); err != nil {
+		return err
+	}
+
+	current, dirty, err := version(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("%w at version %d, fix the schema by hand before migrating", ErrDirty, current)
+	}
+
+	return fn(conn, current)
+}
+
+type execQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func ensureTable(ctx context.Context, db execQueryer) error {
+	_, err := db.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version bigint NOT NULL PRIMARY KEY,
+    dirty boolean NOT NULL
+)`)
+	return err
+}
+
+func version(ctx context.Context, db execQueryer) (int64, bool, error) {
+	var current int64
+	var dirty bool
+	err := db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&current, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return current, dirty, err
+}
+
+// apply runs one migration and records the resulting version in the same
+// transaction, so a failed migration leaves the schema untouched.
+func apply(ctx context.Context, conn *sql.Conn, statements string, newVersion int64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.ExecContext(ctx, statements); err != nil {
+		return fmt.Errorf("migrating to version %d: %w", newVersion, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations`); err != nil {
+		return err
+	}
+	if newVersion > 0 {
+		_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, newVersion)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
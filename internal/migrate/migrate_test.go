@@ -0,0 +1,76 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"greenlight.samedarslan28.net/migrations"
+)
+
+func TestLoadEmbeddedMigrations(t *testing.T) {
+	m, err := New(nil, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Latest() < 1 {
+		t.Fatalf("Latest() = %d, want at least 1", m.Latest())
+	}
+	for i, mig := range m.migrations {
+		if i > 0 && mig.Version <= m.migrations[i-1].Version {
+			t.Errorf("migrations not sorted at %d", mig.Version)
+		}
+	}
+}
+
+func TestLoadRejectsMissingDown(t *testing.T) {
+	fsys := fstest.MapFS{
+		"000001_a.up.sql": {Data: []byte("CREATE TABLE a ()")},
+	}
+	if _, err := New(nil, fsys); err == nil {
+		t.Fatal("expected an error for a migration without a down file")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	fsys := fstest.MapFS{
+		"000001_a.up.sql":   {Data: []byte("up 1")},
+		"000001_a.down.sql": {Data: []byte("down 1")},
+		"000002_b.up.sql":   {Data: []byte("up 2")},
+		"000002_b.down.sql": {Data: []byte("down 2")},
+		"000005_c.up.sql":   {Data: []byte("up 5")},
+		"000005_c.down.sql": {Data: []byte("down 5")},
+		"README.md":         {Data: []byte("ignored")},
+	}
+	m, err := New(nil, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		current, target int64
+		want            []step
+	}{
+		{name: "all up", current: 0, target: 5, want: []step{{"up 1", 1}, {"up 2", 2}, {"up 5", 5}}},
+		{name: "partial up", current: 1, target: 2, want: []step{{"up 2", 2}}},
+		{name: "down one", current: 5, target: 2, want: []step{{"down 5", 2}}},
+		{name: "all down", current: 5, target: 0, want: []step{{"down 5", 2}, {"down 2", 1}, {"down 1", 0}}},
+		{name: "no-op", current: 2, target: 2, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := m.plan(tt.current, tt.target)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("plan(%d, %d) = %v, want %v", tt.current, tt.target, got, tt.want)
+			}
+		})
+	}
+
+	if err := m.Goto(context.Background(), 3); !errors.Is(err, ErrNoMigration) {
+		t.Errorf("Goto(3) error = %v, want ErrNoMigration", err)
+	}
+}
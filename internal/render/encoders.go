@@ -0,0 +1,258 @@
+package render
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// JSON writes indented JSON.
+var JSON = Encoder{
+	ContentType: "application/json",
+	MediaTypes:  []string{"application/json"},
+	Encode: func(w io.Writer, v interface{}) error {
+		js, err := json.MarshalIndent(v, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(js, '\n'))
+		return err
+	},
+}
+
+// ProblemJSON writes RFC 9457 problem details. It is not registered for
+// negotiation with the other encoders, as only error responses use it.
+var ProblemJSON = Encoder{
+	ContentType: "application/problem+json",
+	MediaTypes:  []string{"application/problem+json"},
+	Encode: func(w io.Writer, v interface{}) error {
+		return JSON.Encode(w, v)
+	},
+}
+
+// XML writes the JSON form of a value as elements under a <response> root.
+// Object keys become elements and list items become <item> elements.
+var XML = Encoder{
+	ContentType: "application/xml; charset=utf-8",
+	MediaTypes:  []string{"application/xml", "text/xml"},
+	Encode: func(w io.Writer, v interface{}) error {
+		g, err := generic(v)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "\t")
+		if err := encodeXML(enc, "response", g); err != nil {
+			return err
+		}
+		if err := enc.Flush(); err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, "\n")
+		return err
+	},
+}
+
+func encodeXML(enc *xml.Encoder, name string, v interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: xmlName(name)}}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case *object:
+		for _, key := range v.keys {
+			if err := encodeXML(enc, key, v.values[key]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := encodeXML(enc, "item", item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		if err := enc.EncodeToken(xml.CharData(scalar(v))); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// xmlName turns a JSON key into a valid XML element name.
+func xmlName(key string) string {
+	name := []rune(key)
+	for i, r := range name {
+		switch {
+		case r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z':
+		case i > 0 && (r == '-' || r == '.' || r >= '0' && r <= '9'):
+		default:
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 {
+		return "_"
+	}
+	return string(name)
+}
+
+// CSV writes records as comma-separated values with a header row. When the
+// value is an object holding exactly one list, such as a page of movies,
+// the list items are the records and the rest of the object, such as the
+// pagination metadata, is left out. Any other value is written as a single
+// record. Nested objects are flattened into dotted column names and lists
+// of plain values are joined with semicolons.
+var CSV = Encoder{
+	ContentType: "text/csv; charset=utf-8",
+	MediaTypes:  []string{"text/csv"},
+	Tabular:     true,
+	Encode: func(w io.Writer, v interface{}) error {
+		g, err := generic(v)
+		if err != nil {
+			return err
+		}
+
+		var columns []string
+		seen := map[string]bool{}
+		var records []map[string]string
+		for _, item := range csvRecords(g) {
+			record := map[string]string{}
+			flatten("", item, record, func(column string) {
+				if !seen[column] {
+					seen[column] = true
+					columns = append(columns, column)
+				}
+			})
+			records = append(records, record)
+		}
+		if len(columns) == 0 {
+			return nil
+		}
+
+		cw := csv.NewWriter(w)
+		if err := cw.Write(columns); err != nil {
+			return err
+		}
+		row := make([]string, len(columns))
+		for _, record := range records {
+			for i, column := range columns {
+				row[i] = record[column]
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	},
+}
+
+func csvRecords(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		return v
+	case *object:
+		var list []interface{}
+		lists := 0
+		for _, key := range v.keys {
+			if l, ok := v.values[key].([]interface{}); ok {
+				list = l
+				lists++
+			}
+		}
+		if lists == 1 {
+			return list
+		}
+	}
+	return []interface{}{v}
+}
+
+func flatten(prefix string, v interface{}, record map[string]string, column func(string)) {
+	switch v := v.(type) {
+	case *object:
+		for _, key := range v.keys {
+			name := key
+			if prefix != "" {
+				name = prefix + "." + key
+			}
+			flatten(name, v.values[key], record, column)
+		}
+		return
+	}
+
+	if prefix == "" {
+		prefix = "value"
+	}
+	column(prefix)
+	switch v := v.(type) {
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			if _, ok := item.(*object); ok {
+				js, _ := json.Marshal(item)
+				parts[i] = string(js)
+				continue
+			}
+			parts[i] = scalar(item)
+		}
+		record[prefix] = strings.Join(parts, ";")
+	default:
+		record[prefix] = scalar(v)
+	}
+}
+
+func scalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return ""
+	}
+}
+
+// MessagePack writes the JSON form of a value as MessagePack.
+var MessagePack = Encoder{
+	ContentType: "application/msgpack",
+	MediaTypes:  []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"},
+	Encode: func(w io.Writer, v interface{}) error {
+		g, err := generic(v)
+		if err != nil {
+			return err
+		}
+		return msgpack.NewEncoder(w).Encode(g)
+	},
+}
+
+// EncodeMsgpack writes the object as a map with its keys in order.
+func (o *object) EncodeMsgpack(enc *msgpack.Encoder) error {
+	if err := enc.EncodeMapLen(len(o.keys)); err != nil {
+		return err
+	}
+	for _, key := range o.keys {
+		if err := enc.EncodeString(key); err != nil {
+			return err
+		}
+		if err := enc.Encode(o.values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
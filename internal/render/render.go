@@ -0,0 +1,213 @@
+// Package render encodes API responses in the format a client asks for in
+// its Accept header.
+//
+// Every encoder works from the JSON form of a value, so struct tags and
+// custom MarshalJSON methods shape the other formats too, and a field looks
+// the same whichever format it is sent in.
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNotAcceptable is returned by Negotiate when no registered encoder
+// produces a media type the client accepts.
+var ErrNotAcceptable = errors.New("render: no acceptable media type")
+
+// Encoder writes values in one format.
+type Encoder struct {
+	// ContentType is sent in the Content-Type header.
+	ContentType string
+	// MediaTypes are the media types, without parameters, that select the
+	// encoder in an Accept header.
+	MediaTypes []string
+	// Tabular is set for formats that only suit lists of records.
+	Tabular bool
+	// Encode writes v to w.
+	Encode func(w io.Writer, v interface{}) error
+}
+
+// Registry picks encoders by Accept header.
+type Registry struct {
+	encoders []Encoder
+}
+
+// NewRegistry returns a registry of the given encoders. The first is the
+// default, used when the client accepts anything.
+func NewRegistry(encoders ...Encoder) *Registry {
+	return &Registry{encoders: encoders}
+}
+
+// Default returns the first registered encoder.
+func (r *Registry) Default() Encoder {
+	return r.encoders[0]
+}
+
+// MediaTypes returns the primary media type of every registered encoder.
+func (r *Registry) MediaTypes() []string {
+	types := make([]string, len(r.encoders))
+	for i, enc := range r.encoders {
+		types[i] = enc.MediaTypes[0]
+	}
+	return types
+}
+
+// Negotiate returns the encoder that best matches accept, following the
+// quality values and wildcards of RFC 9110. An empty header accepts the
+// default encoder.
+func (r *Registry) Negotiate(accept string) (Encoder, error) {
+	if strings.TrimSpace(accept) == "" {
+		return r.Default(), nil
+	}
+
+	for _, mr := range parseAccept(accept) {
+		for _, enc := range r.encoders {
+			for _, mt := range enc.MediaTypes {
+				if mr.matches(mt) {
+					return enc, nil
+				}
+			}
+		}
+	}
+	return Encoder{}, ErrNotAcceptable
+}
+
+// Accepts reports whether accept names mediaType itself with a non-zero
+// quality. Wildcards don't count, so that formats a client must opt in to,
+// such as problem details, are not sent to clients that accept anything.
+func Accepts(accept, mediaType string) bool {
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+	for _, mr := range parseAccept(accept) {
+		if mr.typ == typ && mr.subtype == subtype {
+			return true
+		}
+	}
+	return false
+}
+
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// specificity ranks exact media types above type/* above */*.
+func (m mediaRange) specificity() int {
+	switch {
+	case m.typ == "*":
+		return 0
+	case m.subtype == "*":
+		return 1
+	default:
+		return 2
+	}
+}
+
+func (m mediaRange) matches(mediaType string) bool {
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+	return (m.typ == "*" || m.typ == typ) && (m.subtype == "*" || m.subtype == subtype)
+}
+
+// parseAccept returns the acceptable media ranges in an Accept header, most
+// preferred first. Ranges with q=0 are left out.
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		typ, subtype, ok := strings.Cut(strings.ToLower(strings.TrimSpace(params[0])), "/")
+		if !ok || typ == "" || subtype == "" {
+			continue
+		}
+
+		mr := mediaRange{typ: typ, subtype: subtype, q: 1}
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					mr.q = q
+				}
+			}
+		}
+		if mr.q > 0 {
+			ranges = append(ranges, mr)
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return ranges[i].specificity() > ranges[j].specificity()
+	})
+	return ranges
+}
+
+// object is a JSON object that keeps its keys in document order, so that
+// columns and elements come out in the order of the struct fields.
+type object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// generic converts v to its JSON form: *object, []interface{}, string,
+// int64, float64, bool or nil.
+func generic(v interface{}) (interface{}, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	return decodeValue(dec)
+}
+
+func decodeValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '[' {
+			list := []interface{}{}
+			for dec.More() {
+				value, err := decodeValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, value)
+			}
+			_, err := dec.Token()
+			return list, err
+		}
+
+		obj := &object{values: map[string]interface{}{}}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj.keys = append(obj.keys, key.(string))
+			obj.values[key.(string)] = value
+		}
+		_, err := dec.Token()
+		return obj, err
+	case json.Number:
+		if n, err := tok.Int64(); err == nil {
+			return n, nil
+		}
+		return tok.Float64()
+	default:
+		return tok, nil
+	}
+}
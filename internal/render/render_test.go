@@ -0,0 +1,143 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestNegotiate(t *testing.T) {
+	r := NewRegistry(JSON, CSV, XML, MessagePack)
+
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"text/csv", "text/csv"},
+		{"text/*", "text/csv"},
+		{"TEXT/XML", "application/xml"},
+		{"application/json;q=0.5, application/xml", "application/xml"},
+		{"application/*;q=0.9, application/msgpack", "application/msgpack"},
+		{"text/html, */*;q=0.1", "application/json"},
+		{"text/csv;q=0, */*", "application/json"},
+	}
+	for _, tt := range tests {
+		enc, err := r.Negotiate(tt.accept)
+		if err != nil {
+			t.Errorf("Negotiate(%q): %v", tt.accept, err)
+			continue
+		}
+		if enc.MediaTypes[0] != tt.want {
+			t.Errorf("Negotiate(%q) = %s; want %s", tt.accept, enc.MediaTypes[0], tt.want)
+		}
+	}
+
+	for _, accept := range []string{"text/html", "image/png, text/plain", "application/json;q=0"} {
+		if _, err := r.Negotiate(accept); !errors.Is(err, ErrNotAcceptable) {
+			t.Errorf("Negotiate(%q) = %v; want ErrNotAcceptable", accept, err)
+		}
+	}
+}
+
+func TestAccepts(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"application/problem+json", true},
+		{"application/json, application/problem+json;q=0.5", true},
+		{"Application/Problem+JSON", true},
+		{"application/problem+json;q=0", false},
+		{"*/*", false},
+		{"application/*", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := Accepts(tt.accept, "application/problem+json"); got != tt.want {
+			t.Errorf("Accepts(%q) = %t; want %t", tt.accept, got, tt.want)
+		}
+	}
+}
+
+type movie struct {
+	ID     int64    `json:"id"`
+	Title  string   `json:"title"`
+	Genres []string `json:"genres,omitempty"`
+}
+
+var page = map[string]interface{}{
+	"movies": []movie{
+		{ID: 1, Title: "Casablanca", Genres: []string{"drama", "romance"}},
+		{ID: 2, Title: "Heat, the movie"},
+	},
+	"metadata": map[string]int{"total_records": 2},
+}
+
+func TestCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := CSV.Encode(&buf, page); err != nil {
+		t.Fatal(err)
+	}
+	want := "id,title,genres\n1,Casablanca,drama;romance\n2,\"Heat, the movie\",\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := CSV.Encode(&buf, map[string]interface{}{"error": map[string]string{"title": "must be provided"}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "error.title\nmust be provided\n"; buf.String() != want {
+		t.Errorf("got %q; want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := CSV.Encode(&buf, map[string]interface{}{"movies": []movie{}, "metadata": map[string]int{}}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("empty page encoded as %q; want no records", buf.String())
+	}
+}
+
+func TestXML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := XML.Encode(&buf, map[string]interface{}{"movie": movie{ID: 1, Title: "Tom & Jerry", Genres: []string{"comedy"}}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<response>",
+		"<id>1</id>",
+		"<title>Tom &amp; Jerry</title>",
+		"<genres>\n\t\t\t<item>comedy</item>\n\t\t</genres>",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output is missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestMessagePack(t *testing.T) {
+	var buf bytes.Buffer
+	if err := MessagePack.Encode(&buf, page); err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Movies []struct {
+			ID    int64  `msgpack:"id"`
+			Title string `msgpack:"title"`
+		} `msgpack:"movies"`
+	}
+	if err := msgpack.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Movies) != 2 || got.Movies[1].ID != 2 || got.Movies[1].Title != "Heat, the movie" {
+		t.Errorf("got %+v", got)
+	}
+}
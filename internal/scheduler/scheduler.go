@@ -0,0 +1,251 @@
+// Package scheduler runs periodic maintenance tasks on cron schedules.
+//
+// Every API instance runs a Scheduler, but only the one elected leader runs
+// tasks. The others keep track of the schedule and take over when the leader
+// goes away, so each task runs once per activation however many instances
+// there are.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Func is the work done by a task.
+type Func func(ctx context.Context) error
+
+// Elector decides which instance runs tasks. data.AdvisoryLock implements it.
+type Elector interface {
+	// TryAcquire reports whether this instance is the leader, becoming the
+	// leader if there is none.
+	TryAcquire(ctx context.Context) (bool, error)
+	// Release steps down as leader.
+	Release()
+}
+
+// Recorder stores the outcome of task runs so that every instance can report
+// them. data.ScheduledTaskModel implements it.
+type Recorder interface {
+	RecordRun(ctx context.Context, name string, startedAt time.Time, duration time.Duration, runErr error) error
+}
+
+// TaskStatus describes a registered task as this instance sees it.
+type TaskStatus struct {
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	NextRun  time.Time `json:"next_run"`
+	Running  bool      `json:"running"`
+}
+
+type task struct {
+	name     string
+	spec     string
+	schedule Schedule
+	fn       Func
+	next     time.Time
+	running  bool
+}
+
+// Scheduler runs registered tasks when their schedule is due.
+type Scheduler struct {
+	Elector  Elector
+	Recorder Recorder
+
+	// ElectionInterval is how often the leader checks that it still holds
+	// leadership and the other instances try to take it over. It defaults
+	// to 15 seconds.
+	ElectionInterval time.Duration
+	// Timeout bounds a single run of a task.
+	Timeout time.Duration
+
+	// OnError, if set, is called with failed runs and election errors.
+	OnError func(err error, properties map[string]string)
+	// OnRun, if set, is called after every successful run.
+	OnRun func(name string, duration time.Duration)
+
+	mu     sync.Mutex
+	tasks  []*task
+	leader bool
+}
+
+// Register adds a task that runs fn on the cron schedule spec. See Parse for
+// the accepted syntax. Tasks must be registered before Run is called.
+func (s *Scheduler) Register(name, spec string, fn Func) error {
+	schedule, err := Parse(spec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tasks {
+		if t.name == name {
+			return fmt.Errorf("scheduler: task %q registered twice", name)
+		}
+	}
+	s.tasks = append(s.tasks, &task{name: name, spec: spec, schedule: schedule, fn: fn})
+	return nil
+}
+
+// Leader reports whether this instance was the leader at the last election.
+func (s *Scheduler) Leader() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.leader
+}
+
+// Status returns the registered tasks ordered by name.
+func (s *Scheduler) Status() []TaskStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := make([]TaskStatus, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		status = append(status, TaskStatus{Name: t.name, Schedule: t.spec, NextRun: t.next, Running: t.running})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Name < status[j].Name })
+	return status
+}
+
+// Run runs tasks until ctx is cancelled, then waits for the running ones and
+// steps down as leader. Running tasks are cancelled with ctx.
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer s.Elector.Release()
+	defer wg.Wait()
+
+	now := time.Now()
+	s.mu.Lock()
+	for _, t := range s.tasks {
+		t.next = t.schedule.Next(now)
+	}
+	s.mu.Unlock()
+
+	interval := s.ElectionInterval
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+
+	s.elect(ctx)
+	nextElection := time.Now().Add(interval)
+
+	for {
+		timer := time.NewTimer(time.Until(s.wakeup(nextElection)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !time.Now().Before(nextElection) {
+			s.elect(ctx)
+			nextElection = time.Now().Add(interval)
+		}
+
+		for _, t := range s.due(time.Now()) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.run(ctx, t)
+			}()
+		}
+	}
+}
+
+func (s *Scheduler) elect(ctx context.Context) {
+	leader, err := s.Elector.TryAcquire(ctx)
+	if err != nil && ctx.Err() == nil {
+		s.report(err, map[string]string{"task": "scheduler election"})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leader = leader
+}
+
+// wakeup returns the earliest of the next task activation and the next
+// election.
+func (s *Scheduler) wakeup(nextElection time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at := nextElection
+	for _, t := range s.tasks {
+		if !t.next.IsZero() && t.next.Before(at) {
+			at = t.next
+		}
+	}
+	return at
+}
+
+// due advances the schedule of every task whose activation has passed and
+// returns the tasks this instance should run now. Activations are skipped
+// when this instance is not the leader or the previous run is still going.
+func (s *Scheduler) due(now time.Time) []*task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*task
+	for _, t := range s.tasks {
+		if t.next.IsZero() || now.Before(t.next) {
+			continue
+		}
+		t.next = t.schedule.Next(now)
+		if !s.leader || t.running {
+			continue
+		}
+		t.running = true
+		due = append(due, t)
+	}
+	return due
+}
+
+func (s *Scheduler) run(ctx context.Context, t *task) {
+	start := time.Now()
+	err := s.execute(ctx, t)
+	duration := time.Since(start)
+
+	s.mu.Lock()
+	t.running = false
+	s.mu.Unlock()
+
+	if err != nil {
+		s.report(err, map[string]string{"task": t.name})
+	} else if s.OnRun != nil {
+		s.OnRun(t.name, duration)
+	}
+
+	if s.Recorder != nil {
+		rctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if rerr := s.Recorder.RecordRun(rctx, t.name, start, duration, err); rerr != nil {
+			s.report(rerr, map[string]string{"task": t.name})
+		}
+	}
+}
+
+// execute calls the task, turning a panic into an error.
+func (s *Scheduler) execute(ctx context.Context, t *task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("scheduler: %s panicked: %v", t.name, r)
+		}
+	}()
+
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	return t.fn(ctx)
+}
+
+func (s *Scheduler) report(err error, properties map[string]string) {
+	if s.OnError != nil {
+		s.OnError(err, properties)
+	}
+}
@@ -0,0 +1,91 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeElector struct{ leader bool }
+
+func (e *fakeElector) TryAcquire(context.Context) (bool, error) { return e.leader, nil }
+func (e *fakeElector) Release()                                 {}
+
+type fakeRecorder struct {
+	mu   sync.Mutex
+	runs map[string]error
+}
+
+func (r *fakeRecorder) RecordRun(_ context.Context, name string, _ time.Time, _ time.Duration, runErr error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs[name] = runErr
+	return nil
+}
+
+func TestSchedulerRunsDueTasksOnlyOnLeader(t *testing.T) {
+	elector := &fakeElector{}
+	recorder := &fakeRecorder{runs: map[string]error{}}
+	s := &Scheduler{Elector: elector, Recorder: recorder}
+
+	failure := errors.New("boom")
+	if err := s.Register("ok", "* * * * *", func(context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register("fails", "* * * * *", func(context.Context) error { return failure }); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register("ok", "@hourly", nil); err == nil {
+		t.Error("registering a task twice succeeded")
+	}
+
+	now := time.Now()
+	for _, task := range s.tasks {
+		task.next = now
+	}
+
+	s.elect(context.Background())
+	if due := s.due(now); len(due) != 0 {
+		t.Fatalf("follower got %d due tasks; want none", len(due))
+	}
+	for _, status := range s.Status() {
+		if !status.NextRun.After(now) {
+			t.Errorf("%s: next run %v was not advanced", status.Name, status.NextRun)
+		}
+	}
+
+	elector.leader = true
+	s.elect(context.Background())
+	for _, task := range s.tasks {
+		task.next = now
+	}
+	due := s.due(now)
+	if len(due) != 2 {
+		t.Fatalf("leader got %d due tasks; want 2", len(due))
+	}
+
+	// A task that is still running is not started again.
+	for _, task := range s.tasks {
+		task.next = now
+	}
+	if again := s.due(now); len(again) != 0 {
+		t.Fatalf("got %d overlapping runs; want none", len(again))
+	}
+
+	for _, task := range due {
+		s.run(context.Background(), task)
+	}
+	if err, ok := recorder.runs["ok"]; !ok || err != nil {
+		t.Errorf("ok: recorded %v, %v", err, ok)
+	}
+	if err := recorder.runs["fails"]; !errors.Is(err, failure) {
+		t.Errorf("fails: recorded %v; want %v", err, failure)
+	}
+	for _, status := range s.Status() {
+		if status.Running {
+			t.Errorf("%s still marked running", status.Name)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Exporter sends finished spans somewhere they can be looked at. A Tracer
+// calls ExportSpans from a single goroutine.
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// JSONExporter writes each span as a line of JSON, for reading by eye or
+// by a log shipper.
+type JSONExporter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewJSONExporter returns a JSONExporter that writes to out, typically
+// os.Stdout.
+func NewJSONExporter(out io.Writer) *JSONExporter {
+	return &JSONExporter{out: out}
+}
+
+type jsonSpan struct {
+	Service      string         `json:"service,omitempty"`
+	Name         string         `json:"name"`
+	Kind         string         `json:"kind"`
+	TraceID      string         `json:"trace_id"`
+	SpanID       string         `json:"span_id"`
+	ParentSpanID string         `json:"parent_span_id,omitempty"`
+	Start        time.Time      `json:"start"`
+	DurationMS   float64        `json:"duration_ms"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Error        string         `json:"error,omitempty"`
+}
+
+func (e *JSONExporter) ExportSpans(_ context.Context, spans []SpanData) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, s := range spans {
+		js := jsonSpan{
+			Service:    s.Service,
+			Name:       s.Name,
+			Kind:       s.Kind.String(),
+			TraceID:    s.SpanContext.TraceID.String(),
+			SpanID:     s.SpanContext.SpanID.String(),
+			Start:      s.Start.UTC(),
+			DurationMS: float64(s.End.Sub(s.Start).Microseconds()) / 1000,
+			Attributes: s.Attributes,
+			Error:      s.Error,
+		}
+		if s.ParentSpanID != (SpanID{}) {
+			js.ParentSpanID = s.ParentSpanID.String()
+		}
+		if err := enc.Encode(js); err != nil {
+			return err
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.out.Write(buf.Bytes())
+	return err
+}
+
+func (e *JSONExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// OTLPExporter posts spans to an OpenTelemetry collector using OTLP over
+// HTTP with the JSON encoding.
+type OTLPExporter struct {
+	// Endpoint is the full URL spans are posted to, such as
+	// http://localhost:4318/v1/traces.
+	Endpoint string
+	// Headers are added to every request, for example to authenticate.
+	Headers map[string]string
+	// Client sends the requests. It defaults to a client with a ten second
+	// timeout.
+	Client *http.Client
+}
+
+var defaultOTLPClient = &http.Client{Timeout: 10 * time.Second}
+
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.Headers {
+		req.Header.Set(key, value)
+	}
+
+	client := e.Client
+	if client == nil {
+		client = defaultOTLPClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("trace: OTLP export to %s: %s", e.Endpoint, res.Status)
+	}
+	return nil
+}
+
+func (e *OTLPExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// The types below follow the JSON mapping of the OTLP protobuf messages:
+// IDs are hex, 64-bit integers are strings and enums are numbers.
+
+type otlpExport struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Message string `json:"message,omitempty"`
+	Code    int    `json:"code,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// otlpStatusError is STATUS_CODE_ERROR.
+const otlpStatusError = 2
+
+// otlpRequest groups spans by service, each service being an OTLP resource.
+func otlpRequest(spans []SpanData) otlpExport {
+	var export otlpExport
+	byService := make(map[string]int)
+	for _, s := range spans {
+		i, ok := byService[s.Service]
+		if !ok {
+			i = len(export.ResourceSpans)
+			byService[s.Service] = i
+			export.ResourceSpans = append(export.ResourceSpans, otlpResourceSpans{
+				Resource: otlpResource{Attributes: []otlpKeyValue{
+					{Key: "service.name", Value: otlpAnyValue(s.Service)},
+				}},
+				ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "greenlight"}}},
+			})
+		}
+
+		span := otlpSpan{
+			TraceID:           s.SpanContext.TraceID.String(),
+			SpanID:            s.SpanContext.SpanID.String(),
+			Name:              s.Name,
+			Kind:              int(s.Kind),
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+		}
+		if s.ParentSpanID != (SpanID{}) {
+			span.ParentSpanID = s.ParentSpanID.String()
+		}
+		if s.Error != "" {
+			span.Status = otlpStatus{Message: s.Error, Code: otlpStatusError}
+		}
+		scope := &export.ResourceSpans[i].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, span)
+	}
+	return export
+}
+
+func otlpAttributes(attributes map[string]any) []otlpKeyValue {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	kvs := make([]otlpKeyValue, 0, len(keys))
+	for _, key := range keys {
+		kvs = append(kvs, otlpKeyValue{Key: key, Value: otlpAnyValue(attributes[key])})
+	}
+	return kvs
+}
+
+func otlpAnyValue(v any) otlpValue {
+	switch v := v.(type) {
+	case string:
+		return otlpValue{StringValue: &v}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		return otlpInt(int64(v))
+	case int32:
+		return otlpInt(int64(v))
+	case int64:
+		return otlpInt(v)
+	case float32:
+		f := float64(v)
+		return otlpValue{DoubleValue: &f}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	case fmt.Stringer:
+		s := v.String()
+		return otlpValue{StringValue: &s}
+	default:
+		s := fmt.Sprint(v)
+		return otlpValue{StringValue: &s}
+	}
+}
+
+func otlpInt(n int64) otlpValue {
+	s := strconv.FormatInt(n, 10)
+	return otlpValue{IntValue: &s}
+}
@@ -0,0 +1,138 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSpan() SpanData {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	sc, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	return SpanData{
+		Service:      "greenlight",
+		Name:         "db.query movies.get",
+		Kind:         KindClient,
+		SpanContext:  sc,
+		ParentSpanID: SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		Start:        start,
+		End:          start.Add(1500 * time.Microsecond),
+		Attributes:   map[string]any{"db.system": "postgresql", "db.rows": int64(1)},
+		Error:        "sql: no rows in result set",
+	}
+}
+
+func TestJSONExporter(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewJSONExporter(&buf).ExportSpans(context.Background(), []SpanData{testSpan(), testSpan()}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("wrote %d lines; want 2", len(lines))
+	}
+	var got map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]any{
+		"name":           "db.query movies.get",
+		"kind":           "client",
+		"trace_id":       "4bf92f3577b34da6a3ce929d0e0e4736",
+		"parent_span_id": "0102030405060708",
+		"duration_ms":    1.5,
+		"error":          "sql: no rows in result set",
+	} {
+		if got[key] != want {
+			t.Errorf("%s = %v; want %v", key, got[key], want)
+		}
+	}
+}
+
+// TestOTLPExporter posts to a stand-in for an OpenTelemetry collector.
+func TestOTLPExporter(t *testing.T) {
+	var received otlpExport
+	var header http.Header
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		if r.URL.Path != "/v1/traces" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer collector.Close()
+
+	exp := &OTLPExporter{Endpoint: collector.URL + "/v1/traces", Headers: map[string]string{"Authorization": "Bearer x"}}
+	if err := exp.ExportSpans(context.Background(), []SpanData{testSpan()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := header.Get("Authorization"); got != "Bearer x" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if len(received.ResourceSpans) != 1 || len(received.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("received %+v", received)
+	}
+	if got := *received.ResourceSpans[0].Resource.Attributes[0].Value.StringValue; got != "greenlight" {
+		t.Errorf("service.name = %q", got)
+	}
+	span := received.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || span.ParentSpanID != "0102030405060708" || span.Kind != int(KindClient) {
+		t.Errorf("span = %+v", span)
+	}
+	if span.StartTimeUnixNano != "1767323045000000000" || span.Status.Code != otlpStatusError {
+		t.Errorf("span times or status = %+v", span)
+	}
+	if kv := span.Attributes[0]; kv.Key != "db.rows" || kv.Value.IntValue == nil || *kv.Value.IntValue != "1" {
+		t.Errorf("first attribute = %+v", kv)
+	}
+
+	exp.Endpoint = collector.URL + "/elsewhere"
+	if err := exp.ExportSpans(context.Background(), []SpanData{testSpan()}); err == nil {
+		t.Error("export to a 404 endpoint succeeded")
+	}
+}
+
+func TestTracerReportsExportErrors(t *testing.T) {
+	errc := make(chan error, 1)
+	tracer := NewTracer(failingExporter{}, Options{SampleRatio: 1, OnError: func(err error) { errc <- err }})
+	_, span := tracer.Start(context.Background(), "request", KindServer)
+	span.End()
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err.Error() != "collector down" {
+			t.Errorf("OnError got %v", err)
+		}
+	default:
+		t.Error("OnError was not called")
+	}
+	tracer.Shutdown(context.Background())
+}
+
+type failingExporter struct{}
+
+func (failingExporter) ExportSpans(context.Context, []SpanData) error {
+	return errors.New("collector down")
+}
+
+func (failingExporter) Shutdown(context.Context) error { return nil }
@@ -0,0 +1,63 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+)
+
+// Extract returns a copy of the request context that continues the trace
+// named by a valid traceparent header, if the request has one.
+func Extract(r *http.Request) context.Context {
+	sc, err := ParseTraceparent(r.Header.Get("traceparent"))
+	if err != nil {
+		return r.Context()
+	}
+	return ContextWithRemoteParent(r.Context(), sc)
+}
+
+// Inject sets the traceparent header of an outgoing request to the span ctx
+// carries, so that the receiving service continues the trace.
+func Inject(ctx context.Context, header http.Header) {
+	if span := SpanFromContext(ctx); span != nil {
+		header.Set("traceparent", span.SpanContext().Traceparent())
+	}
+}
+
+type middlewareSpans struct {
+	span, outer *Span
+}
+
+// Middleware wraps mw, an HTTP middleware, so that its own work is timed in
+// a span named name. The span ends when mw hands the request on, or returns
+// without doing so, and the rest of the chain runs under the outer span
+// again.
+func Middleware(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	// Each wrapper has its own key, so nested wrappers never mix up their
+	// spans.
+	key := &struct{ name string }{name}
+
+	return func(next http.Handler) http.Handler {
+		handOn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			spans, ok := r.Context().Value(key).(middlewareSpans)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			spans.span.End()
+			next.ServeHTTP(w, r.WithContext(ContextWithSpan(r.Context(), spans.outer)))
+		})
+		inner := mw(handOn)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			outer := SpanFromContext(r.Context())
+			ctx, span := Start(r.Context(), name, KindInternal)
+			if span == nil {
+				inner.ServeHTTP(w, r)
+				return
+			}
+			defer span.End()
+			ctx = context.WithValue(ctx, key, middlewareSpans{span: span, outer: outer})
+			inner.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,437 @@
+// Package trace records spans, the timed steps of a request, and hands them
+// to an Exporter. Trace context travels between services in the W3C
+// traceparent header.
+package trace
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TraceID identifies a trace, the tree of spans started by one request.
+type TraceID [16]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanID identifies a span within its trace.
+type SpanID [8]byte
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanContext is the part of a span that is passed on to its children,
+// within the process or, as a traceparent header, to other services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether neither ID is all zeroes.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats sc as a traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := 0
+	if sc.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+var errTraceparent = errors.New("trace: malformed traceparent")
+
+// ParseTraceparent parses a traceparent header value. Versions after 00 are
+// read as far as version 00 goes, as the specification asks.
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	if len(value) < 55 || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return sc, errTraceparent
+	}
+	version, ok := decodeHex(value[0:2])
+	if !ok || version[0] == 0xff || (version[0] == 0 && len(value) != 55) || (len(value) > 55 && value[55] != '-') {
+		return sc, errTraceparent
+	}
+	traceID, ok1 := decodeHex(value[3:35])
+	spanID, ok2 := decodeHex(value[36:52])
+	flags, ok3 := decodeHex(value[53:55])
+	if !ok1 || !ok2 || !ok3 {
+		return sc, errTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return SpanContext{}, errTraceparent
+	}
+	return sc, nil
+}
+
+// decodeHex decodes lowercase hex only, as traceparent requires.
+func decodeHex(s string) ([]byte, bool) {
+	if strings.ToLower(s) != s {
+		return nil, false
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+// Kind says what part a span plays, using the OTLP numbering.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+// SpanData is a finished span as handed to an Exporter.
+type SpanData struct {
+	Service      string
+	Name         string
+	Kind         Kind
+	SpanContext  SpanContext
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]any
+	// Error is the message of the error recorded on the span, if any.
+	Error string
+}
+
+// Span is a step in progress. The methods of a nil *Span do nothing, so code
+// can be instrumented without checking whether it is being traced.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanContext returns the IDs of s, or a zero SpanContext for a nil span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetAttribute records a property of the step, such as a route or a row
+// count. Values should be strings, booleans or numbers.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]any)
+	}
+	s.data.Attributes[key] = value
+}
+
+// RecordError marks the step as failed with err. A nil err is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End finishes the span and, if its trace is sampled, queues it for export.
+// Calls after the first do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.SpanContext.Sampled {
+		s.tracer.enqueue(data)
+	}
+}
+
+type contextKey string
+
+const (
+	spanContextKey   = contextKey("span")
+	remoteContextKey = contextKey("remote_parent")
+)
+
+// ContextWithSpan returns a copy of ctx that carries span, making it the
+// parent of spans started from the copy.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey, span)
+}
+
+// SpanFromContext returns the span ctx carries, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey).(*Span)
+	return span
+}
+
+// ContextWithRemoteParent returns a copy of ctx whose next span started by a
+// Tracer continues the trace of sc, as read from an incoming traceparent.
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteContextKey, sc)
+}
+
+// Start starts a child of the span ctx carries. Without one, the work is
+// not being traced and Start returns ctx and a nil span.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.start(ctx, name, kind, parent.data.SpanContext)
+}
+
+// Options configure a Tracer.
+type Options struct {
+	// Service names the process in exported spans.
+	Service string
+	// SampleRatio is the share of new traces recorded, from 0 to 1. Traces
+	// continued from a traceparent follow its sampled flag instead.
+	SampleRatio float64
+	// QueueSize is the number of finished spans held for export before new
+	// ones are dropped. It defaults to 2048.
+	QueueSize int
+	// BatchSize is the largest number of spans exported at once. It defaults
+	// to 512.
+	BatchSize int
+	// FlushInterval is the longest a finished span waits for export. It
+	// defaults to five seconds.
+	FlushInterval time.Duration
+	// OnError, if set, is called with every export error.
+	OnError func(error)
+}
+
+// Tracer starts root spans and exports finished spans in batches from a
+// background goroutine, so that a slow exporter never holds up a request.
+// The methods of a nil *Tracer do nothing.
+type Tracer struct {
+	exporter Exporter
+	opts     Options
+	queue    chan SpanData
+	flush    chan chan struct{}
+	done     chan struct{}
+	dropped  atomic.Int64
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewTracer returns a Tracer that hands finished spans to exporter.
+func NewTracer(exporter Exporter, opts Options) *Tracer {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 2048
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 512
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+	t := &Tracer{
+		exporter: exporter,
+		opts:     opts,
+		queue:    make(chan SpanData, opts.QueueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start starts a span as a child of the span ctx carries or, failing that,
+// of the remote parent set by ContextWithRemoteParent. Otherwise it starts a
+// new trace, sampled at the configured ratio.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		return t.start(ctx, name, kind, parent.data.SpanContext)
+	}
+	if remote, ok := ctx.Value(remoteContextKey).(SpanContext); ok && remote.IsValid() {
+		return t.start(ctx, name, kind, remote)
+	}
+
+	var sc SpanContext
+	binary.BigEndian.PutUint64(sc.TraceID[:8], nonZeroUint64())
+	binary.BigEndian.PutUint64(sc.TraceID[8:], rand.Uint64())
+	sc.Sampled = t.opts.SampleRatio >= 1 || rand.Float64() < t.opts.SampleRatio
+	return t.start(ctx, name, kind, sc)
+}
+
+func (t *Tracer) start(ctx context.Context, name string, kind Kind, parentSC SpanContext) (context.Context, *Span) {
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			Service: t.opts.Service,
+			Name:    name,
+			Kind:    kind,
+			SpanContext: SpanContext{
+				TraceID: parentSC.TraceID,
+				Sampled: parentSC.Sampled,
+			},
+			ParentSpanID: parentSC.SpanID,
+			Start:        time.Now(),
+		},
+	}
+	binary.BigEndian.PutUint64(span.data.SpanContext.SpanID[:], nonZeroUint64())
+	return ContextWithSpan(ctx, span), span
+}
+
+func nonZeroUint64() uint64 {
+	for {
+		if n := rand.Uint64(); n != 0 {
+			return n
+		}
+	}
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		t.dropped.Add(1)
+		return
+	}
+	select {
+	case t.queue <- data:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of finished spans dropped because the export
+// queue was full.
+func (t *Tracer) Dropped() int64 {
+	if t == nil {
+		return 0
+	}
+	return t.dropped.Load()
+}
+
+// Flush exports the spans finished so far, returning early if ctx ends.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.RLock()
+	if t.closed {
+		t.mu.RUnlock()
+		return nil
+	}
+	flushed := make(chan struct{})
+	select {
+	case t.flush <- flushed:
+	case <-ctx.Done():
+		t.mu.RUnlock()
+		return ctx.Err()
+	}
+	t.mu.RUnlock()
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports the spans still queued and shuts the exporter down.
+// Spans that end afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, t.opts.BatchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := t.exporter.ExportSpans(ctx, batch)
+		cancel()
+		if err != nil && t.opts.OnError != nil {
+			t.opts.OnError(err)
+		}
+		batch = make([]SpanData, 0, t.opts.BatchSize)
+	}
+
+	for {
+		select {
+		case data, ok := <-t.queue:
+			if !ok {
+				export()
+				return
+			}
+			batch = append(batch, data)
+			if len(batch) >= t.opts.BatchSize {
+				export()
+			}
+		case flushed := <-t.flush:
+			for n := len(t.queue); n > 0; n-- {
+				batch = append(batch, <-t.queue)
+			}
+			export()
+			close(flushed)
+		case <-ticker.C:
+			export()
+		}
+	}
+}
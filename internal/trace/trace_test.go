@@ -0,0 +1,184 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *recordingExporter) ExportSpans(_ context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *recordingExporter) Shutdown(context.Context) error { return nil }
+
+func (e *recordingExporter) byName() map[string]SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	spans := make(map[string]SpanData, len(e.spans))
+	for _, s := range e.spans {
+		spans[s.Name] = s
+	}
+	return spans
+}
+
+func TestParseTraceparent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.Sampled || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("ParseTraceparent(%q) = %+v", valid, sc)
+	}
+	if got := sc.Traceparent(); got != valid {
+		t.Errorf("Traceparent() = %q; want %q", got, valid)
+	}
+
+	// A later version may add fields after a dash.
+	if _, err := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra"); err != nil {
+		t.Errorf("future version rejected: %v", err)
+	}
+
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz",
+	} {
+		if _, err := ParseTraceparent(value); err == nil {
+			t.Errorf("ParseTraceparent(%q) succeeded", value)
+		}
+	}
+}
+
+func TestSpans(t *testing.T) {
+	exp := &recordingExporter{}
+	tracer := NewTracer(exp, Options{Service: "api", SampleRatio: 1})
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := ContextWithRemoteParent(context.Background(), remote)
+	ctx, root := tracer.Start(ctx, "request", KindServer)
+	_, child := Start(ctx, "query", KindClient)
+	child.SetAttribute("rows", 3)
+	child.RecordError(errors.New("boom"))
+	child.End()
+	child.End()
+	root.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	spans := exp.byName()
+	if len(exp.spans) != 2 {
+		t.Fatalf("exported %d spans; want 2", len(exp.spans))
+	}
+	if spans["request"].SpanContext.TraceID != remote.TraceID || spans["request"].ParentSpanID != remote.SpanID {
+		t.Errorf("request span does not continue the remote trace: %+v", spans["request"])
+	}
+	q := spans["query"]
+	if q.ParentSpanID != spans["request"].SpanContext.SpanID || q.SpanContext.TraceID != remote.TraceID {
+		t.Errorf("query span is not a child of the request span: %+v", q)
+	}
+	if q.Attributes["rows"] != 3 || q.Error != "boom" || q.Kind != KindClient || q.Service != "api" {
+		t.Errorf("query span = %+v", q)
+	}
+}
+
+func TestUnsampledAndUntraced(t *testing.T) {
+	exp := &recordingExporter{}
+	tracer := NewTracer(exp, Options{SampleRatio: 0})
+
+	ctx, root := tracer.Start(context.Background(), "request", KindServer)
+	_, child := Start(ctx, "query", KindClient)
+	if child == nil || child.SpanContext().TraceID != root.SpanContext().TraceID {
+		t.Error("unsampled trace did not propagate to its child")
+	}
+	child.End()
+	root.End()
+
+	// Without a span in the context, Start does nothing.
+	if _, span := Start(context.Background(), "query", KindClient); span != nil {
+		t.Error("Start without a parent returned a span")
+	}
+	var nilTracer *Tracer
+	if _, span := nilTracer.Start(context.Background(), "request", KindServer); span != nil {
+		t.Error("nil Tracer returned a span")
+	}
+
+	tracer.Shutdown(context.Background())
+	if len(exp.spans) != 0 {
+		t.Errorf("exported %d unsampled spans", len(exp.spans))
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	exp := &recordingExporter{}
+	tracer := NewTracer(exp, Options{SampleRatio: 1})
+
+	var handlerParent SpanID
+	auth := Middleware("authenticate", func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, span := Start(r.Context(), "lookup", KindInternal)
+			span.End()
+			next.ServeHTTP(w, r)
+		})
+	})
+	handler := auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "handler", KindInternal)
+		handlerParent = span.data.ParentSpanID
+		span.End()
+	}))
+
+	ctx, root := tracer.Start(context.Background(), "request", KindServer)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	root.End()
+	tracer.Shutdown(context.Background())
+
+	spans := exp.byName()
+	if got := spans["authenticate"].ParentSpanID; got != root.SpanContext().SpanID {
+		t.Errorf("middleware span parent = %s; want the request span", got)
+	}
+	if got := spans["lookup"].ParentSpanID; got != spans["authenticate"].SpanContext.SpanID {
+		t.Errorf("lookup span parent = %s; want the middleware span", got)
+	}
+	if handlerParent != root.SpanContext().SpanID {
+		t.Errorf("handler span parent = %s; want the request span", handlerParent)
+	}
+	if spans["authenticate"].End.After(spans["handler"].Start) {
+		t.Error("middleware span did not end before the handler started")
+	}
+}
+
+func TestInject(t *testing.T) {
+	tracer := NewTracer(&recordingExporter{}, Options{SampleRatio: 1})
+	defer tracer.Shutdown(context.Background())
+
+	ctx, span := tracer.Start(context.Background(), "delivery", KindClient)
+	header := http.Header{}
+	Inject(ctx, header)
+	if got, want := header.Get("traceparent"), span.SpanContext().Traceparent(); got != want {
+		t.Errorf("traceparent = %q; want %q", got, want)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header = header
+	_, continued := tracer.Start(Extract(r), "request", KindServer)
+	if continued.SpanContext().TraceID != span.SpanContext().TraceID {
+		t.Error("Extract did not continue the injected trace")
+	}
+}
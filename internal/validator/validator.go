@@ -2,8 +2,27 @@ package validator
 
 import "regexp"
 
+// Codes identify the kind of each validation failure, so that clients can
+// act on a failure without matching its wording. They are part of the API
+// and must not change once published.
+const (
+	CodeRequired      = "required"
+	CodeAlreadyExists = "already_exists"
+	CodeDuplicate     = "duplicate"
+	CodeTooShort      = "too_short"
+	CodeTooLong       = "too_long"
+	CodeWrongLength   = "wrong_length"
+	CodeTooSmall      = "too_small"
+	CodeTooLarge      = "too_large"
+	CodeInvalidFormat = "invalid_format"
+	CodeNotAllowed    = "not_allowed"
+	CodeInvalid       = "invalid"
+)
+
 type Validator struct {
 	Errors map[string]string
+	// Codes holds the code of each error in Errors, under the same key.
+	Codes map[string]string
 }
 
 var (
@@ -13,6 +32,7 @@ var (
 func New() *Validator {
 	return &Validator{
 		Errors: make(map[string]string),
+		Codes:  make(map[string]string),
 	}
 }
 
@@ -20,9 +40,17 @@ func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
+// AddError records message for key with the generic CodeInvalid. Use
+// AddErrorCode for failures that clients may need to tell apart.
 func (v *Validator) AddError(key string, message string) {
+	v.AddErrorCode(key, CodeInvalid, message)
+}
+
+// AddErrorCode records message and code for key, unless key already failed.
+func (v *Validator) AddErrorCode(key, code, message string) {
 	if _, exists := v.Errors[key]; !exists {
 		v.Errors[key] = message
+		v.Codes[key] = code
 	}
 }
 
@@ -32,6 +60,21 @@ func (v *Validator) Check(ok bool, key, message string) {
 	}
 }
 
+// CheckCode is like Check but records code with the message.
+func (v *Validator) CheckCode(ok bool, key, code, message string) {
+	if !ok {
+		v.AddErrorCode(key, code, message)
+	}
+}
+
+// Code returns the code recorded for key, or CodeInvalid if there is none.
+func (v *Validator) Code(key string) string {
+	if code, ok := v.Codes[key]; ok {
+		return code
+	}
+	return CodeInvalid
+}
+
 func In(value string, list ...string) bool {
 	for i := range list {
 		if value == list[i] {
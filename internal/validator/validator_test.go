@@ -0,0 +1,30 @@
+package validator
+
+import "testing"
+
+func TestCheckCode(t *testing.T) {
+	v := New()
+	v.CheckCode(true, "title", CodeRequired, "must be provided")
+	v.CheckCode(false, "year", CodeTooSmall, "must be greater than or equal to 1888")
+	v.CheckCode(false, "year", CodeRequired, "must be provided")
+	v.Check(false, "sort", "invalid sort value")
+
+	if v.Valid() {
+		t.Fatal("expected the validator to have failed")
+	}
+	tests := []struct {
+		key, code, message string
+	}{
+		{"title", CodeInvalid, ""},
+		{"year", CodeTooSmall, "must be greater than or equal to 1888"},
+		{"sort", CodeInvalid, "invalid sort value"},
+	}
+	for _, tt := range tests {
+		if got := v.Code(tt.key); got != tt.code {
+			t.Errorf("Code(%q) = %q; want %q", tt.key, got, tt.code)
+		}
+		if got := v.Errors[tt.key]; got != tt.message {
+			t.Errorf("Errors[%q] = %q; want %q", tt.key, got, tt.message)
+		}
+	}
+}
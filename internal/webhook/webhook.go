@@ -0,0 +1,240 @@
+// Package webhook delivers catalogue events to partner endpoints.
+//
+// Deliveries are queued in the database by the transaction that changes a
+// movie. A Worker claims due deliveries, POSTs them with an HMAC-SHA256
+// signature and records the outcome, retrying failures with exponential
+// backoff until they are delivered or dead-lettered.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"greenlight.samedarslan28.net/internal/data"
+)
+
+// Headers sent with every delivery.
+const (
+	SignatureHeader = "X-Greenlight-Signature"
+	EventHeader     = "X-Greenlight-Event"
+	DeliveryHeader  = "X-Greenlight-Delivery"
+)
+
+// Sign returns the signature header value for body sent at t. The signed
+// message is "<unix seconds>.<body>", so receivers can reject replays of old
+// deliveries by checking the timestamp.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + signature(secret, ts, body)
+}
+
+func signature(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature header produced by Sign and rejects signatures
+// older than tolerance. It is what a receiver written in Go would run.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			sig = value
+		}
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return errors.New("webhook: malformed signature header")
+	}
+	if now.Sub(time.Unix(unix, 0)).Abs() > tolerance {
+		return errors.New("webhook: signature timestamp outside tolerance")
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, ts, body))) {
+		return errors.New("webhook: signature mismatch")
+	}
+	return nil
+}
+
+// Backoff returns how long to wait before retrying after the given attempt:
+// 30s, 1m, 2m, ... doubling up to a maximum of six hours.
+func Backoff(attempt int) time.Duration {
+	const base, max = 30 * time.Second, 6 * time.Hour
+	if attempt < 1 {
+		attempt = 1
+	}
+	if attempt > 20 {
+		return max
+	}
+	return min(base<<(attempt-1), max)
+}
+
+// Store is the queue the worker takes deliveries from. data.WebhookModel
+// implements it.
+type Store interface {
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*data.WebhookDelivery, error)
+	RecordAttempt(ctx context.Context, d *data.WebhookDelivery, status int, attemptErr error, retryAt time.Time) error
+}
+
+// Worker delivers queued webhooks.
+type Worker struct {
+	Store  Store
+	Client *http.Client
+
+	// Concurrency is the number of deliveries sent at once.
+	Concurrency int
+	// PollInterval is how long the worker waits when the queue is empty.
+	PollInterval time.Duration
+	// Timeout bounds a single delivery request.
+	Timeout time.Duration
+	// MaxAttempts is the number of attempts before a delivery is dead.
+	MaxAttempts int
+
+	// OnError, if set, is called with delivery and queue errors.
+	OnError func(err error, properties map[string]string)
+}
+
+// Run delivers webhooks until ctx is cancelled. Deliveries in flight when
+// that happens are allowed to finish, so Run may return up to Timeout later.
+func (w *Worker) Run(ctx context.Context) {
+	sem := make(chan struct{}, max(w.Concurrency, 1))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		// Only claim as many deliveries as can be sent straight away, so
+		// none of them sit out their lease in this process.
+		free := cap(sem) - len(sem)
+		var deliveries []*data.WebhookDelivery
+		if free > 0 {
+			var err error
+			deliveries, err = w.Store.ClaimDeliveries(ctx, free, w.lease())
+			if err != nil && ctx.Err() == nil {
+				w.report(err, nil)
+			}
+		}
+
+		for _, d := range deliveries {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				w.deliver(d)
+			}()
+		}
+
+		if len(deliveries) == free && free > 0 {
+			continue // there may be more waiting
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.PollInterval):
+		}
+	}
+}
+
+// lease is how long a claimed delivery is hidden from other workers. It
+// covers the request plus a margin for recording the outcome.
+func (w *Worker) lease() time.Duration {
+	return w.Timeout + time.Minute
+}
+
+// deliver sends d and records the outcome. It deliberately ignores the
+// worker's context, so that a shutdown does not cut deliveries off halfway.
+func (w *Worker) deliver(d *data.WebhookDelivery) {
+	status, err := w.send(d)
+
+	var retryAt time.Time
+	if err != nil && d.Attempts < w.MaxAttempts {
+		retryAt = time.Now().Add(Backoff(d.Attempts))
+	}
+
+	if err != nil {
+		properties := map[string]string{
+			"delivery_id":     strconv.FormatInt(d.ID, 10),
+			"subscription_id": strconv.FormatInt(d.SubscriptionID, 10),
+			"attempt":         strconv.Itoa(d.Attempts),
+		}
+		if retryAt.IsZero() {
+			properties["dead"] = "true"
+		}
+		w.report(err, properties)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rerr := w.Store.RecordAttempt(ctx, d, status, err, retryAt)
+	if errors.Is(rerr, data.ErrEditConflict) {
+		// The lease ran out and the delivery was claimed again, or it was
+		// replayed; the newer attempt records its own outcome.
+		rerr = fmt.Errorf("webhook: delivery was claimed again before its outcome was recorded: %w", rerr)
+	}
+	if rerr != nil {
+		w.report(rerr, map[string]string{
+			"delivery_id": strconv.FormatInt(d.ID, 10),
+			"attempt":     strconv.Itoa(d.Attempts),
+		})
+	}
+}
+
+// send POSTs the delivery and returns the response status. Any status other
+// than 2xx is an error.
+func (w *Worker) send(d *data.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), w.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "greenlight-webhooks/1")
+	req.Header.Set(EventHeader, d.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(d.Secret, time.Now(), d.Payload))
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhook: receiver responded %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+func (w *Worker) report(err error, properties map[string]string) {
+	if w.OnError != nil {
+		w.OnError(err, properties)
+	}
+}
@@ -0,0 +1,158 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"greenlight.samedarslan28.net/internal/data"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"event":"movie.created"}`)
+	now := time.Unix(1_700_000_000, 0)
+	header := Sign("topsecretsecretkey", now, body)
+
+	if err := Verify("topsecretsecretkey", header, body, 5*time.Minute, now.Add(time.Minute)); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+	if err := Verify("othersecretsecretkey", header, body, 5*time.Minute, now); err == nil {
+		t.Fatal("signature with the wrong secret accepted")
+	}
+	if err := Verify("topsecretsecretkey", header, []byte(`{}`), 5*time.Minute, now); err == nil {
+		t.Fatal("signature over a different body accepted")
+	}
+	if err := Verify("topsecretsecretkey", header, body, 5*time.Minute, now.Add(time.Hour)); err == nil {
+		t.Fatal("stale signature accepted")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:   30 * time.Second,
+		2:   time.Minute,
+		5:   8 * time.Minute,
+		20:  6 * time.Hour,
+		100: 6 * time.Hour,
+	}
+	for attempt, want := range tests {
+		if got := Backoff(attempt); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+type attempt struct {
+	id      int64
+	status  int
+	failed  bool
+	retryAt time.Time
+}
+
+// fakeStore hands out its deliveries once and records the attempts.
+type fakeStore struct {
+	mu         sync.Mutex
+	deliveries []*data.WebhookDelivery
+	attempts   chan attempt
+}
+
+func (s *fakeStore) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*data.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := min(limit, len(s.deliveries))
+	claimed := s.deliveries[:n]
+	s.deliveries = s.deliveries[n:]
+	for _, d := range claimed {
+		d.Attempts++
+	}
+	return claimed, nil
+}
+
+func (s *fakeStore) RecordAttempt(ctx context.Context, d *data.WebhookDelivery, status int, attemptErr error, retryAt time.Time) error {
+	s.attempts <- attempt{id: d.ID, status: status, failed: attemptErr != nil, retryAt: retryAt}
+	return nil
+}
+
+func TestWorkerDeliversSignedRequests(t *testing.T) {
+	const secret = "receiver-secret-0123"
+	received := make(chan *http.Request, 2)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := Verify(secret, r.Header.Get(SignatureHeader), body, time.Minute, time.Now()); err != nil {
+			t.Errorf("receiver: %v", err)
+		}
+		received <- r
+		if r.Header.Get(DeliveryHeader) == "2" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+
+	payload := []byte(`{"event":"movie.updated","movie":{"id":1,"version":2}}`)
+	store := &fakeStore{
+		attempts: make(chan attempt, 2),
+		deliveries: []*data.WebhookDelivery{
+			{ID: 1, Event: "movie.updated", Payload: payload, URL: receiver.URL, Secret: secret},
+			{ID: 2, Event: "movie.updated", Payload: payload, URL: receiver.URL, Secret: secret, Attempts: 4},
+		},
+	}
+
+	w := &Worker{Store: store, Concurrency: 2, PollInterval: 10 * time.Millisecond, Timeout: time.Second, MaxAttempts: 5}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	results := map[int64]attempt{}
+	for len(results) < 2 {
+		select {
+		case a := <-store.attempts:
+			results[a.id] = a
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for deliveries")
+		}
+	}
+	cancel()
+	<-done
+
+	if r := <-received; r.Header.Get(EventHeader) != "movie.updated" {
+		t.Errorf("event header = %q", r.Header.Get(EventHeader))
+	}
+	if a := results[1]; a.failed || a.status != http.StatusOK {
+		t.Errorf("delivery 1: %+v, want delivered with 200", a)
+	}
+	// Delivery 2 failed on its fifth and final attempt, so it is dead.
+	if a := results[2]; !a.failed || a.status != http.StatusInternalServerError || !a.retryAt.IsZero() {
+		t.Errorf("delivery 2: %+v, want dead-lettered after a 500", a)
+	}
+}
+
+func TestWorkerSchedulesRetry(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	store := &fakeStore{
+		attempts:   make(chan attempt, 1),
+		deliveries: []*data.WebhookDelivery{{ID: 7, URL: receiver.URL, Secret: "s", Payload: []byte(`{}`)}},
+	}
+	w := &Worker{Store: store, Concurrency: 1, PollInterval: 10 * time.Millisecond, Timeout: time.Second, MaxAttempts: 5}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	before := time.Now()
+	a := <-store.attempts
+	if !a.failed || a.retryAt.Before(before.Add(Backoff(1))) {
+		t.Fatalf("attempt %+v, want a retry about %v from now", a, Backoff(1))
+	}
+}
@@ -0,0 +1,42 @@
+-- movie_statistics computes the catalogue aggregates for the movies matching
+-- the same title and genre filters as the movie listing. An empty title and
+-- genre list select the whole catalogue.
+CREATE OR REPLACE FUNCTION movie_statistics(filter_title text, filter_genres text[])
+    RETURNS TABLE (
+        total              bigint,
+        average_runtime    double precision,
+        median_runtime     double precision,
+        by_genre           jsonb,
+        by_decade          jsonb,
+        additions_by_month jsonb
+    )
+    LANGUAGE sql STABLE AS
+$$
+WITH m AS (
+    SELECT * FROM movies
+    WHERE (to_tsvector('simple', movies.title) @@ plainto_tsquery('simple', filter_title) OR filter_title = '')
+      AND (movies.genres @> filter_genres OR filter_genres = '{}')
+)
+SELECT
+    (SELECT count(*) FROM m),
+    (SELECT coalesce(avg(m.runtime), 0)::double precision FROM m),
+    (SELECT coalesce(percentile_cont(0.5) WITHIN GROUP (ORDER BY m.runtime), 0) FROM m),
+    (SELECT coalesce(jsonb_object_agg(g.genre, g.n), '{}')
+     FROM (SELECT genre, count(*) AS n FROM m, unnest(m.genres) AS genre GROUP BY genre) g),
+    (SELECT coalesce(jsonb_object_agg(d.decade, d.n), '{}')
+     FROM (SELECT (m.year / 10 * 10)::text || 's' AS decade, count(*) AS n FROM m GROUP BY 1) d),
+    (SELECT coalesce(jsonb_object_agg(a.month, a.n), '{}')
+     FROM (SELECT to_char(date_trunc('month', m.created_at), 'YYYY-MM') AS month, count(*) AS n
+           FROM m
+           WHERE m.created_at >= date_trunc('month', now()) - interval '11 months'
+           GROUP BY 1) a)
+$$;
+
+-- movie_stats holds the unfiltered aggregates. It has a single row and is
+-- refreshed periodically by the API, so refreshed_at says how stale it is.
+CREATE MATERIALIZED VIEW IF NOT EXISTS movie_stats AS
+SELECT now() AS refreshed_at, s.*
+FROM movie_statistics('', '{}') s;
+
+-- REFRESH ... CONCURRENTLY needs a unique index.
+CREATE UNIQUE INDEX IF NOT EXISTS movie_stats_refreshed_at_idx ON movie_stats (refreshed_at);
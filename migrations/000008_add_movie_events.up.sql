@@ -0,0 +1,31 @@
+-- Every change to a movie is announced on the movie_events channel. The IDs
+-- come from a sequence so that all API instances agree on them, which lets
+-- streaming clients resume on any instance with Last-Event-ID.
+CREATE SEQUENCE IF NOT EXISTS movie_events_id_seq;
+
+CREATE OR REPLACE FUNCTION notify_movie_change() RETURNS trigger
+    LANGUAGE plpgsql AS
+$$
+DECLARE
+    movie movies;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        movie := OLD;
+    ELSE
+        movie := NEW;
+    END IF;
+
+    PERFORM pg_notify('movie_events', json_build_object(
+        'id', nextval('movie_events_id_seq'),
+        'type', CASE TG_OP WHEN 'INSERT' THEN 'created' WHEN 'UPDATE' THEN 'updated' ELSE 'deleted' END,
+        'movie_id', movie.id,
+        'version', movie.version
+    )::text);
+    RETURN NULL;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS movies_notify_change ON movies;
+CREATE TRIGGER movies_notify_change
+    AFTER INSERT OR UPDATE OR DELETE ON movies
+    FOR EACH ROW EXECUTE FUNCTION notify_movie_change();
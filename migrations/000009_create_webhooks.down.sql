@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'webhooks:manage';
+DROP TRIGGER IF EXISTS movies_enqueue_webhooks ON movies;
+DROP FUNCTION IF EXISTS enqueue_movie_webhooks();
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_subscriptions;
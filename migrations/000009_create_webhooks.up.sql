@@ -0,0 +1,72 @@
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id    bigint REFERENCES users ON DELETE SET NULL,
+    url        text NOT NULL,
+    events     text[] NOT NULL DEFAULT '{}',
+    secret     text NOT NULL,
+    active     boolean NOT NULL DEFAULT true,
+    version    integer NOT NULL DEFAULT 1
+);
+
+-- Deliveries double as the delivery log. Pending deliveries are due once
+-- next_attempt_at has passed; a worker that claims one pushes next_attempt_at
+-- forward by its lease, so a delivery abandoned by a crashed worker is simply
+-- picked up again later.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id              bigserial PRIMARY KEY,
+    created_at      timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    subscription_id bigint NOT NULL REFERENCES webhook_subscriptions ON DELETE CASCADE,
+    event           text NOT NULL,
+    payload         jsonb NOT NULL,
+    status          text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'dead')),
+    attempts        integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    last_attempt_at timestamp with time zone,
+    last_status     integer,
+    last_error      text
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx
+    ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription_idx
+    ON webhook_deliveries (subscription_id, id);
+
+-- Deliveries are queued by the same transaction that changes the movie, so a
+-- change can never be committed without its webhooks or the other way round.
+CREATE OR REPLACE FUNCTION enqueue_movie_webhooks() RETURNS trigger
+    LANGUAGE plpgsql AS
+$$
+DECLARE
+    movie movies;
+    event text;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        movie := OLD;
+    ELSE
+        movie := NEW;
+    END IF;
+    event := 'movie.' || CASE TG_OP WHEN 'INSERT' THEN 'created' WHEN 'UPDATE' THEN 'updated' ELSE 'deleted' END;
+
+    INSERT INTO webhook_deliveries (subscription_id, event, payload)
+    SELECT id, event, jsonb_build_object(
+        'event', event,
+        'occurred_at', now(),
+        'movie', jsonb_build_object('id', movie.id, 'version', movie.version)
+    )
+    FROM webhook_subscriptions
+    WHERE active AND (events = '{}' OR event = ANY (events));
+
+    RETURN NULL;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS movies_enqueue_webhooks ON movies;
+CREATE TRIGGER movies_enqueue_webhooks
+    AFTER INSERT OR UPDATE OR DELETE ON movies
+    FOR EACH ROW EXECUTE FUNCTION enqueue_movie_webhooks();
+
+INSERT INTO permissions (code)
+SELECT 'webhooks:manage'
+WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE code = 'webhooks:manage');
@@ -0,0 +1,24 @@
+-- jobs is a durable work queue. A queued job is due once run_at has passed.
+-- Claiming a job marks it running until locked_until; a running job whose
+-- lock has expired belonged to a worker that died and is due again.
+CREATE TABLE IF NOT EXISTS jobs (
+    id           bigserial PRIMARY KEY,
+    created_at   timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    kind         text NOT NULL,
+    payload      jsonb NOT NULL DEFAULT '{}',
+    status       text NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'done', 'dead')),
+    attempts     integer NOT NULL DEFAULT 0,
+    max_attempts integer NOT NULL DEFAULT 5 CHECK (max_attempts > 0),
+    run_at       timestamp with time zone NOT NULL DEFAULT NOW(),
+    locked_until timestamp with time zone,
+    last_error   text,
+    finished_at  timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS jobs_due_idx ON jobs (run_at) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS jobs_running_idx ON jobs (locked_until) WHERE status = 'running';
+CREATE INDEX IF NOT EXISTS jobs_status_idx ON jobs (status, id);
+
+INSERT INTO permissions (code)
+SELECT 'jobs:manage'
+WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE code = 'jobs:manage');
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'ops:view';
+DROP INDEX IF EXISTS tokens_expiry_idx;
+DROP TABLE IF EXISTS scheduled_tasks;
@@ -0,0 +1,19 @@
+-- scheduled_tasks keeps the outcome of the latest run of every scheduler
+-- task. Only the instance holding the scheduler's advisory lock runs tasks,
+-- so this table is how the other instances learn what happened.
+CREATE TABLE IF NOT EXISTS scheduled_tasks (
+    name            text PRIMARY KEY,
+    last_started_at timestamp with time zone NOT NULL,
+    last_duration   interval NOT NULL,
+    last_error      text,
+    last_success_at timestamp with time zone,
+    runs            bigint NOT NULL DEFAULT 0,
+    failures        bigint NOT NULL DEFAULT 0
+);
+
+-- Expired tokens are purged by the scheduler.
+CREATE INDEX IF NOT EXISTS tokens_expiry_idx ON tokens (expiry);
+
+INSERT INTO permissions (code)
+SELECT 'ops:view'
+WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE code = 'ops:view');
@@ -0,0 +1,4 @@
+-- request_id is the ID of the API request that queued the job, so that the
+-- job's log lines can be matched with it. Jobs queued outside a request,
+-- and those queued before this column existed, have an empty ID.
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS request_id text NOT NULL DEFAULT '';
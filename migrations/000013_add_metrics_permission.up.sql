@@ -0,0 +1,3 @@
+INSERT INTO permissions (code)
+SELECT 'metrics:view'
+WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE code = 'metrics:view');
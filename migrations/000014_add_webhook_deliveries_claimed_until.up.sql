@@ -0,0 +1,4 @@
+-- claimed_until is set while a worker holds a delivery and cleared when it
+-- records the outcome, so that a replay cannot queue a delivery that is
+-- still being sent. It matches the lease in next_attempt_at.
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS claimed_until timestamp with time zone;
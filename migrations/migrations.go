@@ -0,0 +1,11 @@
+// Package migrations embeds the SQL migration files so that the API binary
+// can apply them without the external migrate tool.
+package migrations
+
+import "embed"
+
+// FS holds every NNNNNN_name.up.sql and NNNNNN_name.down.sql file in this
+// directory.
+//
+//go:embed *.sql
+var FS embed.FS